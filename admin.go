@@ -0,0 +1,473 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+)
+
+var (
+	// ErrNamespacesNotSupported is returned when an operation needs the versions table to track
+	// namespaces, but the driver's versions table doesn't.
+	ErrNamespacesNotSupported = errors.New("migrate: driver does not track namespaces in the versions table")
+	// ErrForceUnlockNotSupported is returned by ForceUnlock if the driver doesn't implement
+	// ForceUnlocker.
+	ErrForceUnlockNotSupported = errors.New("migrate: driver does not support force unlocking")
+	// ErrVersionDeleteNotSupported is returned when an operation needs to remove recorded versions,
+	// but the driver doesn't implement VersionDeleter.
+	ErrVersionDeleteNotSupported = errors.New("migrate: driver does not support deleting versions")
+	// ErrRecreateNotSupported is returned by RebuildVersionsTable if the driver doesn't implement
+	// VersionsTableRecreator.
+	ErrRecreateNotSupported = errors.New("migrate: driver does not support recreating the versions table")
+	// ErrRebuildNotConfirmed is returned by RebuildVersionsTable if it wasn't given
+	// WithRebuildConfirmed.
+	ErrRebuildNotConfirmed = errors.New("migrate: rebuilding the versions table must be confirmed")
+)
+
+// RebuildOption configures optional behaviour of RebuildVersionsTable.
+type RebuildOption func(*rebuildOptions)
+
+// rebuildOptions holds the configuration built up from RebuildOption values.
+type rebuildOptions struct {
+	confirmed bool
+	opts      []Option
+}
+
+// WithRebuildConfirmed confirms that the versions table really should be dropped and rebuilt.
+func WithRebuildConfirmed() RebuildOption {
+	return func(o *rebuildOptions) {
+		o.confirmed = true
+	}
+}
+
+// WithRebuildOptions applies the given Options to RebuildVersionsTable, e.g. WithLockScope or
+// WithLockWait, to lock the versions table the same way Execute does, or WithTimeout.
+func WithRebuildOptions(opts ...Option) RebuildOption {
+	return func(o *rebuildOptions) {
+		o.opts = append(o.opts, opts...)
+	}
+}
+
+// RenameNamespace updates the namespace of all recorded versions from oldName to newName, in a
+// transaction, holding the versions table lock (see WithLockScope). This is intended to be used
+// when a namespace is renamed in code, so that already applied versions aren't applied again
+// under the new name. The signatures stored by WithTamperDetection are refreshed for both
+// namespaces. If the driver doesn't track namespaces, ErrNamespacesNotSupported is returned and
+// nothing changes.
+func RenameNamespace(driver Driver, oldName, newName string, ctx context.Context, opts ...Option) error {
+	o := newOptions(opts...)
+
+	ctx, cancel := withTimeout(ctx, o.timeout)
+	defer cancel()
+
+	renamer, ok := driver.(NamespaceRenamer)
+	if !ok {
+		return ErrNamespacesNotSupported
+	}
+
+	if oldName == newName {
+		return nil
+	}
+
+	return inTransaction(ctx, driver, o, func() error {
+		err := renamer.RenameNamespace(ctx, oldName, newName)
+		if err != nil {
+			return fmt.Errorf("failed to rename namespace %q to %q: %w", oldName, newName, err)
+		}
+
+		return nil
+	})
+}
+
+// ForceUnlock releases the lock on the versions table, regardless of which process holds it. It is
+// intended as a deliberate operator action for recovering from a migrator that crashed or hung
+// while holding the lock, which would otherwise block all future runs. Depending on the driver,
+// this may terminate the holder's database connection, so make sure it really is stuck first.
+func ForceUnlock(driver Driver, ctx context.Context) error {
+	unlocker, ok := driver.(ForceUnlocker)
+	if !ok {
+		return ErrForceUnlockNotSupported
+	}
+
+	log.Println("migrate: force unlocking versions table")
+
+	err := unlocker.ForceUnlock(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to force unlock: %w", err)
+	}
+
+	log.Println("migrate: force unlocked versions table")
+
+	return nil
+}
+
+// Baseline records every version registered in the given namespace up to and including upTo as
+// applied, without executing their commands, in a transaction holding the versions table lock (see
+// WithLockScope). This is for adopting migrate on a database whose schema already exists. Versions
+// that are already recorded are left alone. If the driver implements BatchInserter the versions are
+// inserted in bulk, which is much faster when baselining many versions.
+func Baseline(driver Driver, namespace string, upTo int, ctx context.Context, opts ...Option) error {
+	return defaultRegistry.Baseline(driver, namespace, upTo, ctx, opts...)
+}
+
+// Baseline is like the package-level Baseline, but uses this Registry.
+func (r *Registry) Baseline(driver Driver, namespace string, upTo int, ctx context.Context, opts ...Option) error {
+	o := newOptions(opts...)
+
+	ctx, cancel := withTimeout(ctx, o.timeout)
+	defer cancel()
+
+	exists, err := driver.VersionTableExists(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check if versions table exists: %w", err)
+	}
+
+	if !exists {
+		err = driver.CreateVersionsTable(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return inTransaction(ctx, driver, o, func() error {
+		existingVersions, err := driver.Versions(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get current versions: %w", err)
+		}
+
+		existing := make(map[int]bool, len(existingVersions))
+		for _, version := range existingVersions {
+			existing[version] = true
+		}
+
+		var versions []int
+		for version := range r.registered(namespace) {
+			if version <= upTo && !existing[version] {
+				versions = append(versions, version)
+			}
+		}
+
+		sort.Ints(versions)
+
+		return insertVersions(ctx, driver, versions)
+	})
+}
+
+// Unrecord removes the given versions from the versions table, without executing anything, in a
+// transaction holding the versions table lock (see WithLockScope), so that they're applied again by
+// the next Execute. This is an operator recovery tool for versions that were recorded as applied
+// but never actually ran, e.g. because a baseline was taken from an incomplete schema dump. Every
+// version must be registered in the given namespace. Versions that aren't recorded are left alone.
+func Unrecord(driver Driver, namespace string, versions []int, ctx context.Context, opts ...Option) error {
+	return defaultRegistry.Unrecord(driver, namespace, versions, ctx, opts...)
+}
+
+// Unrecord is like the package-level Unrecord, but uses this Registry.
+func (r *Registry) Unrecord(driver Driver, namespace string, versions []int, ctx context.Context, opts ...Option) error {
+	o := newOptions(opts...)
+
+	ctx, cancel := withTimeout(ctx, o.timeout)
+	defer cancel()
+
+	deleter, ok := driver.(VersionDeleter)
+	if !ok {
+		return ErrVersionDeleteNotSupported
+	}
+
+	migrationsByVersion := r.registered(namespace)
+	for _, version := range versions {
+		if _, ok := migrationsByVersion[version]; !ok {
+			return fmt.Errorf("version %d is not registered in namespace %q", version, namespace)
+		}
+	}
+
+	return inTransaction(ctx, driver, o, func() error {
+		for _, version := range versions {
+			err := deleter.DeleteVersion(ctx, version)
+			if err != nil {
+				return fmt.Errorf("failed to delete version %d: %w", version, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// MarkApplied records the given versions as applied, without executing anything, in a transaction
+// holding the versions table lock (see WithLockScope), e.g. after a change was hotfixed by hand.
+// Every version must be registered in the given namespace. Versions that are already recorded are
+// left alone. Once committed, an OnVersionMarkedApplied event is fired for each version recorded,
+// so that repairs can be audited.
+func MarkApplied(driver Driver, events EventHandler, namespace string, versions []int, ctx context.Context, opts ...Option) error {
+	return defaultRegistry.MarkApplied(driver, events, namespace, versions, ctx, opts...)
+}
+
+// MarkApplied is like the package-level MarkApplied, but uses this Registry.
+func (r *Registry) MarkApplied(driver Driver, events EventHandler, namespace string, versions []int, ctx context.Context, opts ...Option) error {
+	o := newOptions(opts...)
+
+	ctx, cancel := withTimeout(ctx, o.timeout)
+	defer cancel()
+
+	if events == nil {
+		events = o.events
+	}
+
+	migrationsByVersion := r.registered(namespace)
+	for _, version := range versions {
+		if _, ok := migrationsByVersion[version]; !ok {
+			return fmt.Errorf("version %d is not registered in namespace %q", version, namespace)
+		}
+	}
+
+	exists, err := driver.VersionTableExists(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check if versions table exists: %w", err)
+	}
+
+	if !exists {
+		err = driver.CreateVersionsTable(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	var marked []int
+
+	err = inTransaction(ctx, driver, o, func() error {
+		existingVersions, err := driver.Versions(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get current versions: %w", err)
+		}
+
+		existing := versionSet(existingVersions)
+		for _, version := range versions {
+			if !existing[version] {
+				marked = append(marked, version)
+				existing[version] = true
+			}
+		}
+
+		sort.Ints(marked)
+
+		return insertVersions(ctx, driver, marked)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, version := range marked {
+		events.OnVersionMarkedApplied(version)
+	}
+
+	return nil
+}
+
+// MarkUnapplied removes the given versions from the versions table, without executing anything, in
+// a transaction holding the versions table lock (see WithLockScope), e.g. after a partially failed
+// run on a database without transactional DDL has been cleaned up by hand. Unlike Unrecord, the
+// versions don't need to be registered, so versions applied by a newer binary can be removed too.
+// Versions that aren't recorded are left alone. Once committed, an OnVersionMarkedUnapplied event
+// is fired for each version removed, so that repairs can be audited. The driver must implement
+// VersionDeleter.
+func MarkUnapplied(driver Driver, events EventHandler, versions []int, ctx context.Context, opts ...Option) error {
+	o := newOptions(opts...)
+
+	ctx, cancel := withTimeout(ctx, o.timeout)
+	defer cancel()
+
+	if events == nil {
+		events = o.events
+	}
+
+	deleter, ok := driver.(VersionDeleter)
+	if !ok {
+		return ErrVersionDeleteNotSupported
+	}
+
+	exists, err := driver.VersionTableExists(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check if versions table exists: %w", err)
+	}
+
+	if !exists {
+		return nil
+	}
+
+	var unmarked []int
+
+	err = inTransaction(ctx, driver, o, func() error {
+		existingVersions, err := driver.Versions(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get current versions: %w", err)
+		}
+
+		existing := versionSet(existingVersions)
+		for _, version := range versions {
+			if !existing[version] {
+				continue
+			}
+
+			err = deleter.DeleteVersion(ctx, version)
+			if err != nil {
+				return fmt.Errorf("failed to delete version %d: %w", version, err)
+			}
+
+			unmarked = append(unmarked, version)
+			existing[version] = false
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, version := range unmarked {
+		events.OnVersionMarkedUnapplied(version)
+	}
+
+	return nil
+}
+
+// RebuildVersionsTable drops and recreates the versions table, and records exactly the given
+// versions in it, holding the versions table lock. It returns the versions recorded beforehand as
+// a backup, which are also logged, even if rebuilding fails. This is a destructive operator
+// recovery tool for a damaged versions table, so it refuses to do anything without
+// WithRebuildConfirmed. Every version must be registered in the given namespace. Stored checksums
+// and tool versions are lost. Where the database supports transactional DDL, it all happens in
+// one transaction; on MySQL, the table is dropped and recreated even if recording the versions
+// fails afterwards.
+func RebuildVersionsTable(driver Driver, namespace string, versions []int, ctx context.Context, opts ...RebuildOption) ([]int, error) {
+	return defaultRegistry.RebuildVersionsTable(driver, namespace, versions, ctx, opts...)
+}
+
+// RebuildVersionsTable is like the package-level RebuildVersionsTable, but uses this Registry.
+func (r *Registry) RebuildVersionsTable(driver Driver, namespace string, versions []int, ctx context.Context, opts ...RebuildOption) ([]int, error) {
+	var o rebuildOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if !o.confirmed {
+		return nil, ErrRebuildNotConfirmed
+	}
+
+	recreator, ok := driver.(VersionsTableRecreator)
+	if !ok {
+		return nil, ErrRecreateNotSupported
+	}
+
+	migrationsByVersion := r.registered(namespace)
+	for _, version := range versions {
+		if _, ok := migrationsByVersion[version]; !ok {
+			return nil, fmt.Errorf("version %d is not registered in namespace %q", version, namespace)
+		}
+	}
+
+	lockOpts := newOptions(o.opts...)
+
+	ctx, cancel := withTimeout(ctx, lockOpts.timeout)
+	defer cancel()
+
+	var backup []int
+
+	err := inTransaction(ctx, driver, lockOpts, func() error {
+		var err error
+
+		backup, err = driver.Versions(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to back up current versions: %w", err)
+		}
+
+		sort.Ints(backup)
+		log.Printf("migrate: rebuilding versions table, backup of recorded versions: %v", backup)
+
+		err = recreator.RecreateVersionsTable(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to recreate versions table: %w", err)
+		}
+
+		sorted := make([]int, len(versions))
+		copy(sorted, versions)
+		sort.Ints(sorted)
+
+		return insertVersions(ctx, driver, sorted)
+	})
+
+	return backup, err
+}
+
+// insertVersions inserts all of the given versions, in bulk if the driver supports it.
+func insertVersions(ctx context.Context, driver Driver, versions []int) error {
+	if len(versions) == 0 {
+		return nil
+	}
+
+	if inserter, ok := driver.(BatchInserter); ok {
+		err := inserter.InsertVersions(ctx, versions)
+		if err != nil {
+			return fmt.Errorf("failed to insert versions: %w", err)
+		}
+
+		return nil
+	}
+
+	for _, version := range versions {
+		err := driver.InsertVersion(ctx, version)
+		if err != nil {
+			return fmt.Errorf("failed to insert version %d: %w", version, err)
+		}
+	}
+
+	return nil
+}
+
+// inTransaction begins a transaction, locks the versions table as configured by o, and then calls
+// fn. If fn returns an error the transaction is rolled back, otherwise it is committed. Any stored
+// versions table signature is updated before committing, as changes made by migrate aren't
+// tampering.
+func inTransaction(ctx context.Context, driver Driver, o *options, fn func() error) (err error) {
+	metadata, err := signedMetadata(ctx, driver)
+	if err != nil {
+		return err
+	}
+
+	err = driver.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			// The original error is more useful to the caller than any rollback error.
+			_ = driver.Rollback(ctx)
+		}
+	}()
+
+	err = o.lock(ctx, driver)
+	if err != nil {
+		return fmt.Errorf("failed to lock versions table: %w", err)
+	}
+
+	err = fn()
+	if err != nil {
+		return err
+	}
+
+	if metadata != nil {
+		err = refreshVersionsSignature(ctx, driver, metadata)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = driver.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
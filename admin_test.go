@@ -0,0 +1,39 @@
+package migrate
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestRegistryAdminUsesOwnMigrations(t *testing.T) {
+	ctx := context.Background()
+
+	r := NewRegistry()
+	r.Register("default", NewMigration(1, "CREATE TABLE a"))
+	r.Register("default", NewMigration(2, "CREATE TABLE b"))
+	r.Register("default", NewMigration(3, "CREATE TABLE c"))
+
+	driver := newFakeDriver()
+
+	if err := r.Baseline(driver, "default", 2, ctx); err != nil {
+		t.Fatalf("unexpected error baselining: %v", err)
+	}
+
+	if got := driver.db.committedVersions(); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("expected versions [1 2] to be baselined, got %v", got)
+	}
+
+	if err := r.MarkApplied(driver, NoopEventHandler{}, "default", []int{3}, ctx); err != nil {
+		t.Fatalf("unexpected error marking applied: %v", err)
+	}
+
+	if got := driver.db.committedVersions(); !reflect.DeepEqual(got, []int{1, 2, 3}) {
+		t.Errorf("expected versions [1 2 3] to be recorded, got %v", got)
+	}
+
+	// The default registry has none of these migrations.
+	if err := MarkApplied(newFakeDriver(), NoopEventHandler{}, "default", []int{3}, ctx); err == nil {
+		t.Errorf("expected an error marking a version not registered in the default registry")
+	}
+}
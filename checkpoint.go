@@ -0,0 +1,27 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrCheckpointNotSupported is returned when command checkpoints are enabled, but the driver
+// doesn't implement CheckpointDriver.
+var ErrCheckpointNotSupported = errors.New("migrate: driver does not support command checkpoints")
+
+// resumeFrom returns the index of the first of the given NoTransaction migration's commands that
+// hasn't completed, according to its checkpoint. A checkpoint left by different commands (e.g. if
+// the migration was fixed after failing) is ignored, so every command is executed again.
+func resumeFrom(ctx context.Context, checkpoints CheckpointDriver, migration Migration) (int, error) {
+	completed, checksum, err := checkpoints.Checkpoint(ctx, migration.Version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get checkpoint: %w", err)
+	}
+
+	if checksum != ChecksumSHA256(migration.Commands) || completed > len(migration.Commands) {
+		return 0, nil
+	}
+
+	return completed, nil
+}
@@ -0,0 +1,268 @@
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrChecksumMismatch is returned when an applied migration's stored checksum doesn't match the
+	// checksum of the currently registered migration.
+	ErrChecksumMismatch = errors.New("migrate: checksum mismatch")
+	// ErrChecksumAlgorithmMismatch is returned when an applied migration's stored checksum was
+	// produced by a different algorithm to the one currently configured.
+	ErrChecksumAlgorithmMismatch = errors.New("migrate: checksum algorithm mismatch")
+	// ErrChecksumsNotSupported is returned when checksums are enabled, but the driver doesn't
+	// implement ChecksumDriver.
+	ErrChecksumsNotSupported = errors.New("migrate: driver does not support checksums")
+	// ErrChecksumTableNotSupported is returned when a checksum table is enabled, but the driver
+	// doesn't implement ChecksumTableDriver.
+	ErrChecksumTableNotSupported = errors.New("migrate: driver does not support a checksum table")
+)
+
+// ChecksumFunc produces a checksum for a migration's (normalized) commands. The result should be
+// prefixed with an identifier for the algorithm, followed by a colon (e.g. "sha256:..."), as that
+// prefix is stored alongside the checksum so that switching algorithms can be detected.
+type ChecksumFunc func(commands []string) string
+
+// NormalizeFunc normalizes a single command before it is passed to a ChecksumFunc.
+type NormalizeFunc func(command string) string
+
+// ChecksumSHA256 is a ChecksumFunc that produces a SHA-256 checksum. This is the default.
+func ChecksumSHA256(commands []string) string {
+	return "sha256:" + hashCommands(sha256.New(), commands)
+}
+
+// ChecksumCRC32 is a ChecksumFunc that produces a CRC32 (IEEE) checksum. It's faster than SHA-256,
+// but much more prone to collisions.
+func ChecksumCRC32(commands []string) string {
+	return "crc32:" + hashCommands(crc32.NewIEEE(), commands)
+}
+
+// hashCommands writes each command to the given hash, separated so that moving text between
+// adjacent commands still changes the result.
+func hashCommands(h hash.Hash, commands []string) string {
+	for i, command := range commands {
+		if i > 0 {
+			h.Write([]byte{0})
+		}
+
+		h.Write([]byte(command))
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// NormalizeExact is a NormalizeFunc that leaves commands untouched, so checksums are computed over
+// the exact command bytes. This is the default.
+func NormalizeExact(command string) string {
+	return command
+}
+
+var (
+	lineCommentPattern  = regexp.MustCompile(`--[^\n]*`)
+	blockCommentPattern = regexp.MustCompile(`(?s)/\*.*?\*/`)
+)
+
+// NormalizeWhitespace is a NormalizeFunc that strips SQL comments and collapses all runs of
+// whitespace into a single space, so that reformatting a migration doesn't change its checksum.
+// It isn't aware of string literals, so comment-like text inside strings is stripped too.
+func NormalizeWhitespace(command string) string {
+	command = blockCommentPattern.ReplaceAllString(command, " ")
+	command = lineCommentPattern.ReplaceAllString(command, " ")
+
+	return strings.Join(strings.Fields(command), " ")
+}
+
+// checksumAlgorithm returns the algorithm identifier prefix of the given checksum.
+func checksumAlgorithm(checksum string) string {
+	if i := strings.Index(checksum, ":"); i >= 0 {
+		return checksum[:i]
+	}
+
+	return ""
+}
+
+// checksum calculates the checksum of the given migration using the configured algorithm.
+func (o *options) checksum(migration Migration) string {
+	commands := make([]string, len(migration.Commands))
+	for i, command := range migration.Commands {
+		commands[i] = o.checksumNormalize(command)
+	}
+
+	return o.checksumAlgo(commands)
+}
+
+// verifyChecksum compares a stored checksum against the checksum of the given migration. Versions
+// applied before checksums were enabled have no stored checksum, and are not verified.
+func (o *options) verifyChecksum(migration Migration, stored string) error {
+	if stored == "" {
+		return nil
+	}
+
+	current := o.checksum(migration)
+
+	if checksumAlgorithm(stored) != checksumAlgorithm(current) {
+		return fmt.Errorf("version %d stored with %q, configured %q: %w",
+			migration.Version, checksumAlgorithm(stored), checksumAlgorithm(current), ErrChecksumAlgorithmMismatch)
+	}
+
+	if stored != current {
+		return fmt.Errorf("version %d: %w", migration.Version, ErrChecksumMismatch)
+	}
+
+	return nil
+}
+
+// checksumDriver returns the ChecksumDriver to store checksums with, which uses a separate
+// checksum table if enabled.
+func (o *options) checksumDriver(driver Driver) (ChecksumDriver, error) {
+	if o.checksumTable {
+		table, ok := driver.(ChecksumTableDriver)
+		if !ok {
+			return nil, ErrChecksumTableNotSupported
+		}
+
+		return checksumTable{table}, nil
+	}
+
+	checksums, ok := driver.(ChecksumDriver)
+	if !ok {
+		return nil, ErrChecksumsNotSupported
+	}
+
+	return checksums, nil
+}
+
+// checksumTable adapts a ChecksumTableDriver to a ChecksumDriver.
+type checksumTable struct {
+	driver ChecksumTableDriver
+}
+
+// CreateChecksumColumn creates the checksum table, rather than a column.
+func (t checksumTable) CreateChecksumColumn(ctx context.Context) error {
+	return t.driver.CreateChecksumTable(ctx)
+}
+
+// SetChecksum stores the checksum in the checksum table.
+func (t checksumTable) SetChecksum(ctx context.Context, version int, checksum string) error {
+	return t.driver.SetTableChecksum(ctx, version, checksum)
+}
+
+// Checksums returns the checksums stored in the checksum table.
+func (t checksumTable) Checksums(ctx context.Context) (map[int]string, error) {
+	return t.driver.TableChecksums(ctx)
+}
+
+// ChecksumMismatches is returned by VerifyChecksums, containing an error for every applied version
+// whose checksum doesn't match its registered migration.
+type ChecksumMismatches []error
+
+// Error returns all of the mismatches, one per line.
+func (e ChecksumMismatches) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+
+	return fmt.Sprintf("migrate: %d checksum mismatches:\n%s", len(e), strings.Join(msgs, "\n"))
+}
+
+// Is returns true if any of the mismatches is target, e.g. ErrChecksumMismatch.
+func (e ChecksumMismatches) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// VerifyChecksums compares the stored checksums of applied versions against the migrations
+// currently registered in the given namespace, without applying anything or locking the versions
+// table. Every mismatch is reported in the returned ChecksumMismatches, not just the first. Pass
+// the same WithChecksum and WithChecksumTable options used with Execute, if the defaults weren't
+// used, and WithTimeout to bound it. Other options are ignored. The driver must implement
+// ChecksumDriver, or ChecksumTableDriver when using WithChecksumTable.
+func VerifyChecksums(driver Driver, namespace string, ctx context.Context, opts ...Option) error {
+	o := newOptions(opts...)
+
+	ctx, cancel := withTimeout(ctx, o.timeout)
+	defer cancel()
+
+	_, mismatches, err := o.checksumMismatches(ctx, driver, namespace)
+	if err != nil {
+		return err
+	}
+
+	if len(mismatches) > 0 {
+		return mismatches
+	}
+
+	return nil
+}
+
+// checksumMismatches returns the applied versions registered in the given namespace whose stored
+// checksums don't match, in ascending order, along with an error for each.
+func (o *options) checksumMismatches(ctx context.Context, driver Driver, namespace string) ([]int, ChecksumMismatches, error) {
+	checksums, err := o.checksumDriver(driver)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	exists, err := driver.VersionTableExists(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to check if versions table exists: %w", err)
+	}
+
+	if !exists {
+		return nil, nil, nil
+	}
+
+	err = driver.Begin(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	// This transaction is only used for reading.
+	defer driver.Rollback(ctx)
+
+	stored, err := checksums.Checksums(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get current checksums: %w", err)
+	}
+
+	versions := make([]int, 0, len(stored))
+	for version := range stored {
+		versions = append(versions, version)
+	}
+
+	sort.Ints(versions)
+
+	migrationsByVersion := o.registry.registered(namespace)
+
+	var mismatched []int
+	var mismatches ChecksumMismatches
+	for _, version := range versions {
+		migration, ok := migrationsByVersion[version]
+		if !ok {
+			continue
+		}
+
+		if err := o.verifyChecksum(migration, stored[version]); err != nil {
+			mismatched = append(mismatched, version)
+			mismatches = append(mismatches, err)
+		}
+	}
+
+	return mismatched, mismatches, nil
+}
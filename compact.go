@@ -0,0 +1,172 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrVersionRewriteNotSupported is returned by ApplyCompaction if the driver doesn't implement
+// VersionRewriter.
+var ErrVersionRewriteNotSupported = errors.New("migrate: driver does not support rewriting versions")
+
+// CompactVersions proposes a renumbering of the migration files in the given filesystem into a
+// clean sequence, starting at startAt, preserving their current order. The result maps each
+// current version to its new version. Use RewriteFilenames to rename the files, and
+// ApplyCompaction to update the versions recorded in each database, so they stay consistent.
+func CompactVersions(fsys fs.FS, startAt int) (map[int]int, error) {
+	seen := make(map[int]bool)
+
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		version, _, _, _, err := parseFilename(path)
+		if errors.Is(err, errNotMigration) {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		seen[version] = true
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]int, 0, len(seen))
+	for version := range seen {
+		versions = append(versions, version)
+	}
+
+	sort.Ints(versions)
+
+	mapping := make(map[int]int, len(versions))
+	for i, version := range versions {
+		mapping[version] = startAt + i
+	}
+
+	return mapping, nil
+}
+
+// RewriteFilenames renames the migration files in the given directory according to the mapping
+// returned by CompactVersions. Any zero-padding of the current version is preserved. Files are
+// first moved to temporary names, so versions can be swapped without clobbering each other.
+func RewriteFilenames(dir string, mapping map[int]int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	renames := make(map[string]string)
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		name := entry.Name()
+
+		version, _, _, _, err := parseFilename(name)
+		if errors.Is(err, errNotMigration) {
+			continue
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		to, ok := mapping[version]
+		if !ok || to == version {
+			continue
+		}
+
+		// The version is everything before the first dot, e.g. "0012" in "0012.up.sql".
+		prefix := name[:strings.Index(name, ".")]
+		renamed := strconv.Itoa(to)
+		if len(prefix) > 1 && strings.HasPrefix(prefix, "0") {
+			renamed = fmt.Sprintf("%0*d", len(prefix), to)
+		}
+
+		renames[name] = renamed + name[len(prefix):]
+	}
+
+	for from := range renames {
+		err := os.Rename(filepath.Join(dir, from), filepath.Join(dir, from+".compacting"))
+		if err != nil {
+			return fmt.Errorf("failed to rename file: %w", err)
+		}
+	}
+
+	for from, to := range renames {
+		err := os.Rename(filepath.Join(dir, from+".compacting"), filepath.Join(dir, to))
+		if err != nil {
+			return fmt.Errorf("failed to rename file: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ApplyCompaction updates the versions recorded in the versions table according to the mapping
+// returned by CompactVersions, in a transaction holding the versions table lock (see
+// WithLockScope), so that an existing database stays consistent with renumbered migration files.
+// Recorded versions that aren't in the mapping are left as they are.
+func ApplyCompaction(driver Driver, mapping map[int]int, ctx context.Context, opts ...Option) error {
+	o := newOptions(opts...)
+
+	ctx, cancel := withTimeout(ctx, o.timeout)
+	defer cancel()
+
+	rewriter, ok := driver.(VersionRewriter)
+	if !ok {
+		return ErrVersionRewriteNotSupported
+	}
+
+	return inTransaction(ctx, driver, o, func() error {
+		existing, err := driver.Versions(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get current versions: %w", err)
+		}
+
+		var versions []int
+		for _, version := range existing {
+			if to, ok := mapping[version]; ok && to != version {
+				versions = append(versions, version)
+			}
+		}
+
+		// Move every version out of the way first, so that versions can be swapped without
+		// violating uniqueness part way through. Versions are never negative, so that's safe.
+		for _, version := range versions {
+			err := rewriter.RewriteVersion(ctx, version, -version-1)
+			if err != nil {
+				return fmt.Errorf("failed to rewrite version %d: %w", version, err)
+			}
+		}
+
+		for _, version := range versions {
+			err := rewriter.RewriteVersion(ctx, -version-1, mapping[version])
+			if err != nil {
+				return fmt.Errorf("failed to rewrite version %d to %d: %w", version, mapping[version], err)
+			}
+		}
+
+		return nil
+	})
+}
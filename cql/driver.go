@@ -0,0 +1,349 @@
+// Package cql provides a migrate.Driver for Cassandra and ScyllaDB, using gocql.
+package cql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/seeruk/go-migrate"
+)
+
+// lockTTL is how long the lock row lives for, so that a migrator that crashes while holding the
+// lock doesn't block all future runs forever. It's refreshed while the lock is held.
+const lockTTL = 15 * time.Minute
+
+// lockRefreshInterval is how often the lock row's TTL is refreshed while the lock is held, well
+// within lockTTL, so that a refresh can fail without the lock being lost.
+const lockRefreshInterval = lockTTL / 3
+
+// maxIdentifierLen is the maximum length of a keyspace or table name in Cassandra.
+const maxIdentifierLen = 48
+
+// lockID is the primary key of the lock row.
+const lockID = "lock"
+
+var (
+	// errLockHeld is returned by Lock when the lock row is held by another migrator.
+	errLockHeld = errors.New("lock held by another migrator")
+	// errLockLost is returned when the lock row is no longer held by this migrator, e.g. because
+	// it was deleted with ForceUnlock, so another migrator may be running at the same time.
+	errLockLost = errors.New("lock no longer held by this migrator")
+)
+
+var (
+	_ migrate.Driver              = (*Driver)(nil)
+	_ migrate.TransactionalDriver = (*Driver)(nil)
+)
+
+// Driver is a migrate.Driver for Cassandra and ScyllaDB. There are no multi-statement transactions
+// in CQL, so Begin, Commit, and Rollback only track state, and every command and version insert
+// takes effect immediately. It reports that through migrate.TransactionalDriver, so each version is
+// applied and recorded on its own, as with migrate.WithTransactionPerMigration, and a failed run
+// leaves earlier versions applied and recorded, and the failed version partially applied. Locking
+// uses a lock row inserted with a lightweight transaction, whose TTL is refreshed in the background
+// while it's held, and whose ownership is checked again before each version is recorded.
+type Driver struct {
+	session  *gocql.Session
+	keyspace string
+	table    string
+	owner    string
+	started  bool
+	locked   bool
+
+	// stopHeartbeat stops refreshing the lock row, which closes heartbeatDone once it's stopped.
+	stopHeartbeat chan struct{}
+	heartbeatDone chan struct{}
+}
+
+// NewDriver returns a new Driver instance. The keyspace and table names are used in queries, so
+// an error is returned if either isn't a safe identifier.
+func NewDriver(session *gocql.Session, keyspace, table string) (*Driver, error) {
+	for _, name := range []string{keyspace, table} {
+		// The lock table name has a suffix, which must fit too.
+		if err := migrate.ValidateIdentifier(name, maxIdentifierLen-len("_lock")); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Driver{
+		session:  session,
+		keyspace: keyspace,
+		table:    table,
+		owner:    gocql.TimeUUID().String(),
+	}, nil
+}
+
+// Begin ...
+func (d *Driver) Begin(_ context.Context) error {
+	if d.started {
+		return migrate.ErrTransactionAlreadyStarted
+	}
+
+	d.started = true
+	return nil
+}
+
+// Commit ...
+func (d *Driver) Commit(ctx context.Context) error {
+	if !d.started {
+		return migrate.ErrTransactionNotStarted
+	}
+
+	d.started = false
+	return d.unlock(ctx)
+}
+
+// Rollback ...
+func (d *Driver) Rollback(ctx context.Context) error {
+	if !d.started {
+		return migrate.ErrTransactionNotStarted
+	}
+
+	d.started = false
+	return d.unlock(ctx)
+}
+
+// Transactional returns false, as every command takes effect immediately.
+func (d *Driver) Transactional() bool {
+	return false
+}
+
+// Lock ...
+func (d *Driver) Lock(ctx context.Context) error {
+	if !d.started {
+		return migrate.ErrTransactionNotStarted
+	}
+
+	query := fmt.Sprintf(`
+		INSERT INTO %s.%s_lock (id, owner, locked_at) VALUES (?, ?, toTimestamp(now()))
+		IF NOT EXISTS USING TTL %d
+	`, d.keyspace, d.table, int(lockTTL.Seconds()))
+
+	existing := make(map[string]interface{})
+
+	applied, err := d.session.Query(query, lockID, d.owner).WithContext(ctx).MapScanCAS(existing)
+	if err != nil {
+		return fmt.Errorf("failed to insert lock row: %w", err)
+	}
+
+	if !applied {
+		return fmt.Errorf("failed to acquire lock: held by %v: %w", existing["owner"], errLockHeld)
+	}
+
+	d.locked = true
+	d.stopHeartbeat = make(chan struct{})
+	d.heartbeatDone = make(chan struct{})
+
+	go d.heartbeat(d.stopHeartbeat, d.heartbeatDone)
+
+	return nil
+}
+
+// heartbeat refreshes the lock row's TTL every lockRefreshInterval, until stop is closed.
+func (d *Driver) heartbeat(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(lockRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), lockRefreshInterval)
+			// A failed refresh is retried by the next tick, and if the lock was lost, recording the
+			// next version fails, so the error isn't needed here.
+			_ = d.refreshLock(ctx)
+			cancel()
+		}
+	}
+}
+
+// refreshLock resets the lock row's TTL, returning an error wrapping errLockLost if it's no
+// longer held by this migrator.
+func (d *Driver) refreshLock(ctx context.Context) error {
+	query := fmt.Sprintf(`
+		UPDATE %s.%s_lock USING TTL %d SET owner = ? WHERE id = ? IF owner = ?
+	`, d.keyspace, d.table, int(lockTTL.Seconds()))
+
+	existing := make(map[string]interface{})
+
+	applied, err := d.session.Query(query, d.owner, lockID, d.owner).WithContext(ctx).MapScanCAS(existing)
+	if err != nil {
+		return fmt.Errorf("failed to refresh lock row: %w", err)
+	}
+
+	if !applied {
+		return fmt.Errorf("failed to refresh lock: held by %v: %w", existing["owner"], errLockLost)
+	}
+
+	return nil
+}
+
+// IsLockContention returns true if the given error is due to the lock row being held by another
+// migrator.
+func (d *Driver) IsLockContention(err error) bool {
+	return errors.Is(err, errLockHeld)
+}
+
+// unlock deletes the lock row, if this driver holds it.
+func (d *Driver) unlock(ctx context.Context) error {
+	if !d.locked {
+		return nil
+	}
+
+	if d.stopHeartbeat != nil {
+		close(d.stopHeartbeat)
+		<-d.heartbeatDone
+		d.stopHeartbeat, d.heartbeatDone = nil, nil
+	}
+
+	query := fmt.Sprintf(`DELETE FROM %s.%s_lock WHERE id = ? IF owner = ?`, d.keyspace, d.table)
+
+	existing := make(map[string]interface{})
+
+	applied, err := d.session.Query(query, lockID, d.owner).WithContext(ctx).MapScanCAS(existing)
+	if err != nil {
+		return fmt.Errorf("failed to delete lock row: %w", err)
+	}
+
+	// Either way, this migrator doesn't hold the lock any more.
+	d.locked = false
+
+	if !applied {
+		return fmt.Errorf("failed to release lock: held by %v: %w", existing["owner"], errLockLost)
+	}
+
+	return nil
+}
+
+// ForceUnlock deletes the lock row, regardless of which migrator holds it.
+func (d *Driver) ForceUnlock(ctx context.Context) error {
+	query := fmt.Sprintf(`DELETE FROM %s.%s_lock WHERE id = ?`, d.keyspace, d.table)
+
+	err := d.session.Query(query, lockID).WithContext(ctx).Exec()
+	if err != nil {
+		return fmt.Errorf("failed to delete lock row: %w", err)
+	}
+
+	return nil
+}
+
+// Exec ...
+func (d *Driver) Exec(ctx context.Context, command string) error {
+	if !d.started {
+		return migrate.ErrTransactionNotStarted
+	}
+
+	err := d.session.Query(command).WithContext(ctx).Exec()
+	if err != nil {
+		return fmt.Errorf("failed to execute command: %w", err)
+	}
+
+	return nil
+}
+
+// CreateVersionsTable ...
+func (d *Driver) CreateVersionsTable(ctx context.Context) error {
+	queries := []string{
+		fmt.Sprintf(`
+			CREATE TABLE IF NOT EXISTS %s.%s (
+				version int,
+				migrated_at timestamp,
+
+				PRIMARY KEY (version)
+			)
+		`, d.keyspace, d.table),
+		fmt.Sprintf(`
+			CREATE TABLE IF NOT EXISTS %s.%s_lock (
+				id text,
+				owner text,
+				locked_at timestamp,
+
+				PRIMARY KEY (id)
+			)
+		`, d.keyspace, d.table),
+	}
+
+	for _, query := range queries {
+		err := d.session.Query(query).WithContext(ctx).Exec()
+		if err != nil {
+			return fmt.Errorf("failed to create versions table: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// InsertVersion ...
+func (d *Driver) InsertVersion(ctx context.Context, version int) error {
+	if !d.started {
+		return migrate.ErrTransactionNotStarted
+	}
+
+	// Versions are only recorded while the lock is still held, so that a migrator that lost it
+	// stops, rather than racing whoever holds it now.
+	if d.locked {
+		err := d.refreshLock(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to insert version: %w", err)
+		}
+	}
+
+	query := fmt.Sprintf(`INSERT INTO %s.%s (version, migrated_at) VALUES (?, toTimestamp(now()))`, d.keyspace, d.table)
+
+	err := d.session.Query(query, version).WithContext(ctx).Exec()
+	if err != nil {
+		return fmt.Errorf("failed to insert version: %w", err)
+	}
+
+	return nil
+}
+
+// Versions ...
+func (d *Driver) Versions(ctx context.Context) ([]int, error) {
+	query := fmt.Sprintf(`SELECT version FROM %s.%s`, d.keyspace, d.table)
+
+	iter := d.session.Query(query).WithContext(ctx).Iter()
+
+	var versions []int
+	var version int
+
+	for iter.Scan(&version) {
+		versions = append(versions, version)
+	}
+
+	err := iter.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query current versions: %w", err)
+	}
+
+	return versions, nil
+}
+
+// VersionTableExists ...
+func (d *Driver) VersionTableExists(ctx context.Context) (bool, error) {
+	var count int
+
+	query := `SELECT COUNT(*) FROM system_schema.tables WHERE keyspace_name = ? AND table_name = ?`
+
+	err := d.session.Query(query, d.keyspace, d.table).WithContext(ctx).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to check if version table exists: %w", err)
+	}
+
+	return count == 1, nil
+}
+
+// Info returns the identity of the database the driver operates against.
+func (d *Driver) Info() migrate.DriverInfo {
+	return migrate.DriverInfo{
+		Dialect:  "cql",
+		Database: d.keyspace,
+		Table:    d.table,
+	}
+}
@@ -0,0 +1,146 @@
+package migrate
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// directivePrefix starts a magic comment in a SQL migration file, e.g. "-- migrate:no-transaction".
+const directivePrefix = "-- migrate:"
+
+// The directives that can be given in magic comments.
+const (
+	// directiveNoTransaction sets Migration.NoTransaction.
+	directiveNoTransaction = "no-transaction"
+	// directiveTimeout sets Migration.Timeout to the duration given after it, e.g. "timeout 5m".
+	directiveTimeout = "timeout"
+	// directiveStatementBegin and directiveStatementEnd surround a statement that must be executed
+	// as one command, even if it contains semicolons, e.g. a function body.
+	directiveStatementBegin = "statementbegin"
+	directiveStatementEnd   = "statementend"
+)
+
+// directives holds the options set by magic comments in a SQL migration file.
+type directives struct {
+	noTransaction bool
+	timeout       time.Duration
+	// statements is true if the file has statementbegin or statementend directives, so is split
+	// into commands by them.
+	statements bool
+}
+
+// parseDirectives reads the magic comments in the given SQL file contents. Unknown directives are
+// ignored, but an error wrapping ErrInvalidMigration is returned if a known one is malformed.
+func parseDirectives(contents string) (directives, error) {
+	var d directives
+
+	for _, line := range strings.Split(contents, "\n") {
+		fields := directiveFields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case directiveNoTransaction:
+			d.noTransaction = true
+		case directiveTimeout:
+			if len(fields) != 2 {
+				return d, fmt.Errorf("%s directive must be followed by a duration: %w", directiveTimeout, ErrInvalidMigration)
+			}
+
+			timeout, err := time.ParseDuration(fields[1])
+			if err != nil || timeout <= 0 {
+				return d, fmt.Errorf("%s directive has invalid duration %q: %w", directiveTimeout, fields[1], ErrInvalidMigration)
+			}
+
+			d.timeout = timeout
+		case directiveStatementBegin, directiveStatementEnd:
+			d.statements = true
+		}
+	}
+
+	return d, nil
+}
+
+// directiveFields returns the fields of the magic comment on the given line, or nil if it isn't
+// one.
+func directiveFields(line string) []string {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, directivePrefix) {
+		return nil
+	}
+
+	return strings.Fields(strings.TrimPrefix(line, directivePrefix))
+}
+
+// apply sets the options from the directives on the given migration.
+func (d directives) apply(migration *Migration) {
+	if d.noTransaction {
+		migration.NoTransaction = true
+	}
+
+	if d.timeout > 0 {
+		migration.Timeout = d.timeout
+	}
+}
+
+// splitStatementBlocks splits the given SQL file contents into commands, with each statementbegin
+// and statementend block as one command. The text around the blocks is split into statements for
+// the given dialect, or kept whole if dialect is empty. See WithStatementSplitting.
+func splitStatementBlocks(contents, dialect string) ([]string, error) {
+	var commands []string
+	var chunk []string
+	inBlock := false
+
+	// flush adds the lines read since the last directive as commands.
+	flush := func() error {
+		text := strings.TrimSpace(strings.Join(chunk, "\n"))
+		chunk = chunk[:0]
+
+		switch {
+		case text == "":
+			return nil
+		case inBlock || dialect == "":
+			commands = append(commands, text)
+			return nil
+		}
+
+		statements, err := splitStatements(text, dialect)
+		if err != nil {
+			return err
+		}
+
+		commands = append(commands, statements...)
+
+		return nil
+	}
+
+	for _, line := range strings.Split(contents, "\n") {
+		fields := directiveFields(line)
+		if len(fields) == 0 || (fields[0] != directiveStatementBegin && fields[0] != directiveStatementEnd) {
+			chunk = append(chunk, line)
+			continue
+		}
+
+		if (fields[0] == directiveStatementBegin) == inBlock {
+			return nil, fmt.Errorf("unexpected %s directive: %w", fields[0], ErrInvalidMigration)
+		}
+
+		if err := flush(); err != nil {
+			return nil, err
+		}
+
+		inBlock = !inBlock
+	}
+
+	if inBlock {
+		return nil, fmt.Errorf("%s directive without %s: %w", directiveStatementBegin, directiveStatementEnd, ErrInvalidMigration)
+	}
+
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	return commands, nil
+}
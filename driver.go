@@ -2,8 +2,73 @@ package migrate
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
 )
 
+// maxBatchInsert is the maximum number of versions drivers insert in a single statement, to stay
+// well within limits on the number of parameters in a query.
+const maxBatchInsert = 1000
+
+// versionsColumn is a column of a versions table. Drivers define each of their versions table's
+// columns once, and derive their DDL and queries from them, so that they can't drift apart.
+type versionsColumn struct {
+	name       string
+	definition string
+}
+
+// ddl returns the column's name and definition, for use in CREATE or ALTER TABLE statements.
+func (c versionsColumn) ddl() string {
+	return c.name + " " + c.definition
+}
+
+// columnsDDL returns the DDL for the given columns, for use in a CREATE TABLE statement.
+func columnsDDL(columns []versionsColumn) string {
+	defs := make([]string, len(columns))
+	for i, column := range columns {
+		defs[i] = column.ddl()
+	}
+
+	return strings.Join(defs, ",\n\t\t\t")
+}
+
+// sideTableSuffixes are appended to the versions table's name to name the other tables drivers
+// may create alongside it.
+var sideTableSuffixes = []string{"_metadata", "_runs", "_checksums", "_repeats", "_progress"}
+
+// ownTables returns the names of the given versions table, and the side tables created alongside
+// it, i.e. every table that migrate itself may create.
+func ownTables(table string) []string {
+	tables := []string{table}
+	for _, suffix := range sideTableSuffixes {
+		tables = append(tables, table+suffix)
+	}
+
+	return tables
+}
+
+// ErrInvalidIdentifier is returned when constructing a driver with a database, schema, or table
+// name that isn't a safe identifier to use in queries.
+var ErrInvalidIdentifier = errors.New("migrate: invalid identifier")
+
+// identifierPattern matches identifiers that are safe to interpolate into queries unquoted.
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ValidateIdentifier returns an error wrapping ErrInvalidIdentifier if the given name isn't a safe
+// identifier, i.e. one made up of letters, digits, and underscores, not starting with a digit, and
+// no longer than maxLen. This is exported for use by drivers in other packages.
+func ValidateIdentifier(name string, maxLen int) error {
+	if !identifierPattern.MatchString(name) || len(name) > maxLen {
+		return fmt.Errorf("%q: %w", name, ErrInvalidIdentifier)
+	}
+
+	return nil
+}
+
 // Driver ...
 // TODO: Can this be simplified, leaving more to each driver? It's quite heavily tied to SQL
 // databases currently?
@@ -18,3 +83,330 @@ type Driver interface {
 	Versions(ctx context.Context) ([]int, error)
 	VersionTableExists(ctx context.Context) (bool, error)
 }
+
+// Executor executes commands in the transaction a migration is being applied in. See
+// Migration.Func.
+type Executor interface {
+	Exec(ctx context.Context, command string) error
+}
+
+// executorFunc is an Executor that calls itself.
+type executorFunc func(ctx context.Context, command string) error
+
+// Exec ...
+func (fn executorFunc) Exec(ctx context.Context, command string) error {
+	return fn(ctx, command)
+}
+
+// ChecksumDriver is implemented by drivers that can store a checksum alongside each version.
+type ChecksumDriver interface {
+	// CreateChecksumColumn adds the checksum column to the versions table, if it's not there.
+	CreateChecksumColumn(ctx context.Context) error
+	// SetChecksum stores the checksum for an inserted version, as part of the transaction.
+	SetChecksum(ctx context.Context, version int, checksum string) error
+	// Checksums returns the stored checksums by version. Versions without one may be omitted.
+	Checksums(ctx context.Context) (map[int]string, error)
+}
+
+// RepeatableDriver is implemented by drivers that can store the checksums of applied repeatable
+// migrations, in a table alongside the versions table. See RepeatableMigration.
+type RepeatableDriver interface {
+	// CreateRepeatableTable creates the repeatable migrations table, if it doesn't exist.
+	CreateRepeatableTable(ctx context.Context) error
+	// RepeatableChecksums returns the checksum each repeatable migration was last applied with, by
+	// name, as part of the transaction.
+	RepeatableChecksums(ctx context.Context) (map[string]string, error)
+	// SetRepeatableChecksum records that the named repeatable migration was applied with the given
+	// checksum, as part of the transaction.
+	SetRepeatableChecksum(ctx context.Context, name, checksum string) error
+}
+
+// ChecksumTableDriver is implemented by drivers that can store checksums in a separate checksum
+// table, for when the versions table can't be altered.
+type ChecksumTableDriver interface {
+	// CreateChecksumTable creates the checksum table, if it doesn't exist.
+	CreateChecksumTable(ctx context.Context) error
+	// SetTableChecksum stores the checksum for an inserted version, as part of the transaction.
+	SetTableChecksum(ctx context.Context, version int, checksum string) error
+	// TableChecksums returns the stored checksums of recorded versions, by version.
+	TableChecksums(ctx context.Context) (map[int]string, error)
+}
+
+// ToolVersionDriver is implemented by drivers that can store the version of the tool that applied
+// each version alongside it.
+type ToolVersionDriver interface {
+	// CreateToolVersionColumn adds the tool version column to the versions table, if it's not there.
+	CreateToolVersionColumn(ctx context.Context) error
+	// SetToolVersion stores the tool version for an inserted version, as part of the transaction.
+	SetToolVersion(ctx context.Context, version int, toolVersion string) error
+}
+
+// SavepointDriver is implemented by drivers that can set savepoints in the current transaction. See
+// WithSavepoints.
+type SavepointDriver interface {
+	// Savepoint sets a savepoint with the given name.
+	Savepoint(ctx context.Context, name string) error
+	// RollbackToSavepoint rolls back everything done since the named savepoint was set.
+	RollbackToSavepoint(ctx context.Context, name string) error
+	// ReleaseSavepoint releases the named savepoint, keeping everything done since it was set.
+	ReleaseSavepoint(ctx context.Context, name string) error
+}
+
+// DescriptionDriver is implemented by drivers that can store a description of each version (its
+// Name) alongside it.
+type DescriptionDriver interface {
+	// CreateDescriptionColumn adds the description column to the versions table, if it's not there.
+	CreateDescriptionColumn(ctx context.Context) error
+	// SetDescription stores the description for an inserted version, as part of the transaction.
+	SetDescription(ctx context.Context, version int, description string) error
+}
+
+// NamespaceRenamer is implemented by drivers whose versions table tracks the namespace of each
+// version, allowing recorded versions to be moved to a new namespace.
+type NamespaceRenamer interface {
+	// RenameNamespace updates recorded versions in oldName to newName, and the stored versions
+	// table signatures of both namespaces, if any, as part of the transaction.
+	RenameNamespace(ctx context.Context, oldName, newName string) error
+}
+
+// ForceUnlocker is implemented by drivers that can release a lock on the versions table held by
+// another process, e.g. one that crashed or hung while migrating.
+type ForceUnlocker interface {
+	// ForceUnlock releases the versions table lock, regardless of the holder.
+	ForceUnlock(ctx context.Context) error
+}
+
+// MetadataDriver is implemented by drivers that can store arbitrary key/value metadata alongside
+// the versions table, e.g. in a separate metadata table.
+type MetadataDriver interface {
+	// CreateMetadataTable creates the metadata table, if it doesn't exist.
+	CreateMetadataTable(ctx context.Context) error
+	// Metadata returns the value stored for key, as part of the transaction, or "" if unset.
+	Metadata(ctx context.Context, key string) (string, error)
+	// SetMetadata stores the value for key, as part of the transaction.
+	SetMetadata(ctx context.Context, key, value string) error
+}
+
+// MetadataTableChecker is implemented by MetadataDrivers that can check whether the metadata table
+// exists, so that it needn't be created just to find out that nothing is stored in it.
+type MetadataTableChecker interface {
+	// MetadataTableExists returns true if the metadata table exists.
+	MetadataTableExists(ctx context.Context) (bool, error)
+}
+
+// SessionDriver is implemented by drivers that need to set up state before a run, and tear it
+// down again afterwards, e.g. to acquire a dedicated connection.
+type SessionDriver interface {
+	// Open is called before anything else during a run.
+	Open(ctx context.Context) error
+	// Close is called at the end of a run, even if Open failed.
+	Close(ctx context.Context) error
+}
+
+// NoTransactionDriver is implemented by drivers that can execute commands outside of the
+// transaction, for migrations that can't be run inside of one.
+type NoTransactionDriver interface {
+	// ExecNoTransaction executes the command on a connection not used by the transaction.
+	ExecNoTransaction(ctx context.Context, command string) error
+}
+
+// TransactionalDriver is implemented by drivers that can report whether they support
+// transactions. Drivers that don't implement it are assumed to. Execute applies and records each
+// version on its own when using a driver without transactions, as if WithTransactionPerMigration
+// was given, and reports a failed version's earlier commands as partially applied (see
+// MigrationError), as nothing is rolled back.
+type TransactionalDriver interface {
+	// Transactional returns false if Begin, Commit, and Rollback only track state, and commands
+	// and inserted versions take effect immediately, e.g. for Cassandra.
+	Transactional() bool
+}
+
+// isTransactional returns true unless the driver reports that it doesn't support transactions.
+func isTransactional(driver Driver) bool {
+	td, ok := driver.(TransactionalDriver)
+	return !ok || td.Transactional()
+}
+
+// LockContentionClassifier is implemented by drivers that can tell when Lock failed because the
+// lock is held by another process, rather than because of some other problem.
+type LockContentionClassifier interface {
+	// IsLockContention returns true if the given error returned by Lock is due to contention.
+	IsLockContention(err error) bool
+}
+
+// VersionRewriter is implemented by drivers that can change the version of a recorded version.
+type VersionRewriter interface {
+	// RewriteVersion changes the recorded version from to to, as part of the transaction.
+	RewriteVersion(ctx context.Context, from, to int) error
+}
+
+// VersionDeleter is implemented by drivers that can remove a recorded version.
+type VersionDeleter interface {
+	// DeleteVersion removes the recorded version, as part of the transaction.
+	DeleteVersion(ctx context.Context, version int) error
+}
+
+// BatchInserter is implemented by drivers that can insert many versions at once, which is much
+// faster than inserting them one at a time when baselining.
+type BatchInserter interface {
+	// InsertVersions inserts all of the given versions, as part of the transaction.
+	InsertVersions(ctx context.Context, versions []int) error
+}
+
+// ReaderExecer is implemented by drivers that can execute a stream of SQL statements without
+// reading it all into memory first.
+type ReaderExecer interface {
+	// ExecReader executes every statement read from r, as part of the transaction.
+	ExecReader(ctx context.Context, r io.Reader) error
+}
+
+// ResultExecer is implemented by drivers that can report the number of rows affected by each
+// command they execute.
+type ResultExecer interface {
+	// ExecResult is Exec, but also returns the number of rows affected by the command.
+	ExecResult(ctx context.Context, command string) (int64, error)
+}
+
+// InconsistencyDetector is implemented by drivers that can find schema objects left in an
+// inconsistent state by a failed migration, e.g. invalid indexes left behind by a failed
+// CREATE INDEX CONCURRENTLY.
+type InconsistencyDetector interface {
+	// DetectInconsistentObjects returns a description of each inconsistent object found. It must
+	// not use the transaction, as it may already have been aborted.
+	DetectInconsistentObjects(ctx context.Context) ([]string, error)
+}
+
+// Notifier is implemented by drivers that can notify other database clients that migrations were
+// applied, e.g. using Postgres' NOTIFY.
+type Notifier interface {
+	// Notify sends payload on channel. It's called after the transaction has been committed.
+	Notify(ctx context.Context, channel, payload string) error
+}
+
+// RunLogDriver is implemented by drivers that can keep an append-only log of Execute runs in a
+// separate run log table.
+type RunLogDriver interface {
+	// CreateRunLogTable creates the run log table, if it doesn't exist.
+	CreateRunLogTable(ctx context.Context) error
+	// InsertRunLog appends the given entry to the run log, as part of the transaction.
+	InsertRunLog(ctx context.Context, entry RunLogEntry) error
+	// RunLog returns the entries for the given namespace, oldest first.
+	RunLog(ctx context.Context, namespace string) ([]RunLogEntry, error)
+}
+
+// Explainer is implemented by drivers that can show the query plan for a command without
+// executing it.
+type Explainer interface {
+	// Explain returns the lines of the query plan for the given command, without executing it.
+	Explain(ctx context.Context, command string) ([]string, error)
+}
+
+// ScopedLocker is implemented by drivers that can lock independent scopes of the versions table,
+// allowing runs in different scopes to proceed concurrently.
+type ScopedLocker interface {
+	// LockScope is Lock, but only excludes other runs locking the same scope.
+	LockScope(ctx context.Context, scope string) error
+}
+
+// ErrorClassifier is implemented by drivers that can classify the errors they return, so that
+// failures can be handled the same way across databases.
+type ErrorClassifier interface {
+	// ClassifyError returns the class of the given error, or ErrorClassUnknown.
+	ClassifyError(err error) ErrorClass
+}
+
+// TableNamer is implemented by drivers that can use a different versions table at runtime, so one
+// driver can serve many namespaces, each with their own versions table.
+type TableNamer interface {
+	// ForTable returns a copy of the driver that uses the given versions table, which must not be
+	// in a transaction.
+	ForTable(table string) (Driver, error)
+}
+
+// CheckpointDriver is implemented by drivers that can track which commands of a NoTransaction
+// migration have completed, so that a failed migration can be resumed from the failed command.
+type CheckpointDriver interface {
+	// CreateCheckpointTable creates the checkpoint table, if it doesn't exist.
+	CreateCheckpointTable(ctx context.Context) error
+	// Checkpoint returns how many of version's commands have completed, and the checksum of the
+	// commands, or zero and "" if there is no checkpoint.
+	Checkpoint(ctx context.Context, version int) (int, string, error)
+	// SetCheckpoint records how many of version's commands have completed. This is done outside of
+	// the transaction, so that it's kept if the transaction is rolled back.
+	SetCheckpoint(ctx context.Context, version, completed int, checksum string) error
+	// DeleteCheckpoint deletes version's checkpoint, as part of the transaction.
+	DeleteCheckpoint(ctx context.Context, version int) error
+}
+
+// NamespaceScoper is implemented by drivers that can store the versions of many namespaces in one
+// versions table, with a namespace column, instead of needing a table per namespace.
+type NamespaceScoper interface {
+	// ForNamespace returns a copy of the driver that only sees and records the given namespace's
+	// versions, which must not be in a transaction.
+	ForNamespace(namespace string) (Driver, error)
+	// CreateNamespaceColumn adds the namespace column to the versions table, and makes it part of
+	// the primary key, if it's not there, so that a table created before it was shared can be
+	// shared. Versions recorded before then are given the empty namespace.
+	CreateNamespaceColumn(ctx context.Context) error
+}
+
+// VersionsTableRecreator is implemented by drivers that can drop and recreate the versions table.
+type VersionsTableRecreator interface {
+	// RecreateVersionsTable drops the versions table, and creates it again, empty, as part of the
+	// transaction if the database supports transactional DDL.
+	RecreateVersionsTable(ctx context.Context) error
+}
+
+// ReadOnlyVersioner is implemented by drivers that can read the recorded versions without a
+// transaction, for read-only introspection that shouldn't interfere with a run in progress.
+type ReadOnlyVersioner interface {
+	// VersionsReadOnly returns the recorded versions, without a transaction or lock.
+	VersionsReadOnly(ctx context.Context) ([]int, error)
+}
+
+// AppliedAtReader is implemented by drivers that can read when each version was recorded, without
+// a transaction or lock.
+type AppliedAtReader interface {
+	// AppliedAt returns when each recorded version was recorded, by version.
+	AppliedAt(ctx context.Context) (map[int]time.Time, error)
+}
+
+// The dialects of the built-in drivers, as reported in DriverInfo.
+const (
+	DialectPostgres = "postgres"
+	DialectMySQL    = "mysql"
+)
+
+// DriverInfo identifies the database a driver operates against, e.g. for telling apart the logs
+// of runs against different databases. Fields that the driver doesn't know are left empty.
+type DriverInfo struct {
+	Dialect  string
+	Host     string
+	Database string
+	Schema   string
+	Table    string
+}
+
+// Describer is implemented by drivers that can identify the database they operate against.
+type Describer interface {
+	// Info returns the identity of the database the driver operates against, without any I/O.
+	Info() DriverInfo
+}
+
+// Info returns the identity of the database the given driver operates against, or an empty
+// DriverInfo if the driver doesn't implement Describer.
+func Info(driver Driver) DriverInfo {
+	if describer, ok := driver.(Describer); ok {
+		return describer.Info()
+	}
+
+	return DriverInfo{}
+}
+
+// EmptySchemaChecker is implemented by drivers that can check whether the schema (or database)
+// they operate in contains any tables. See WithRequireEmptyOnFirstRun.
+type EmptySchemaChecker interface {
+	// IsSchemaEmpty returns true if the schema contains no tables, other than the versions table
+	// and the side tables created alongside it.
+	IsSchemaEmpty(ctx context.Context) (bool, error)
+}
@@ -3,27 +3,190 @@ package migrate
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"regexp"
+	"strings"
 	"time"
 )
 
+// mysqlMaxIdentifierLen is the maximum length of an identifier in MySQL.
+const mysqlMaxIdentifierLen = 64
+
+// mysqlLockTimeout is how many seconds each attempt to acquire the named lock waits for.
+const mysqlLockTimeout = 5
+
+// errMySQLLockTimeout is returned by Lock when the named lock is held by another connection.
+var errMySQLLockTimeout = errors.New("timed out waiting for lock")
+
+// The columns of the MySQL versions table.
+var (
+	mysqlVersionColumn     = versionsColumn{name: "version", definition: "int NOT NULL"}
+	mysqlMigratedAtColumn  = versionsColumn{name: "migrated_at", definition: "timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP"}
+	mysqlChecksumColumn    = versionsColumn{name: "checksum", definition: "varchar(255) NOT NULL DEFAULT ''"}
+	mysqlToolVersionColumn = versionsColumn{name: "tool_version", definition: "varchar(255) NULL"}
+	mysqlDescriptionColumn = versionsColumn{name: "description", definition: "varchar(255) NULL"}
+	mysqlNamespaceColumn   = versionsColumn{name: "namespace", definition: "varchar(255) NOT NULL DEFAULT ''"}
+)
+
+// mysqlVersionsTableColumns are the columns the versions table is created with. The rest are
+// added when a feature that needs them is first used.
+var mysqlVersionsTableColumns = []versionsColumn{mysqlVersionColumn, mysqlMigratedAtColumn}
+
+// mysqlRunLogColumns are the columns added to the run log table after it was first released, so
+// that run log tables created before then are upgraded.
+var mysqlRunLogColumns = []versionsColumn{
+	{name: "run_id", definition: "varchar(64) NOT NULL DEFAULT ''"},
+	{name: "finished_at", definition: "datetime(6) NULL"},
+	{name: "host", definition: "varchar(255) NOT NULL DEFAULT ''"},
+	{name: "app_version", definition: "varchar(255) NOT NULL DEFAULT ''"},
+}
+
 // MySQLDriver ...
 type MySQLDriver struct {
-	conn     *sql.DB
+	db       *sql.DB
+	conn     mysqlQuerier
 	tx       *sql.Tx
 	database string
 	table    string
+	scope    string
+
+	// shared is set by ForNamespace, restricting the versions table to namespace's rows.
+	shared    bool
+	namespace string
+
+	bookkeepingTimeout time.Duration
+
+	pin    bool
+	setup  func(ctx context.Context, conn *sql.Conn) error
+	pinned *sql.Conn
+}
+
+// tableName returns the quoted, database-qualified name of the versions table, or of one of its
+// side tables if suffix is given (e.g. "_metadata").
+func (d *MySQLDriver) tableName(suffix string) string {
+	return mysqlQuote(d.database) + "." + mysqlQuote(d.table+suffix)
+}
+
+// namespaceFilter returns a condition restricting the versions table to the driver's namespace,
+// and args with the namespace appended, if the table is shared. Otherwise, it returns a condition
+// that's always true, and args unchanged.
+func (d *MySQLDriver) namespaceFilter(args ...interface{}) (string, []interface{}) {
+	if !d.shared {
+		return "TRUE", args
+	}
+
+	return mysqlNamespaceColumn.name + " = ?", append(args, d.namespace)
+}
+
+// mysqlQuote quotes the given identifier, so that reserved words (e.g. "order") can be used.
+// Identifiers are validated, so never contain backticks themselves.
+func mysqlQuote(identifier string) string {
+	return "`" + identifier + "`"
+}
+
+// mysqlQuerier is the set of methods shared by *sql.DB and *sql.Conn that the driver uses,
+// allowing queries to run on either the pool, or a pinned connection.
+type mysqlQuerier interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// MySQLOption configures optional behaviour of a MySQLDriver.
+type MySQLOption func(*MySQLDriver)
+
+// WithMySQLPinnedConn makes the driver acquire a single connection from the pool when Execute
+// starts, and use it for every query until Execute finishes, releasing it afterwards. MySQL named
+// locks belong to the connection that acquired them, so this guarantees the lock is released by
+// the same connection that took it. If setup is not nil, it's called on the connection once it's
+// acquired, e.g. to set session variables. The pool must allow at least one connection to be held
+// for the duration of the run, plus one more for NoTransaction migrations.
+func WithMySQLPinnedConn(setup func(ctx context.Context, conn *sql.Conn) error) MySQLOption {
+	return func(d *MySQLDriver) {
+		d.pin = true
+		d.setup = setup
+	}
+}
+
+// WithMySQLBookkeepingTimeout bounds each of the driver's bookkeeping queries (checking for,
+// creating, and reading the versions table) with its own timeout, separate from the overall
+// Execute timeout, so that a hung metadata query fails fast instead of using up the whole budget.
+func WithMySQLBookkeepingTimeout(d time.Duration) MySQLOption {
+	return func(driver *MySQLDriver) {
+		driver.bookkeepingTimeout = d
+	}
 }
 
-// NewMySQLDriver returns a new MySQLDriver instance.
-func NewMySQLDriver(conn *sql.DB, database, table string) *MySQLDriver {
-	return &MySQLDriver{
+// NewMySQLDriver returns a new MySQLDriver instance. The database and table names are used in
+// queries, so an error is returned if either isn't a safe identifier.
+func NewMySQLDriver(conn *sql.DB, database, table string, opts ...MySQLOption) (*MySQLDriver, error) {
+	for _, name := range []string{database, table} {
+		// The side table names have suffixes, the longest of which must fit too.
+		if err := ValidateIdentifier(name, mysqlMaxIdentifierLen-len("_checksums")); err != nil {
+			return nil, err
+		}
+	}
+
+	d := &MySQLDriver{
+		db:       conn,
 		conn:     conn,
 		database: database,
 		table:    table,
 	}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	return d, nil
+}
+
+// Open acquires the pinned connection, if the driver is configured to use one.
+func (d *MySQLDriver) Open(ctx context.Context) error {
+	if !d.pin || d.pinned != nil {
+		return nil
+	}
+
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %w", err)
+	}
+
+	if d.setup != nil {
+		err = d.setup(ctx, conn)
+		if err != nil {
+			conn.Close()
+			return fmt.Errorf("failed to set up connection: %w", err)
+		}
+	}
+
+	d.pinned = conn
+	d.conn = conn
+
+	return nil
+}
+
+// Close releases the pinned connection back to the pool, if one was acquired.
+func (d *MySQLDriver) Close(_ context.Context) error {
+	if d.pinned == nil {
+		return nil
+	}
+
+	err := d.pinned.Close()
+
+	d.pinned = nil
+	d.conn = d.db
+
+	if err != nil {
+		return fmt.Errorf("failed to release connection: %w", err)
+	}
+
+	return nil
 }
 
 // Begin ...
@@ -49,6 +212,7 @@ func (d *MySQLDriver) Commit(_ context.Context) error {
 	}
 
 	defer d.Unlock()
+	defer func() { d.tx = nil }()
 
 	err := d.tx.Commit()
 	if err != nil {
@@ -65,6 +229,7 @@ func (d *MySQLDriver) Rollback(_ context.Context) error {
 	}
 
 	defer d.Unlock()
+	defer func() { d.tx = nil }()
 
 	err := d.tx.Rollback()
 	if err != nil {
@@ -74,6 +239,35 @@ func (d *MySQLDriver) Rollback(_ context.Context) error {
 	return nil
 }
 
+// Savepoint ...
+func (d *MySQLDriver) Savepoint(ctx context.Context, name string) error {
+	return d.execSavepoint(ctx, "SAVEPOINT "+name)
+}
+
+// RollbackToSavepoint ...
+func (d *MySQLDriver) RollbackToSavepoint(ctx context.Context, name string) error {
+	return d.execSavepoint(ctx, "ROLLBACK TO SAVEPOINT "+name)
+}
+
+// ReleaseSavepoint ...
+func (d *MySQLDriver) ReleaseSavepoint(ctx context.Context, name string) error {
+	return d.execSavepoint(ctx, "RELEASE SAVEPOINT "+name)
+}
+
+// execSavepoint executes the given savepoint statement in the transaction.
+func (d *MySQLDriver) execSavepoint(ctx context.Context, query string) error {
+	if d.tx == nil {
+		return ErrTransactionNotStarted
+	}
+
+	_, err := d.tx.ExecContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to execute %q: %w", query, err)
+	}
+
+	return nil
+}
+
 // Exec ...
 func (d *MySQLDriver) Exec(ctx context.Context, command string) error {
 	if d.tx == nil {
@@ -88,43 +282,112 @@ func (d *MySQLDriver) Exec(ctx context.Context, command string) error {
 	return nil
 }
 
+// ExecNoTransaction ...
+func (d *MySQLDriver) ExecNoTransaction(ctx context.Context, command string) error {
+	_, err := d.db.ExecContext(ctx, command)
+	if err != nil {
+		return fmt.Errorf("failed to execute command: %w", err)
+	}
+
+	return nil
+}
+
 // Lock ...
 func (d *MySQLDriver) Lock(ctx context.Context) error {
-	lock := fmt.Sprintf("migrate_%s_%s", d.database, d.table)
+	if d.tx == nil {
+		return ErrTransactionNotStarted
+	}
+
+	lock := d.lockName()
 
-	// TODO: Ideally there would be a timeout, and we'd keep retrying the acquire.
-	_, err := d.tx.ExecContext(ctx, fmt.Sprintf(`SELECT GET_LOCK("%s", -1)`, lock))
+	var acquired sql.NullInt64
+
+	// GET_LOCK returns 0 if the lock couldn't be acquired before the timeout, so it can be retried.
+	err := d.tx.QueryRowContext(ctx, `SELECT GET_LOCK(?, ?)`, lock, mysqlLockTimeout).Scan(&acquired)
 	if err != nil {
 		return fmt.Errorf("failed to acquire named lock: %s: %w", lock, err)
 	}
 
+	if !acquired.Valid || acquired.Int64 != 1 {
+		return fmt.Errorf("failed to acquire named lock: %s: %w", lock, errMySQLLockTimeout)
+	}
+
 	return nil
 }
 
-// Unlock must be explicitly implemented for MySQL.
+// LockScope is Lock, but uses a named lock specific to the given scope. Named locks are
+// independent of each other, so unlike with Postgres, runs using the default lock don't exclude
+// runs using a scope.
+func (d *MySQLDriver) LockScope(ctx context.Context, scope string) error {
+	d.scope = scope
+	return d.Lock(ctx)
+}
+
+// IsLockContention returns true if the given error is due to the named lock being held by another
+// connection for longer than the lock timeout.
+func (d *MySQLDriver) IsLockContention(err error) bool {
+	return errors.Is(err, errMySQLLockTimeout)
+}
+
+// Unlock must be explicitly implemented for MySQL. Named locks belong to the connection that
+// acquired them, so the lock is only reliably released when using WithMySQLPinnedConn.
 func (d *MySQLDriver) Unlock() {
 	ctx, cfn := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cfn()
 
-	lock := fmt.Sprintf("migrate_%s_%s", d.database, d.table)
+	lock := d.lockName()
+	d.scope = ""
+
+	_, err := d.conn.ExecContext(ctx, `SELECT RELEASE_LOCK(?)`, lock)
+	if err != nil {
+		log.Printf("migrate/mysql: failed to explicitly unlock: %v", err)
+	}
+}
+
+// ForceUnlock releases the named lock regardless of which connection holds it. MySQL only allows
+// the holder to release a named lock, so the holding connection is killed.
+func (d *MySQLDriver) ForceUnlock(ctx context.Context) error {
+	lock := d.lockName()
 
-	_, err := d.conn.ExecContext(ctx, fmt.Sprintf(`SELECT RELEASE_LOCK("%s")`, lock))
+	var holder sql.NullInt64
+
+	err := d.db.QueryRowContext(ctx, `SELECT IS_USED_LOCK(?)`, lock).Scan(&holder)
+	if err != nil {
+		return fmt.Errorf("failed to find named lock holder: %s: %w", lock, err)
+	}
+
+	if !holder.Valid {
+		log.Printf("migrate/mysql: named lock %s is not held, nothing to unlock", lock)
+		return nil
+	}
+
+	log.Printf("migrate/mysql: killing connection %d to release named lock %s", holder.Int64, lock)
+
+	_, err = d.db.ExecContext(ctx, fmt.Sprintf(`KILL %d`, holder.Int64))
 	if err != nil {
-		log.Println("migrate/mysql: failed to explicitly unlock: %v", err)
+		return fmt.Errorf("failed to kill named lock holder: %d: %w", holder.Int64, err)
+	}
+
+	return nil
+}
+
+// lockName returns the name of the named lock used to lock the versions table, in the current
+// scope if there is one.
+func (d *MySQLDriver) lockName() string {
+	if d.scope != "" {
+		return fmt.Sprintf("migrate_%s_%s_%s", d.database, d.table, d.scope)
 	}
+
+	return fmt.Sprintf("migrate_%s_%s", d.database, d.table)
 }
 
 // CreateVersionsTable ...
 func (d *MySQLDriver) CreateVersionsTable(ctx context.Context) error {
-	dbq := fmt.Sprintf(`CREATE DATABASE IF NOT EXISTS %s DEFAULT CHARACTER SET utf8mb4`, d.database)
-	tbq := fmt.Sprintf(`
-		CREATE TABLE IF NOT EXISTS %s.%s (
-			version int NOT NULL,
-			migrated_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	ctx, cfn := d.bookkeepingContext(ctx)
+	defer cfn()
 
-			PRIMARY KEY (version)
-		) ENGINE=InnoDB DEFAULT CHARACTER SET=utf8mb4
-	`, d.database, d.table)
+	dbq := fmt.Sprintf(`CREATE DATABASE IF NOT EXISTS %s DEFAULT CHARACTER SET utf8mb4`, mysqlQuote(d.database))
+	tbq := d.createVersionsTableQuery()
 
 	_, err := d.conn.ExecContext(ctx, dbq)
 	if err != nil {
@@ -141,9 +404,18 @@ func (d *MySQLDriver) CreateVersionsTable(ctx context.Context) error {
 
 // InsertVersion ...
 func (d *MySQLDriver) InsertVersion(ctx context.Context, version int) error {
-	query := fmt.Sprintf(`INSERT INTO %s.%s (version) VALUES (?)`, d.database, d.table)
+	if d.tx == nil {
+		return ErrTransactionNotStarted
+	}
 
-	res, err := d.tx.ExecContext(ctx, query, version)
+	query := fmt.Sprintf(`INSERT INTO %s (%s) VALUES (?)`, d.tableName(""), mysqlVersionColumn.name)
+	args := []interface{}{version}
+	if d.shared {
+		query = fmt.Sprintf(`INSERT INTO %s (%s, %s) VALUES (?, ?)`, d.tableName(""), mysqlVersionColumn.name, mysqlNamespaceColumn.name)
+		args = append(args, d.namespace)
+	}
+
+	res, err := d.tx.ExecContext(ctx, query, args...)
 	if err != nil {
 		return fmt.Errorf("failed to insert version: %w", err)
 	}
@@ -162,9 +434,61 @@ func (d *MySQLDriver) InsertVersion(ctx context.Context, version int) error {
 
 // Versions ...
 func (d *MySQLDriver) Versions(ctx context.Context) ([]int, error) {
-	query := fmt.Sprintf(`SELECT version FROM %s.%s`, d.database, d.table)
+	if d.tx == nil {
+		return d.VersionsReadOnly(ctx)
+	}
 
-	rows, err := d.tx.QueryContext(ctx, query)
+	return d.versions(ctx, d.tx.QueryContext)
+}
+
+// VersionsReadOnly returns the recorded versions using the connection, rather than the
+// transaction, so it doesn't need a transaction to have begun, and doesn't lock anything.
+func (d *MySQLDriver) VersionsReadOnly(ctx context.Context) ([]int, error) {
+	return d.versions(ctx, d.conn.QueryContext)
+}
+
+// AppliedAt ...
+func (d *MySQLDriver) AppliedAt(ctx context.Context) (map[int]time.Time, error) {
+	ctx, cfn := d.bookkeepingContext(ctx)
+	defer cfn()
+
+	// The timestamp is read as a Unix time, as scanning it into a time.Time depends on how the
+	// MySQL driver package is configured (i.e. parseTime).
+	filter, args := d.namespaceFilter()
+	query := fmt.Sprintf(`SELECT %s, UNIX_TIMESTAMP(%s) FROM %s WHERE %s`, mysqlVersionColumn.name, mysqlMigratedAtColumn.name, d.tableName(""), filter)
+
+	rows, err := d.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query applied times: %w", err)
+	}
+
+	defer rows.Close()
+
+	appliedAt := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var migratedAt int64
+
+		err := rows.Scan(&version, &migratedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan applied time: %w", err)
+		}
+
+		appliedAt[version] = time.Unix(migratedAt, 0).UTC()
+	}
+
+	return appliedAt, rows.Err()
+}
+
+// versions returns the recorded versions, using the given query function.
+func (d *MySQLDriver) versions(ctx context.Context, queryFn func(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)) ([]int, error) {
+	ctx, cfn := d.bookkeepingContext(ctx)
+	defer cfn()
+
+	filter, args := d.namespaceFilter()
+	query := fmt.Sprintf(`SELECT %s FROM %s WHERE %s`, mysqlVersionColumn.name, d.tableName(""), filter)
+
+	rows, err := queryFn(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query current versions: %w", err)
 	}
@@ -183,11 +507,18 @@ func (d *MySQLDriver) Versions(ctx context.Context) ([]int, error) {
 		versions = append(versions, version)
 	}
 
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query current versions: %w", err)
+	}
+
 	return versions, nil
 }
 
 // VersionTableExists ...
 func (d *MySQLDriver) VersionTableExists(ctx context.Context) (bool, error) {
+	ctx, cfn := d.bookkeepingContext(ctx)
+	defer cfn()
+
 	var count int
 
 	query := `
@@ -204,3 +535,910 @@ func (d *MySQLDriver) VersionTableExists(ctx context.Context) (bool, error) {
 
 	return count == 1, nil
 }
+
+// CreateChecksumColumn ...
+func (d *MySQLDriver) CreateChecksumColumn(ctx context.Context) error {
+	return d.addColumn(ctx, "", mysqlChecksumColumn)
+}
+
+// CreateToolVersionColumn ...
+func (d *MySQLDriver) CreateToolVersionColumn(ctx context.Context) error {
+	return d.addColumn(ctx, "", mysqlToolVersionColumn)
+}
+
+// SetToolVersion ...
+func (d *MySQLDriver) SetToolVersion(ctx context.Context, version int, toolVersion string) error {
+	if d.tx == nil {
+		return ErrTransactionNotStarted
+	}
+
+	filter, args := d.namespaceFilter(toolVersion, version)
+	query := fmt.Sprintf(`UPDATE %s SET %s = ? WHERE %s = ? AND %s`, d.tableName(""), mysqlToolVersionColumn.name, mysqlVersionColumn.name, filter)
+
+	_, err := d.tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to set tool version: %w", err)
+	}
+
+	return nil
+}
+
+// CreateDescriptionColumn ...
+func (d *MySQLDriver) CreateDescriptionColumn(ctx context.Context) error {
+	return d.addColumn(ctx, "", mysqlDescriptionColumn)
+}
+
+// SetDescription ...
+func (d *MySQLDriver) SetDescription(ctx context.Context, version int, description string) error {
+	if d.tx == nil {
+		return ErrTransactionNotStarted
+	}
+
+	filter, args := d.namespaceFilter(description, version)
+	query := fmt.Sprintf(`UPDATE %s SET %s = ? WHERE %s = ? AND %s`, d.tableName(""), mysqlDescriptionColumn.name, mysqlVersionColumn.name, filter)
+
+	_, err := d.tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to set description: %w", err)
+	}
+
+	return nil
+}
+
+// addColumn adds a column to the versions table, or one of its side tables if suffix is given, if it
+// doesn't already exist.
+func (d *MySQLDriver) addColumn(ctx context.Context, suffix string, column versionsColumn) error {
+	// MySQL doesn't support ADD COLUMN IF NOT EXISTS, so we have to check for it ourselves.
+	exists, err := d.columnExists(ctx, suffix, column)
+	if err != nil || exists {
+		return err
+	}
+
+	alter := fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s`, d.tableName(suffix), column.ddl())
+
+	_, err = d.conn.ExecContext(ctx, alter)
+	if err != nil {
+		return fmt.Errorf("failed to add %s column: %w", column.name, err)
+	}
+
+	return nil
+}
+
+// columnExists returns true if the column exists in the versions table, or one of its side tables
+// if suffix is given.
+func (d *MySQLDriver) columnExists(ctx context.Context, suffix string, column versionsColumn) (bool, error) {
+	var count int
+
+	query := `
+		SELECT COUNT(1)
+		FROM information_schema.columns
+		WHERE table_schema = ?
+		AND table_name = ?
+		AND column_name = ?
+	`
+
+	err := d.conn.QueryRowContext(ctx, query, d.database, d.table+suffix, column.name).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to check if %s column exists: %w", column.name, err)
+	}
+
+	return count > 0, nil
+}
+
+// SetChecksum ...
+func (d *MySQLDriver) SetChecksum(ctx context.Context, version int, checksum string) error {
+	if d.tx == nil {
+		return ErrTransactionNotStarted
+	}
+
+	filter, args := d.namespaceFilter(checksum, version)
+	query := fmt.Sprintf(`UPDATE %s SET %s = ? WHERE %s = ? AND %s`, d.tableName(""), mysqlChecksumColumn.name, mysqlVersionColumn.name, filter)
+
+	_, err := d.tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to set checksum: %w", err)
+	}
+
+	return nil
+}
+
+// Checksums ...
+func (d *MySQLDriver) Checksums(ctx context.Context) (map[int]string, error) {
+	if d.tx == nil {
+		return nil, ErrTransactionNotStarted
+	}
+
+	filter, args := d.namespaceFilter()
+	query := fmt.Sprintf(`SELECT %[2]s, %[3]s FROM %[1]s WHERE %[3]s <> '' AND %[4]s`, d.tableName(""), mysqlVersionColumn.name, mysqlChecksumColumn.name, filter)
+
+	rows, err := d.tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query checksums: %w", err)
+	}
+
+	defer rows.Close()
+
+	checksums := make(map[int]string)
+	for rows.Next() {
+		var version int
+		var checksum string
+
+		err := rows.Scan(&version, &checksum)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan checksum: %w", err)
+		}
+
+		checksums[version] = checksum
+	}
+
+	return checksums, rows.Err()
+}
+
+// CreateMetadataTable ...
+func (d *MySQLDriver) CreateMetadataTable(ctx context.Context) error {
+	query := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			name varchar(255) NOT NULL,
+			value text NOT NULL,
+
+			PRIMARY KEY (name)
+		) ENGINE=InnoDB DEFAULT CHARACTER SET=utf8mb4
+	`, d.tableName("_metadata"))
+
+	_, err := d.conn.ExecContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to create metadata table: %w", err)
+	}
+
+	return nil
+}
+
+// MetadataTableExists ...
+func (d *MySQLDriver) MetadataTableExists(ctx context.Context) (bool, error) {
+	var count int
+
+	query := `
+		SELECT COUNT(1)
+		FROM information_schema.tables
+		WHERE table_schema = ?
+		AND table_name = ?
+	`
+
+	err := d.conn.QueryRowContext(ctx, query, d.database, d.table+"_metadata").Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to check if metadata table exists: %w", err)
+	}
+
+	return count == 1, nil
+}
+
+// Metadata ...
+func (d *MySQLDriver) Metadata(ctx context.Context, key string) (string, error) {
+	if d.tx == nil {
+		return "", ErrTransactionNotStarted
+	}
+
+	var value string
+
+	query := fmt.Sprintf(`SELECT value FROM %s WHERE name = ?`, d.tableName("_metadata"))
+
+	err := d.tx.QueryRowContext(ctx, query, d.metadataKey(key)).Scan(&value)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("failed to query metadata: %w", err)
+	}
+
+	return value, nil
+}
+
+// metadataKey returns the name that key is stored under in the metadata table, which is prefixed
+// with the namespace if the versions table is shared, so each namespace has its own metadata.
+func (d *MySQLDriver) metadataKey(key string) string {
+	if !d.shared {
+		return key
+	}
+
+	return d.namespace + ":" + key
+}
+
+// SetMetadata ...
+func (d *MySQLDriver) SetMetadata(ctx context.Context, key, value string) error {
+	if d.tx == nil {
+		return ErrTransactionNotStarted
+	}
+
+	query := fmt.Sprintf(`
+		INSERT INTO %s (name, value) VALUES (?, ?)
+		ON DUPLICATE KEY UPDATE value = VALUES(value)
+	`, d.tableName("_metadata"))
+
+	_, err := d.tx.ExecContext(ctx, query, d.metadataKey(key), value)
+	if err != nil {
+		return fmt.Errorf("failed to set metadata: %w", err)
+	}
+
+	return nil
+}
+
+// RewriteVersion ...
+func (d *MySQLDriver) RewriteVersion(ctx context.Context, from, to int) error {
+	if d.tx == nil {
+		return ErrTransactionNotStarted
+	}
+
+	filter, args := d.namespaceFilter(to, from)
+	query := fmt.Sprintf(`UPDATE %[1]s SET %[2]s = ? WHERE %[2]s = ? AND %[3]s`, d.tableName(""), mysqlVersionColumn.name, filter)
+
+	_, err := d.tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to rewrite version: %w", err)
+	}
+
+	return nil
+}
+
+// InsertVersions ...
+func (d *MySQLDriver) InsertVersions(ctx context.Context, versions []int) error {
+	if d.tx == nil {
+		return ErrTransactionNotStarted
+	}
+
+	for len(versions) > 0 {
+		n := len(versions)
+		if n > maxBatchInsert {
+			n = maxBatchInsert
+		}
+
+		columns, row := mysqlVersionColumn.name, "(?)"
+		if d.shared {
+			columns, row = columns+", "+mysqlNamespaceColumn.name, "(?, ?)"
+		}
+
+		values := make([]string, n)
+		args := make([]interface{}, 0, 2*n)
+		for i, version := range versions[:n] {
+			values[i] = row
+			args = append(args, version)
+			if d.shared {
+				args = append(args, d.namespace)
+			}
+		}
+
+		query := fmt.Sprintf(`INSERT INTO %s (%s) VALUES %s`, d.tableName(""), columns, strings.Join(values, ", "))
+
+		res, err := d.tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return fmt.Errorf("failed to insert versions: %w", err)
+		}
+
+		ra, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get rows affected by insert versions: %w", err)
+		}
+
+		if ra != int64(n) {
+			return fmt.Errorf("expected %d new version rows to be inserted, but %d rows affected", n, ra)
+		}
+
+		versions = versions[n:]
+	}
+
+	return nil
+}
+
+// ExecReader ...
+func (d *MySQLDriver) ExecReader(ctx context.Context, r io.Reader) error {
+	if d.tx == nil {
+		return ErrTransactionNotStarted
+	}
+
+	scanner := newStatementScanner(r, DialectMySQL)
+
+	for i := 0; scanner.Scan(); i++ {
+		_, err := d.tx.ExecContext(ctx, scanner.Statement())
+		if err != nil {
+			return fmt.Errorf("failed to execute statement %d: %w", i, err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read statements: %w", err)
+	}
+
+	return nil
+}
+
+// ExecResult ...
+func (d *MySQLDriver) ExecResult(ctx context.Context, command string) (int64, error) {
+	if d.tx == nil {
+		return 0, ErrTransactionNotStarted
+	}
+
+	res, err := d.tx.ExecContext(ctx, command)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute command: %w", err)
+	}
+
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected by command: %w", err)
+	}
+
+	return ra, nil
+}
+
+// DeleteVersion ...
+func (d *MySQLDriver) DeleteVersion(ctx context.Context, version int) error {
+	if d.tx == nil {
+		return ErrTransactionNotStarted
+	}
+
+	filter, args := d.namespaceFilter(version)
+	query := fmt.Sprintf(`DELETE FROM %s WHERE %s = ? AND %s`, d.tableName(""), mysqlVersionColumn.name, filter)
+
+	_, err := d.tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to delete version: %w", err)
+	}
+
+	return nil
+}
+
+// bookkeepingContext returns a context for a bookkeeping query, bounded by the bookkeeping timeout
+// if one is set.
+func (d *MySQLDriver) bookkeepingContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d.bookkeepingTimeout <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, d.bookkeepingTimeout)
+}
+
+// CreateRunLogTable ...
+func (d *MySQLDriver) CreateRunLogTable(ctx context.Context) error {
+	query := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			id bigint unsigned NOT NULL AUTO_INCREMENT,
+			started_at datetime(6) NOT NULL,
+			namespace varchar(255) NOT NULL,
+			versions text NOT NULL,
+			outcome varchar(32) NOT NULL,
+			duration_ms bigint NOT NULL,
+			operator varchar(255) NOT NULL,
+			error text NOT NULL,
+
+			PRIMARY KEY (id),
+			KEY namespace (namespace)
+		) ENGINE=InnoDB DEFAULT CHARACTER SET=utf8mb4
+	`, d.tableName("_runs"))
+
+	_, err := d.conn.ExecContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to create run log table: %w", err)
+	}
+
+	for _, column := range mysqlRunLogColumns {
+		err = d.addColumn(ctx, "_runs", column)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// InsertRunLog ...
+func (d *MySQLDriver) InsertRunLog(ctx context.Context, entry RunLogEntry) error {
+	if d.tx == nil {
+		return ErrTransactionNotStarted
+	}
+
+	query := fmt.Sprintf(`
+		INSERT INTO %s (run_id, started_at, finished_at, namespace, versions, outcome, duration_ms,
+			operator, host, app_version, error)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, d.tableName("_runs"))
+
+	_, err := d.tx.ExecContext(ctx, query, entry.RunID, entry.StartedAt.UTC(), entry.FinishedAt.UTC(),
+		entry.Namespace, joinVersions(entry.Versions), entry.Outcome, entry.Duration.Milliseconds(),
+		entry.Operator, entry.Host, entry.AppVersion, entry.Error)
+	if err != nil {
+		return fmt.Errorf("failed to insert run log entry: %w", err)
+	}
+
+	return nil
+}
+
+// RunLog ...
+func (d *MySQLDriver) RunLog(ctx context.Context, namespace string) ([]RunLogEntry, error) {
+	// Selected as a string, as parsing DATETIME columns depends on the DSN's parseTime setting.
+	query := fmt.Sprintf(`
+		SELECT run_id, DATE_FORMAT(started_at, '%%Y-%%m-%%d %%H:%%i:%%s.%%f'),
+			COALESCE(DATE_FORMAT(finished_at, '%%Y-%%m-%%d %%H:%%i:%%s.%%f'), ''), namespace, versions,
+			outcome, duration_ms, operator, host, app_version, error
+		FROM %s
+		WHERE namespace = ?
+		ORDER BY id
+	`, d.tableName("_runs"))
+
+	rows, err := d.conn.QueryContext(ctx, query, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query run log: %w", err)
+	}
+
+	defer rows.Close()
+
+	var entries []RunLogEntry
+	for rows.Next() {
+		var entry RunLogEntry
+		var startedAt, finishedAt, versions string
+		var durationMS int64
+
+		err := rows.Scan(&entry.RunID, &startedAt, &finishedAt, &entry.Namespace, &versions,
+			&entry.Outcome, &durationMS, &entry.Operator, &entry.Host, &entry.AppVersion, &entry.Error)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan run log entry: %w", err)
+		}
+
+		entry.StartedAt, err = time.Parse("2006-01-02 15:04:05.999999", startedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse run log entry start time: %w", err)
+		}
+
+		// Runs recorded before finished_at was added don't have it.
+		if finishedAt != "" {
+			entry.FinishedAt, err = time.Parse("2006-01-02 15:04:05.999999", finishedAt)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse run log entry finish time: %w", err)
+			}
+		}
+
+		entry.Versions, err = splitVersions(versions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse run log entry versions: %w", err)
+		}
+
+		entry.Duration = time.Duration(durationMS) * time.Millisecond
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query run log: %w", err)
+	}
+
+	return entries, nil
+}
+
+// CreateChecksumTable ...
+func (d *MySQLDriver) CreateChecksumTable(ctx context.Context) error {
+	if d.shared {
+		return fmt.Errorf("checksum table can't be used with a shared versions table: %w", ErrSharedVersionsTableNotSupported)
+	}
+
+	query := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			version int NOT NULL,
+			checksum varchar(255) NOT NULL,
+
+			PRIMARY KEY (version)
+		) ENGINE=InnoDB DEFAULT CHARACTER SET=utf8mb4
+	`, d.tableName("_checksums"))
+
+	_, err := d.conn.ExecContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to create checksum table: %w", err)
+	}
+
+	return nil
+}
+
+// SetTableChecksum ...
+func (d *MySQLDriver) SetTableChecksum(ctx context.Context, version int, checksum string) error {
+	if d.tx == nil {
+		return ErrTransactionNotStarted
+	}
+
+	// A checksum may be left behind by a version that has since been unrecorded.
+	query := fmt.Sprintf(`
+		INSERT INTO %s (version, checksum) VALUES (?, ?)
+		ON DUPLICATE KEY UPDATE checksum = VALUES(checksum)
+	`, d.tableName("_checksums"))
+
+	_, err := d.tx.ExecContext(ctx, query, version, checksum)
+	if err != nil {
+		return fmt.Errorf("failed to set checksum: %w", err)
+	}
+
+	return nil
+}
+
+// TableChecksums ...
+func (d *MySQLDriver) TableChecksums(ctx context.Context) (map[int]string, error) {
+	if d.tx == nil {
+		return nil, ErrTransactionNotStarted
+	}
+
+	query := fmt.Sprintf(`
+		SELECT c.version, c.checksum
+		FROM %s c
+		JOIN %s v ON v.%s = c.version
+	`, d.tableName("_checksums"), d.tableName(""), mysqlVersionColumn.name)
+
+	rows, err := d.tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query checksums: %w", err)
+	}
+
+	defer rows.Close()
+
+	checksums := make(map[int]string)
+	for rows.Next() {
+		var version int
+		var checksum string
+
+		err := rows.Scan(&version, &checksum)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan checksum: %w", err)
+		}
+
+		checksums[version] = checksum
+	}
+
+	return checksums, rows.Err()
+}
+
+// mysqlErrorNumberPattern matches the error number in the message of a MySQL server error. The
+// error is matched by message so that the driver isn't tied to a particular MySQL driver package.
+var mysqlErrorNumberPattern = regexp.MustCompile(`Error (\d{4})\b`)
+
+// ClassifyError ...
+func (d *MySQLDriver) ClassifyError(err error) ErrorClass {
+	if errors.Is(err, errMySQLLockTimeout) {
+		return ErrorClassLockTimeout
+	}
+
+	if errors.Is(err, driver.ErrBadConn) {
+		return ErrorClassTransient
+	}
+
+	match := mysqlErrorNumberPattern.FindStringSubmatch(err.Error())
+	if match == nil {
+		return ErrorClassUnknown
+	}
+
+	switch match[1] {
+	case "1007", "1050", "1060", "1061", "1826":
+		return ErrorClassAlreadyExists
+	case "1049", "1054", "1091", "1146":
+		return ErrorClassNotFound
+	case "1044", "1045", "1142", "1143", "1227":
+		return ErrorClassPermissionDenied
+	case "1064":
+		return ErrorClassSyntax
+	case "1205":
+		return ErrorClassLockTimeout
+	case "1213":
+		return ErrorClassSerialization
+	case "1040", "2006", "2013":
+		return ErrorClassTransient
+	}
+
+	return ErrorClassUnknown
+}
+
+// CreateRepeatableTable ...
+func (d *MySQLDriver) CreateRepeatableTable(ctx context.Context) error {
+	if d.shared {
+		return fmt.Errorf("repeatable migrations can't be used with a shared versions table: %w", ErrSharedVersionsTableNotSupported)
+	}
+
+	query := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			name varchar(255) NOT NULL,
+			checksum varchar(255) NOT NULL,
+			migrated_at timestamp NOT NULL DEFAULT current_timestamp,
+
+			PRIMARY KEY (name)
+		) ENGINE=InnoDB DEFAULT CHARACTER SET=utf8mb4
+	`, d.tableName("_repeats"))
+
+	_, err := d.conn.ExecContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to create repeatable migrations table: %w", err)
+	}
+
+	return nil
+}
+
+// RepeatableChecksums ...
+func (d *MySQLDriver) RepeatableChecksums(ctx context.Context) (map[string]string, error) {
+	if d.tx == nil {
+		return nil, ErrTransactionNotStarted
+	}
+
+	rows, err := d.tx.QueryContext(ctx, fmt.Sprintf(`SELECT name, checksum FROM %s`, d.tableName("_repeats")))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query repeatable migration checksums: %w", err)
+	}
+
+	defer rows.Close()
+
+	checksums := make(map[string]string)
+	for rows.Next() {
+		var name, checksum string
+
+		err := rows.Scan(&name, &checksum)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan repeatable migration checksum: %w", err)
+		}
+
+		checksums[name] = checksum
+	}
+
+	return checksums, rows.Err()
+}
+
+// SetRepeatableChecksum ...
+func (d *MySQLDriver) SetRepeatableChecksum(ctx context.Context, name, checksum string) error {
+	if d.tx == nil {
+		return ErrTransactionNotStarted
+	}
+
+	query := fmt.Sprintf(`
+		INSERT INTO %s (name, checksum) VALUES (?, ?)
+		ON DUPLICATE KEY UPDATE checksum = VALUES(checksum), migrated_at = current_timestamp
+	`, d.tableName("_repeats"))
+
+	_, err := d.tx.ExecContext(ctx, query, name, checksum)
+	if err != nil {
+		return fmt.Errorf("failed to set repeatable migration checksum: %w", err)
+	}
+
+	return nil
+}
+
+// CreateCheckpointTable ...
+func (d *MySQLDriver) CreateCheckpointTable(ctx context.Context) error {
+	if d.shared {
+		return fmt.Errorf("checkpoints can't be used with a shared versions table: %w", ErrSharedVersionsTableNotSupported)
+	}
+
+	query := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			version int NOT NULL,
+			completed int NOT NULL,
+			checksum varchar(255) NOT NULL,
+			updated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+			PRIMARY KEY (version)
+		) ENGINE=InnoDB DEFAULT CHARACTER SET=utf8mb4
+	`, d.tableName("_progress"))
+
+	_, err := d.conn.ExecContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to create checkpoint table: %w", err)
+	}
+
+	return nil
+}
+
+// Checkpoint ...
+func (d *MySQLDriver) Checkpoint(ctx context.Context, version int) (int, string, error) {
+	if d.tx == nil {
+		return 0, "", ErrTransactionNotStarted
+	}
+
+	var completed int
+	var checksum string
+
+	query := fmt.Sprintf(`SELECT completed, checksum FROM %s WHERE version = ?`, d.tableName("_progress"))
+
+	err := d.tx.QueryRowContext(ctx, query, version).Scan(&completed, &checksum)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, "", fmt.Errorf("failed to query checkpoint: %w", err)
+	}
+
+	return completed, checksum, nil
+}
+
+// SetCheckpoint ...
+func (d *MySQLDriver) SetCheckpoint(ctx context.Context, version, completed int, checksum string) error {
+	query := fmt.Sprintf(`
+		INSERT INTO %s (version, completed, checksum) VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			completed = VALUES(completed), checksum = VALUES(checksum), updated_at = CURRENT_TIMESTAMP
+	`, d.tableName("_progress"))
+
+	// This always uses the pool, like ExecNoTransaction, so it's committed immediately.
+	_, err := d.db.ExecContext(ctx, query, version, completed, checksum)
+	if err != nil {
+		return fmt.Errorf("failed to set checkpoint: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteCheckpoint ...
+func (d *MySQLDriver) DeleteCheckpoint(ctx context.Context, version int) error {
+	if d.tx == nil {
+		return ErrTransactionNotStarted
+	}
+
+	_, err := d.tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE version = ?`, d.tableName("_progress")), version)
+	if err != nil {
+		return fmt.Errorf("failed to delete checkpoint: %w", err)
+	}
+
+	return nil
+}
+
+// ForTable returns a copy of the driver using the given versions table, sharing the pool.
+func (d *MySQLDriver) ForTable(table string) (Driver, error) {
+	if d.tx != nil {
+		return nil, ErrTransactionAlreadyStarted
+	}
+
+	if err := ValidateIdentifier(table, mysqlMaxIdentifierLen-len("_checksums")); err != nil {
+		return nil, err
+	}
+
+	copied := *d
+	copied.table = table
+
+	return &copied, nil
+}
+
+// ForNamespace returns a copy of the driver that shares its versions table with other namespaces,
+// only seeing and recording the given namespace's versions, using a namespace column. A versions
+// table created without one is upgraded by CreateNamespaceColumn. The whole table is still locked
+// while migrating, so runs in different namespaces wait for each other.
+func (d *MySQLDriver) ForNamespace(namespace string) (Driver, error) {
+	if d.tx != nil {
+		return nil, ErrTransactionAlreadyStarted
+	}
+
+	copied := *d
+	copied.shared = true
+	copied.namespace = namespace
+
+	return &copied, nil
+}
+
+// CreateNamespaceColumn ...
+func (d *MySQLDriver) CreateNamespaceColumn(ctx context.Context) error {
+	exists, err := d.columnExists(ctx, "", mysqlNamespaceColumn)
+	if err != nil || exists {
+		return err
+	}
+
+	// This is one statement, so the table is never left with the column, but the old primary key.
+	alter := fmt.Sprintf(
+		`ALTER TABLE %s ADD COLUMN %s, DROP PRIMARY KEY, ADD PRIMARY KEY (%s, %s)`,
+		d.tableName(""), mysqlNamespaceColumn.ddl(), mysqlNamespaceColumn.name, mysqlVersionColumn.name,
+	)
+
+	_, err = d.conn.ExecContext(ctx, alter)
+	if err != nil {
+		// Another process may have added it since we checked.
+		if exists, cerr := d.columnExists(ctx, "", mysqlNamespaceColumn); cerr == nil && exists {
+			return nil
+		}
+
+		return fmt.Errorf("failed to add %s column: %w", mysqlNamespaceColumn.name, err)
+	}
+
+	return nil
+}
+
+// RenameNamespace ...
+func (d *MySQLDriver) RenameNamespace(ctx context.Context, oldName, newName string) error {
+	if d.tx == nil {
+		return ErrTransactionNotStarted
+	}
+
+	if !d.shared {
+		return ErrNamespacesNotSupported
+	}
+
+	query := fmt.Sprintf(`UPDATE %[1]s SET %[2]s = ? WHERE %[2]s = ?`, d.tableName(""), mysqlNamespaceColumn.name)
+
+	_, err := d.tx.ExecContext(ctx, query, newName, oldName)
+	if err != nil {
+		return fmt.Errorf("failed to rename namespace: %w", err)
+	}
+
+	// Both namespaces' versions have changed, so both signatures must be, or they'd look tampered.
+	return refreshNamespaceSignatures(ctx, d.inNamespace(oldName), d.inNamespace(newName))
+}
+
+// inNamespace returns a copy of the driver bound to the given namespace, which shares its
+// transaction, unlike ForNamespace.
+func (d *MySQLDriver) inNamespace(namespace string) *MySQLDriver {
+	copied := *d
+	copied.namespace = namespace
+
+	return &copied
+}
+
+// createVersionsTableQuery returns the query that creates the versions table, if it doesn't exist.
+// A shared versions table has a namespace column too, which is part of the primary key.
+func (d *MySQLDriver) createVersionsTableQuery() string {
+	columns, key := mysqlVersionsTableColumns, mysqlVersionColumn.name
+	if d.shared {
+		columns = append([]versionsColumn{mysqlNamespaceColumn}, columns...)
+		key = mysqlNamespaceColumn.name + ", " + key
+	}
+
+	return fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			%s,
+
+			PRIMARY KEY (%s)
+		) ENGINE=InnoDB DEFAULT CHARACTER SET=utf8mb4
+	`, d.tableName(""), columnsDDL(columns), key)
+}
+
+// RecreateVersionsTable ...
+func (d *MySQLDriver) RecreateVersionsTable(ctx context.Context) error {
+	if d.tx == nil {
+		return ErrTransactionNotStarted
+	}
+
+	// Dropping a shared versions table would lose every other namespace's versions too.
+	if d.shared {
+		return fmt.Errorf("versions table can't be recreated while shared: %w", ErrSharedVersionsTableNotSupported)
+	}
+
+	// DDL implicitly commits in MySQL, but the named lock is held by the session, not the
+	// transaction, so the versions table stays locked.
+	_, err := d.tx.ExecContext(ctx, fmt.Sprintf(`DROP TABLE %s`, d.tableName("")))
+	if err != nil {
+		return fmt.Errorf("failed to drop versions table: %w", err)
+	}
+
+	_, err = d.tx.ExecContext(ctx, d.createVersionsTableQuery())
+	if err != nil {
+		return fmt.Errorf("failed to create versions table: %w", err)
+	}
+
+	return nil
+}
+
+// Info returns the identity of the database the driver operates against.
+func (d *MySQLDriver) Info() DriverInfo {
+	return DriverInfo{
+		Dialect:  DialectMySQL,
+		Database: d.database,
+		Table:    d.table,
+	}
+}
+
+// IsSchemaEmpty ...
+func (d *MySQLDriver) IsSchemaEmpty(ctx context.Context) (bool, error) {
+	ctx, cfn := d.bookkeepingContext(ctx)
+	defer cfn()
+
+	tables := ownTables(d.table)
+
+	args := []interface{}{d.database}
+	placeholders := make([]string, len(tables))
+	for i, table := range tables {
+		args = append(args, table)
+		placeholders[i] = "?"
+	}
+
+	var count int
+
+	query := fmt.Sprintf(`
+		SELECT COUNT(1)
+		FROM information_schema.tables
+		WHERE table_schema = ?
+		AND table_name NOT IN (%s)
+	`, strings.Join(placeholders, ", "))
+
+	err := d.conn.QueryRowContext(ctx, query, args...).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to check if schema is empty: %w", err)
+	}
+
+	return count == 0, nil
+}
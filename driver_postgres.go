@@ -5,27 +5,186 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"io"
+	"strings"
+	"time"
 
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/jackc/puddle"
 )
 
+// pgMaxIdentifierLen is the maximum length of an identifier in Postgres.
+const pgMaxIdentifierLen = 63
+
+// pgLockNotAvailable is the Postgres error code for lock_not_available.
+const pgLockNotAvailable = "55P03"
+
+// The columns of the Postgres versions table.
+var (
+	pgVersionColumn     = versionsColumn{name: "version", definition: "int NOT NULL"}
+	pgMigratedAtColumn  = versionsColumn{name: "migrated_at", definition: "timestamp NOT NULL DEFAULT current_timestamp"}
+	pgChecksumColumn    = versionsColumn{name: "checksum", definition: "text NOT NULL DEFAULT ''"}
+	pgToolVersionColumn = versionsColumn{name: "tool_version", definition: "text NULL"}
+	pgDescriptionColumn = versionsColumn{name: "description", definition: "text NULL"}
+	pgNamespaceColumn   = versionsColumn{name: "namespace", definition: "text NOT NULL DEFAULT ''"}
+)
+
+// pgRunLogColumns are the columns added to the run log table after it was first released, so that
+// run log tables created before then are upgraded.
+var pgRunLogColumns = []versionsColumn{
+	{name: "run_id", definition: "text NOT NULL DEFAULT ''"},
+	{name: "finished_at", definition: "timestamptz NULL"},
+	{name: "host", definition: "text NOT NULL DEFAULT ''"},
+	{name: "app_version", definition: "text NOT NULL DEFAULT ''"},
+}
+
+// pgVersionsTableColumns are the columns the versions table is created with. The rest are added
+// when a feature that needs them is first used.
+var pgVersionsTableColumns = []versionsColumn{pgVersionColumn, pgMigratedAtColumn}
+
 // PostgresDriver ...
 type PostgresDriver struct {
-	conn   *pgxpool.Pool
+	pool   *pgxpool.Pool
+	conn   pgxQuerier
 	tx     pgx.Tx
 	schema string
 	table  string
+
+	// shared is set by ForNamespace, restricting the versions table to namespace's rows.
+	shared    bool
+	namespace string
+
+	bookkeepingTimeout time.Duration
+
+	pin    bool
+	setup  func(ctx context.Context, conn *pgx.Conn) error
+	pinned *pgxpool.Conn
+
+	// host and database are those of the connection the first transaction was started on, for
+	// Info, as the pool's configuration isn't exposed.
+	host     string
+	database string
+}
+
+// tableName returns the quoted, schema-qualified name of the versions table, or of one of its
+// side tables if suffix is given (e.g. "_metadata").
+func (d *PostgresDriver) tableName(suffix string) string {
+	return pgQuote(d.schema) + "." + pgQuote(d.table+suffix)
+}
+
+// namespaceFilter returns a condition restricting the versions table to the driver's namespace,
+// using the placeholder $n, and args with the namespace appended, if the table is shared.
+// Otherwise, it returns a condition that's always true, and args unchanged.
+func (d *PostgresDriver) namespaceFilter(n int, args ...interface{}) (string, []interface{}) {
+	if !d.shared {
+		return "TRUE", args
+	}
+
+	return fmt.Sprintf("%s = $%d", pgNamespaceColumn.name, n), append(args, d.namespace)
+}
+
+// pgQuote quotes the given identifier, so that reserved words (e.g. "order") can be used. It's
+// lowercased first, as Postgres folds unquoted identifiers to lowercase, so that quoting doesn't
+// change which table is used. Identifiers are validated, so never contain quotes themselves.
+func pgQuote(identifier string) string {
+	return `"` + strings.ToLower(identifier) + `"`
+}
+
+// pgxQuerier is the set of methods shared by *pgxpool.Pool and *pgxpool.Conn that the driver uses,
+// allowing queries to run on either the pool, or a pinned connection.
+type pgxQuerier interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// PostgresOption configures optional behaviour of a PostgresDriver.
+type PostgresOption func(*PostgresDriver)
+
+// WithPostgresPinnedConn makes the driver acquire a single connection from the pool when Execute
+// starts, and use it for every query until Execute finishes, releasing it afterwards. This keeps
+// session state (e.g. settings, advisory locks) coherent, as it all lives on one backend. If setup
+// is not nil, it's called on the connection once it's acquired, e.g. to set application_name so
+// that migrations are easy to identify in pg_stat_activity.
+func WithPostgresPinnedConn(setup func(ctx context.Context, conn *pgx.Conn) error) PostgresOption {
+	return func(d *PostgresDriver) {
+		d.pin = true
+		d.setup = setup
+	}
+}
+
+// WithPostgresBookkeepingTimeout bounds each of the driver's bookkeeping queries (checking for,
+// creating, and reading the versions table) with its own timeout, separate from the overall
+// Execute timeout, so that a hung metadata query fails fast instead of using up the whole budget.
+func WithPostgresBookkeepingTimeout(d time.Duration) PostgresOption {
+	return func(driver *PostgresDriver) {
+		driver.bookkeepingTimeout = d
+	}
 }
 
-// NewPostgresDriver returns a new PostgresDriver instance.
-// TODO: Config...
-func NewPostgresDriver(conn *pgxpool.Pool, schema, table string) *PostgresDriver {
-	return &PostgresDriver{
+// NewPostgresDriver returns a new PostgresDriver instance. The schema and table names are used in
+// queries, so an error is returned if either isn't a safe identifier.
+func NewPostgresDriver(conn *pgxpool.Pool, schema, table string, opts ...PostgresOption) (*PostgresDriver, error) {
+	for _, name := range []string{schema, table} {
+		// The side table names have suffixes, the longest of which must fit too.
+		if err := ValidateIdentifier(name, pgMaxIdentifierLen-len("_checksums")); err != nil {
+			return nil, err
+		}
+	}
+
+	d := &PostgresDriver{
+		pool:   conn,
 		conn:   conn,
 		schema: schema,
 		table:  table,
 	}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	return d, nil
+}
+
+// Open acquires the pinned connection, if the driver is configured to use one.
+func (d *PostgresDriver) Open(ctx context.Context) error {
+	if !d.pin || d.pinned != nil {
+		return nil
+	}
+
+	conn, err := d.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %w", d.pgError(err))
+	}
+
+	if d.setup != nil {
+		err = d.setup(ctx, conn.Conn())
+		if err != nil {
+			conn.Release()
+			return fmt.Errorf("failed to set up connection: %w", d.pgError(err))
+		}
+	}
+
+	d.pinned = conn
+	d.conn = conn
+
+	return nil
+}
+
+// Close releases the pinned connection back to the pool, if one was acquired.
+func (d *PostgresDriver) Close(_ context.Context) error {
+	if d.pinned == nil {
+		return nil
+	}
+
+	d.pinned.Release()
+	d.pinned = nil
+	d.conn = d.pool
+
+	return nil
 }
 
 // Begin ...
@@ -36,7 +195,17 @@ func (d *PostgresDriver) Begin(ctx context.Context) error {
 
 	tx, err := d.conn.Begin(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to start transaction: %w", err)
+		return fmt.Errorf("failed to start transaction: %w", d.pgError(err))
+	}
+
+	if d.database == "" {
+		err = tx.QueryRow(ctx, `SELECT current_database()`).Scan(&d.database)
+		if err != nil {
+			_ = tx.Rollback(ctx)
+			return fmt.Errorf("failed to query current database: %w", d.pgError(err))
+		}
+
+		d.host = tx.Conn().PgConn().Conn().RemoteAddr().String()
 	}
 
 	d.tx = tx
@@ -49,9 +218,11 @@ func (d *PostgresDriver) Commit(ctx context.Context) error {
 		return ErrTransactionNotStarted
 	}
 
+	defer func() { d.tx = nil }()
+
 	err := d.tx.Commit(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
+		return fmt.Errorf("failed to commit transaction: %w", d.pgError(err))
 	}
 
 	return nil
@@ -63,9 +234,40 @@ func (d *PostgresDriver) Rollback(ctx context.Context) error {
 		return ErrTransactionNotStarted
 	}
 
+	defer func() { d.tx = nil }()
+
 	err := d.tx.Rollback(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to rollback transaction: %w", err)
+		return fmt.Errorf("failed to rollback transaction: %w", d.pgError(err))
+	}
+
+	return nil
+}
+
+// Savepoint ...
+func (d *PostgresDriver) Savepoint(ctx context.Context, name string) error {
+	return d.execSavepoint(ctx, "SAVEPOINT "+name)
+}
+
+// RollbackToSavepoint ...
+func (d *PostgresDriver) RollbackToSavepoint(ctx context.Context, name string) error {
+	return d.execSavepoint(ctx, "ROLLBACK TO SAVEPOINT "+name)
+}
+
+// ReleaseSavepoint ...
+func (d *PostgresDriver) ReleaseSavepoint(ctx context.Context, name string) error {
+	return d.execSavepoint(ctx, "RELEASE SAVEPOINT "+name)
+}
+
+// execSavepoint executes the given savepoint statement in the transaction.
+func (d *PostgresDriver) execSavepoint(ctx context.Context, query string) error {
+	if d.tx == nil {
+		return ErrTransactionNotStarted
+	}
+
+	_, err := d.tx.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to execute %q: %w", query, d.pgError(err))
 	}
 
 	return nil
@@ -79,7 +281,18 @@ func (d *PostgresDriver) Exec(ctx context.Context, command string) error {
 
 	_, err := d.tx.Exec(ctx, command)
 	if err != nil {
-		return fmt.Errorf("failed to execute command: %w", err)
+		return fmt.Errorf("failed to execute command: %w", d.pgError(err))
+	}
+
+	return nil
+}
+
+// ExecNoTransaction ...
+func (d *PostgresDriver) ExecNoTransaction(ctx context.Context, command string) error {
+	// This always uses the pool, as a pinned connection would be busy with the transaction.
+	_, err := d.pool.Exec(ctx, command)
+	if err != nil {
+		return fmt.Errorf("failed to execute command: %w", d.pgError(err))
 	}
 
 	return nil
@@ -87,9 +300,91 @@ func (d *PostgresDriver) Exec(ctx context.Context, command string) error {
 
 // Lock ...
 func (d *PostgresDriver) Lock(ctx context.Context) error {
-	_, err := d.tx.Exec(ctx, fmt.Sprintf("LOCK TABLE %s.%s IN ACCESS EXCLUSIVE MODE", d.schema, d.table))
+	if d.tx == nil {
+		return ErrTransactionNotStarted
+	}
+
+	_, err := d.tx.Exec(ctx, fmt.Sprintf("LOCK TABLE %s IN ACCESS EXCLUSIVE MODE", d.tableName("")))
+	if err != nil {
+		return fmt.Errorf("failed to lock versions table: %w", d.pgError(err))
+	}
+
+	return nil
+}
+
+// LockScope takes a ROW EXCLUSIVE lock on the versions table, which doesn't conflict with other
+// scoped runs, but does with unscoped runs' ACCESS EXCLUSIVE lock, and then a transaction-level
+// advisory lock specific to the given scope, so only runs in the same scope exclude each other.
+func (d *PostgresDriver) LockScope(ctx context.Context, scope string) error {
+	if d.tx == nil {
+		return ErrTransactionNotStarted
+	}
+
+	_, err := d.tx.Exec(ctx, fmt.Sprintf("LOCK TABLE %s IN ROW EXCLUSIVE MODE", d.tableName("")))
 	if err != nil {
-		return fmt.Errorf("failed to lock versions table: %w", err)
+		return fmt.Errorf("failed to lock versions table: %w", d.pgError(err))
+	}
+
+	key := fmt.Sprintf("%s.%s:%s", d.schema, d.table, scope)
+
+	_, err = d.tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, key)
+	if err != nil {
+		return fmt.Errorf("failed to lock scope: %s: %w", scope, d.pgError(err))
+	}
+
+	return nil
+}
+
+// IsLockContention returns true if the given error is due to the versions table being locked by
+// another transaction for longer than the session's lock_timeout. Without a lock_timeout, Lock
+// waits for the lock indefinitely (or until the context is done) instead.
+func (d *PostgresDriver) IsLockContention(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgLockNotAvailable
+}
+
+// ForceUnlock terminates any other backends holding the ACCESS EXCLUSIVE lock that Lock takes on
+// the versions table. Table locks are only released when the holding transaction ends, so this is
+// the only way to release them. Backends holding weaker locks, e.g. plain readers, are left alone.
+func (d *PostgresDriver) ForceUnlock(ctx context.Context) error {
+	query := fmt.Sprintf(`
+		SELECT DISTINCT pid
+		FROM pg_locks
+		WHERE locktype = 'relation'
+		AND relation = to_regclass('%s')
+		AND mode = 'AccessExclusiveLock'
+		AND granted
+		AND pid <> pg_backend_pid()
+	`, d.tableName(""))
+
+	rows, err := d.conn.Query(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to query versions table lock holders: %w", d.pgError(err))
+	}
+
+	defer rows.Close()
+
+	var pids []int
+	for rows.Next() {
+		var pid int
+
+		err := rows.Scan(&pid)
+		if err != nil {
+			return fmt.Errorf("failed to scan versions table lock holder: %w", d.pgError(err))
+		}
+
+		pids = append(pids, pid)
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to query versions table lock holders: %w", d.pgError(err))
+	}
+
+	for _, pid := range pids {
+		_, err := d.conn.Exec(ctx, `SELECT pg_terminate_backend($1)`, pid)
+		if err != nil {
+			return fmt.Errorf("failed to terminate versions table lock holder: %d: %w", pid, d.pgError(err))
+		}
 	}
 
 	return nil
@@ -97,21 +392,14 @@ func (d *PostgresDriver) Lock(ctx context.Context) error {
 
 // CreateVersionsTable ...
 func (d *PostgresDriver) CreateVersionsTable(ctx context.Context) error {
+	ctx, cfn := d.bookkeepingContext(ctx)
+	defer cfn()
+
 	// We use IF NOT EXISTS here because we're not doing this part in a transaction or with any sort
 	// of lock. If the table already exists, then we can just skip creating it.
-	query := fmt.Sprintf(`
-		CREATE SCHEMA IF NOT EXISTS %[1]s;
-		CREATE TABLE IF NOT EXISTS %[1]s.%[2]s (
-			version int NOT NULL,
-			migrated_at timestamp NOT NULL DEFAULT current_timestamp,
-
-			PRIMARY KEY (version)
-		);
-	`, d.schema, d.table)
-
-	_, err := d.conn.Exec(ctx, query)
+	_, err := d.conn.Exec(ctx, d.createVersionsTableQuery())
 	if err != nil {
-		return fmt.Errorf("failed to create versions table: %w", err)
+		return fmt.Errorf("failed to create versions table: %w", d.pgError(err))
 	}
 
 	return nil
@@ -119,11 +407,20 @@ func (d *PostgresDriver) CreateVersionsTable(ctx context.Context) error {
 
 // InsertVersion ...
 func (d *PostgresDriver) InsertVersion(ctx context.Context, version int) error {
-	query := fmt.Sprintf(`INSERT INTO %s.%s (version) VALUES ($1)`, d.schema, d.table)
+	if d.tx == nil {
+		return ErrTransactionNotStarted
+	}
+
+	query := fmt.Sprintf(`INSERT INTO %s (%s) VALUES ($1)`, d.tableName(""), pgVersionColumn.name)
+	args := []interface{}{version}
+	if d.shared {
+		query = fmt.Sprintf(`INSERT INTO %s (%s, %s) VALUES ($1, $2)`, d.tableName(""), pgVersionColumn.name, pgNamespaceColumn.name)
+		args = append(args, d.namespace)
+	}
 
-	res, err := d.tx.Exec(ctx, query, version)
+	res, err := d.tx.Exec(ctx, query, args...)
 	if err != nil {
-		return fmt.Errorf("failed to insert version: %w", err)
+		return fmt.Errorf("failed to insert version: %w", d.pgError(err))
 	}
 
 	if res.RowsAffected() == 0 {
@@ -135,11 +432,61 @@ func (d *PostgresDriver) InsertVersion(ctx context.Context, version int) error {
 
 // Versions ...
 func (d *PostgresDriver) Versions(ctx context.Context) ([]int, error) {
-	query := fmt.Sprintf(`SELECT version FROM %s.%s`, d.schema, d.table)
+	if d.tx == nil {
+		return d.VersionsReadOnly(ctx)
+	}
 
-	rows, err := d.tx.Query(ctx, query)
+	return d.versions(ctx, d.tx.Query)
+}
+
+// VersionsReadOnly returns the recorded versions using the connection, rather than the
+// transaction, so it doesn't need a transaction to have begun, and doesn't lock anything.
+func (d *PostgresDriver) VersionsReadOnly(ctx context.Context) ([]int, error) {
+	return d.versions(ctx, d.conn.Query)
+}
+
+// AppliedAt ...
+func (d *PostgresDriver) AppliedAt(ctx context.Context) (map[int]time.Time, error) {
+	ctx, cfn := d.bookkeepingContext(ctx)
+	defer cfn()
+
+	filter, args := d.namespaceFilter(1)
+	query := fmt.Sprintf(`SELECT %s, %s FROM %s WHERE %s`, pgVersionColumn.name, pgMigratedAtColumn.name, d.tableName(""), filter)
+
+	rows, err := d.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query applied times: %w", d.pgError(err))
+	}
+
+	defer rows.Close()
+
+	appliedAt := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var migratedAt time.Time
+
+		err := rows.Scan(&version, &migratedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan applied time: %w", d.pgError(err))
+		}
+
+		appliedAt[version] = migratedAt
+	}
+
+	return appliedAt, rows.Err()
+}
+
+// versions returns the recorded versions, using the given query function.
+func (d *PostgresDriver) versions(ctx context.Context, queryFn func(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)) ([]int, error) {
+	ctx, cfn := d.bookkeepingContext(ctx)
+	defer cfn()
+
+	filter, args := d.namespaceFilter(1)
+	query := fmt.Sprintf(`SELECT %s FROM %s WHERE %s`, pgVersionColumn.name, d.tableName(""), filter)
+
+	rows, err := queryFn(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query current versions: %w", err)
+		return nil, fmt.Errorf("failed to query current versions: %w", d.pgError(err))
 	}
 
 	defer rows.Close()
@@ -150,25 +497,1030 @@ func (d *PostgresDriver) Versions(ctx context.Context) ([]int, error) {
 
 		err := rows.Scan(&version)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan current version: %w", err)
+			return nil, fmt.Errorf("failed to scan current version: %w", d.pgError(err))
 		}
 
 		versions = append(versions, version)
 	}
 
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query current versions: %w", d.pgError(err))
+	}
+
 	return versions, nil
 }
 
 // VersionTableExists ...
 func (d *PostgresDriver) VersionTableExists(ctx context.Context) (bool, error) {
+	ctx, cfn := d.bookkeepingContext(ctx)
+	defer cfn()
+
 	var name sql.NullString
 
-	query := fmt.Sprintf(`SELECT to_regclass('%s.%s')::text`, d.schema, d.table)
+	query := fmt.Sprintf(`SELECT to_regclass('%s')::text`, d.tableName(""))
 
 	err := d.conn.QueryRow(ctx, query).Scan(&name)
 	if err != nil {
-		return false, err
+		return false, fmt.Errorf("failed to check if version table exists: %w", d.pgError(err))
 	}
 
 	return name.Valid, nil
 }
+
+// CreateChecksumColumn ...
+func (d *PostgresDriver) CreateChecksumColumn(ctx context.Context) error {
+	query := fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s`, d.tableName(""), pgChecksumColumn.ddl())
+
+	_, err := d.conn.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to add checksum column: %w", d.pgError(err))
+	}
+
+	return nil
+}
+
+// CreateToolVersionColumn ...
+func (d *PostgresDriver) CreateToolVersionColumn(ctx context.Context) error {
+	query := fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s`, d.tableName(""), pgToolVersionColumn.ddl())
+
+	_, err := d.conn.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to add tool version column: %w", d.pgError(err))
+	}
+
+	return nil
+}
+
+// SetToolVersion ...
+func (d *PostgresDriver) SetToolVersion(ctx context.Context, version int, toolVersion string) error {
+	if d.tx == nil {
+		return ErrTransactionNotStarted
+	}
+
+	filter, args := d.namespaceFilter(3, toolVersion, version)
+	query := fmt.Sprintf(`UPDATE %s SET %s = $1 WHERE %s = $2 AND %s`, d.tableName(""), pgToolVersionColumn.name, pgVersionColumn.name, filter)
+
+	_, err := d.tx.Exec(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to set tool version: %w", d.pgError(err))
+	}
+
+	return nil
+}
+
+// CreateDescriptionColumn ...
+func (d *PostgresDriver) CreateDescriptionColumn(ctx context.Context) error {
+	query := fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s`, d.tableName(""), pgDescriptionColumn.ddl())
+
+	_, err := d.conn.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to add description column: %w", d.pgError(err))
+	}
+
+	return nil
+}
+
+// SetDescription ...
+func (d *PostgresDriver) SetDescription(ctx context.Context, version int, description string) error {
+	if d.tx == nil {
+		return ErrTransactionNotStarted
+	}
+
+	filter, args := d.namespaceFilter(3, description, version)
+	query := fmt.Sprintf(`UPDATE %s SET %s = $1 WHERE %s = $2 AND %s`, d.tableName(""), pgDescriptionColumn.name, pgVersionColumn.name, filter)
+
+	_, err := d.tx.Exec(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to set description: %w", d.pgError(err))
+	}
+
+	return nil
+}
+
+// SetChecksum ...
+func (d *PostgresDriver) SetChecksum(ctx context.Context, version int, checksum string) error {
+	if d.tx == nil {
+		return ErrTransactionNotStarted
+	}
+
+	filter, args := d.namespaceFilter(3, checksum, version)
+	query := fmt.Sprintf(`UPDATE %s SET %s = $1 WHERE %s = $2 AND %s`, d.tableName(""), pgChecksumColumn.name, pgVersionColumn.name, filter)
+
+	_, err := d.tx.Exec(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to set checksum: %w", d.pgError(err))
+	}
+
+	return nil
+}
+
+// Checksums ...
+func (d *PostgresDriver) Checksums(ctx context.Context) (map[int]string, error) {
+	if d.tx == nil {
+		return nil, ErrTransactionNotStarted
+	}
+
+	filter, args := d.namespaceFilter(1)
+	query := fmt.Sprintf(`SELECT %[2]s, %[3]s FROM %[1]s WHERE %[3]s <> '' AND %[4]s`, d.tableName(""), pgVersionColumn.name, pgChecksumColumn.name, filter)
+
+	rows, err := d.tx.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query checksums: %w", d.pgError(err))
+	}
+
+	defer rows.Close()
+
+	checksums := make(map[int]string)
+	for rows.Next() {
+		var version int
+		var checksum string
+
+		err := rows.Scan(&version, &checksum)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan checksum: %w", d.pgError(err))
+		}
+
+		checksums[version] = checksum
+	}
+
+	return checksums, rows.Err()
+}
+
+// CreateMetadataTable ...
+func (d *PostgresDriver) CreateMetadataTable(ctx context.Context) error {
+	query := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			name text NOT NULL,
+			value text NOT NULL,
+
+			PRIMARY KEY (name)
+		)
+	`, d.tableName("_metadata"))
+
+	_, err := d.conn.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to create metadata table: %w", d.pgError(err))
+	}
+
+	return nil
+}
+
+// MetadataTableExists ...
+func (d *PostgresDriver) MetadataTableExists(ctx context.Context) (bool, error) {
+	var name sql.NullString
+
+	query := fmt.Sprintf(`SELECT to_regclass('%s')::text`, d.tableName("_metadata"))
+
+	err := d.conn.QueryRow(ctx, query).Scan(&name)
+	if err != nil {
+		return false, fmt.Errorf("failed to check if metadata table exists: %w", d.pgError(err))
+	}
+
+	return name.Valid, nil
+}
+
+// Metadata ...
+func (d *PostgresDriver) Metadata(ctx context.Context, key string) (string, error) {
+	if d.tx == nil {
+		return "", ErrTransactionNotStarted
+	}
+
+	var value string
+
+	query := fmt.Sprintf(`SELECT value FROM %s WHERE name = $1`, d.tableName("_metadata"))
+
+	err := d.tx.QueryRow(ctx, query, d.metadataKey(key)).Scan(&value)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return "", fmt.Errorf("failed to query metadata: %w", d.pgError(err))
+	}
+
+	return value, nil
+}
+
+// metadataKey returns the name that key is stored under in the metadata table, which is prefixed
+// with the namespace if the versions table is shared, so each namespace has its own metadata.
+func (d *PostgresDriver) metadataKey(key string) string {
+	if !d.shared {
+		return key
+	}
+
+	return d.namespace + ":" + key
+}
+
+// SetMetadata ...
+func (d *PostgresDriver) SetMetadata(ctx context.Context, key, value string) error {
+	if d.tx == nil {
+		return ErrTransactionNotStarted
+	}
+
+	query := fmt.Sprintf(`
+		INSERT INTO %s (name, value) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET value = EXCLUDED.value
+	`, d.tableName("_metadata"))
+
+	_, err := d.tx.Exec(ctx, query, d.metadataKey(key), value)
+	if err != nil {
+		return fmt.Errorf("failed to set metadata: %w", d.pgError(err))
+	}
+
+	return nil
+}
+
+// RewriteVersion ...
+func (d *PostgresDriver) RewriteVersion(ctx context.Context, from, to int) error {
+	if d.tx == nil {
+		return ErrTransactionNotStarted
+	}
+
+	filter, args := d.namespaceFilter(3, to, from)
+	query := fmt.Sprintf(`UPDATE %[1]s SET %[2]s = $1 WHERE %[2]s = $2 AND %[3]s`, d.tableName(""), pgVersionColumn.name, filter)
+
+	_, err := d.tx.Exec(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to rewrite version: %w", d.pgError(err))
+	}
+
+	return nil
+}
+
+// pgError returns the given error, marked as ErrConnectionClosed if the pool was closed, or the
+// connection the driver is using has been closed, e.g. during a graceful shutdown.
+func (d *PostgresDriver) pgError(err error) error {
+	if errors.Is(err, puddle.ErrClosedPool) || d.connClosed() {
+		return fmt.Errorf("%v: %w", err, ErrConnectionClosed)
+	}
+
+	return err
+}
+
+// connClosed returns true if the connection the driver is using, for its transaction or because
+// it's pinned, has been closed. Queries on the pool don't use any one connection.
+func (d *PostgresDriver) connClosed() bool {
+	switch {
+	case d.tx != nil:
+		return d.tx.Conn().IsClosed()
+	case d.pinned != nil:
+		return d.pinned.Conn().IsClosed()
+	}
+
+	return false
+}
+
+// InsertVersions ...
+func (d *PostgresDriver) InsertVersions(ctx context.Context, versions []int) error {
+	if d.tx == nil {
+		return ErrTransactionNotStarted
+	}
+
+	for len(versions) > 0 {
+		n := len(versions)
+		if n > maxBatchInsert {
+			n = maxBatchInsert
+		}
+
+		values := make([]string, n)
+		args := make([]interface{}, n)
+		for i, version := range versions[:n] {
+			values[i] = fmt.Sprintf("($%d)", i+1)
+			args[i] = version
+		}
+
+		columns := pgVersionColumn.name
+		if d.shared {
+			// Every row shares the one namespace argument, after the versions.
+			columns += ", " + pgNamespaceColumn.name
+			for i := range values {
+				values[i] = fmt.Sprintf("($%d, $%d)", i+1, n+1)
+			}
+			args = append(args, d.namespace)
+		}
+
+		query := fmt.Sprintf(`INSERT INTO %s (%s) VALUES %s`, d.tableName(""), columns, strings.Join(values, ", "))
+
+		res, err := d.tx.Exec(ctx, query, args...)
+		if err != nil {
+			return fmt.Errorf("failed to insert versions: %w", d.pgError(err))
+		}
+
+		if res.RowsAffected() != int64(n) {
+			return fmt.Errorf("expected %d new version rows to be inserted, but %d rows affected", n, res.RowsAffected())
+		}
+
+		versions = versions[n:]
+	}
+
+	return nil
+}
+
+// ExecReader ...
+func (d *PostgresDriver) ExecReader(ctx context.Context, r io.Reader) error {
+	if d.tx == nil {
+		return ErrTransactionNotStarted
+	}
+
+	scanner := newStatementScanner(r, DialectPostgres)
+
+	for i := 0; scanner.Scan(); i++ {
+		_, err := d.tx.Exec(ctx, scanner.Statement())
+		if err != nil {
+			return fmt.Errorf("failed to execute statement %d: %w", i, d.pgError(err))
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read statements: %w", err)
+	}
+
+	return nil
+}
+
+// ExecResult ...
+func (d *PostgresDriver) ExecResult(ctx context.Context, command string) (int64, error) {
+	if d.tx == nil {
+		return 0, ErrTransactionNotStarted
+	}
+
+	res, err := d.tx.Exec(ctx, command)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute command: %w", d.pgError(err))
+	}
+
+	return res.RowsAffected(), nil
+}
+
+// DetectInconsistentObjects returns the indexes marked INVALID, and the constraints marked NOT
+// VALID, in every schema. Queries run on the pool, as the transaction may have been aborted.
+func (d *PostgresDriver) DetectInconsistentObjects(ctx context.Context) ([]string, error) {
+	query := `
+		SELECT format('index %I.%I is INVALID', n.nspname, c.relname)
+		FROM pg_index i
+		JOIN pg_class c ON c.oid = i.indexrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE NOT i.indisvalid
+		UNION ALL
+		SELECT format('constraint %I on %I.%I is NOT VALID', co.conname, n.nspname, c.relname)
+		FROM pg_constraint co
+		JOIN pg_class c ON c.oid = co.conrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE NOT co.convalidated
+	`
+
+	rows, err := d.pool.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query inconsistent objects: %w", d.pgError(err))
+	}
+
+	defer rows.Close()
+
+	var objects []string
+	for rows.Next() {
+		var object string
+
+		err = rows.Scan(&object)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan inconsistent object: %w", err)
+		}
+
+		objects = append(objects, object)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read inconsistent objects: %w", d.pgError(err))
+	}
+
+	return objects, nil
+}
+
+// DeleteVersion ...
+func (d *PostgresDriver) DeleteVersion(ctx context.Context, version int) error {
+	if d.tx == nil {
+		return ErrTransactionNotStarted
+	}
+
+	filter, args := d.namespaceFilter(2, version)
+	query := fmt.Sprintf(`DELETE FROM %s WHERE %s = $1 AND %s`, d.tableName(""), pgVersionColumn.name, filter)
+
+	_, err := d.tx.Exec(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to delete version: %w", d.pgError(err))
+	}
+
+	return nil
+}
+
+// Notify ...
+func (d *PostgresDriver) Notify(ctx context.Context, channel, payload string) error {
+	_, err := d.conn.Exec(ctx, `SELECT pg_notify($1, $2)`, channel, payload)
+	if err != nil {
+		return fmt.Errorf("failed to notify: %w", d.pgError(err))
+	}
+
+	return nil
+}
+
+// bookkeepingContext returns a context for a bookkeeping query, bounded by the bookkeeping timeout
+// if one is set.
+func (d *PostgresDriver) bookkeepingContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d.bookkeepingTimeout <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, d.bookkeepingTimeout)
+}
+
+// CreateRunLogTable ...
+func (d *PostgresDriver) CreateRunLogTable(ctx context.Context) error {
+	query := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			id bigserial NOT NULL,
+			started_at timestamptz NOT NULL,
+			namespace text NOT NULL,
+			versions text NOT NULL,
+			outcome text NOT NULL,
+			duration_ms bigint NOT NULL,
+			operator text NOT NULL,
+			error text NOT NULL,
+
+			PRIMARY KEY (id)
+		)
+	`, d.tableName("_runs"))
+
+	_, err := d.conn.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to create run log table: %w", d.pgError(err))
+	}
+
+	for _, column := range pgRunLogColumns {
+		query := fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s`, d.tableName("_runs"), column.ddl())
+
+		_, err := d.conn.Exec(ctx, query)
+		if err != nil {
+			return fmt.Errorf("failed to add run log %s column: %w", column.name, d.pgError(err))
+		}
+	}
+
+	return nil
+}
+
+// InsertRunLog ...
+func (d *PostgresDriver) InsertRunLog(ctx context.Context, entry RunLogEntry) error {
+	if d.tx == nil {
+		return ErrTransactionNotStarted
+	}
+
+	query := fmt.Sprintf(`
+		INSERT INTO %s (run_id, started_at, finished_at, namespace, versions, outcome, duration_ms,
+			operator, host, app_version, error)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`, d.tableName("_runs"))
+
+	_, err := d.tx.Exec(ctx, query, entry.RunID, entry.StartedAt, entry.FinishedAt, entry.Namespace,
+		joinVersions(entry.Versions), entry.Outcome, entry.Duration.Milliseconds(), entry.Operator,
+		entry.Host, entry.AppVersion, entry.Error)
+	if err != nil {
+		return fmt.Errorf("failed to insert run log entry: %w", d.pgError(err))
+	}
+
+	return nil
+}
+
+// RunLog ...
+func (d *PostgresDriver) RunLog(ctx context.Context, namespace string) ([]RunLogEntry, error) {
+	query := fmt.Sprintf(`
+		SELECT run_id, started_at, finished_at, namespace, versions, outcome, duration_ms, operator,
+			host, app_version, error
+		FROM %s
+		WHERE namespace = $1
+		ORDER BY id
+	`, d.tableName("_runs"))
+
+	rows, err := d.conn.Query(ctx, query, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query run log: %w", d.pgError(err))
+	}
+
+	defer rows.Close()
+
+	var entries []RunLogEntry
+	for rows.Next() {
+		var entry RunLogEntry
+		var versions string
+		var durationMS int64
+		var finishedAt *time.Time
+
+		err := rows.Scan(&entry.RunID, &entry.StartedAt, &finishedAt, &entry.Namespace, &versions,
+			&entry.Outcome, &durationMS, &entry.Operator, &entry.Host, &entry.AppVersion, &entry.Error)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan run log entry: %w", d.pgError(err))
+		}
+
+		// Runs recorded before finished_at was added don't have it.
+		if finishedAt != nil {
+			entry.FinishedAt = *finishedAt
+		}
+
+		entry.Versions, err = splitVersions(versions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse run log entry versions: %w", err)
+		}
+
+		entry.Duration = time.Duration(durationMS) * time.Millisecond
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query run log: %w", d.pgError(err))
+	}
+
+	return entries, nil
+}
+
+// Explain ...
+func (d *PostgresDriver) Explain(ctx context.Context, command string) ([]string, error) {
+	// Plain EXPLAIN only plans the command, unlike EXPLAIN ANALYZE, which executes it.
+	rows, err := d.conn.Query(ctx, "EXPLAIN "+command)
+	if err != nil {
+		return nil, fmt.Errorf("failed to explain command: %w", d.pgError(err))
+	}
+
+	defer rows.Close()
+
+	var lines []string
+	for rows.Next() {
+		var line string
+
+		err := rows.Scan(&line)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan query plan: %w", d.pgError(err))
+		}
+
+		lines = append(lines, line)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to explain command: %w", d.pgError(err))
+	}
+
+	return lines, nil
+}
+
+// CreateChecksumTable ...
+func (d *PostgresDriver) CreateChecksumTable(ctx context.Context) error {
+	if d.shared {
+		return fmt.Errorf("checksum table can't be used with a shared versions table: %w", ErrSharedVersionsTableNotSupported)
+	}
+
+	query := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			version int NOT NULL,
+			checksum text NOT NULL,
+
+			PRIMARY KEY (version)
+		)
+	`, d.tableName("_checksums"))
+
+	_, err := d.conn.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to create checksum table: %w", d.pgError(err))
+	}
+
+	return nil
+}
+
+// SetTableChecksum ...
+func (d *PostgresDriver) SetTableChecksum(ctx context.Context, version int, checksum string) error {
+	if d.tx == nil {
+		return ErrTransactionNotStarted
+	}
+
+	// A checksum may be left behind by a version that has since been unrecorded.
+	query := fmt.Sprintf(`
+		INSERT INTO %s (version, checksum) VALUES ($1, $2)
+		ON CONFLICT (version) DO UPDATE SET checksum = EXCLUDED.checksum
+	`, d.tableName("_checksums"))
+
+	_, err := d.tx.Exec(ctx, query, version, checksum)
+	if err != nil {
+		return fmt.Errorf("failed to set checksum: %w", d.pgError(err))
+	}
+
+	return nil
+}
+
+// TableChecksums ...
+func (d *PostgresDriver) TableChecksums(ctx context.Context) (map[int]string, error) {
+	if d.tx == nil {
+		return nil, ErrTransactionNotStarted
+	}
+
+	query := fmt.Sprintf(`
+		SELECT c.version, c.checksum
+		FROM %s c
+		JOIN %s v ON v.%s = c.version
+	`, d.tableName("_checksums"), d.tableName(""), pgVersionColumn.name)
+
+	rows, err := d.tx.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query checksums: %w", d.pgError(err))
+	}
+
+	defer rows.Close()
+
+	checksums := make(map[int]string)
+	for rows.Next() {
+		var version int
+		var checksum string
+
+		err := rows.Scan(&version, &checksum)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan checksum: %w", d.pgError(err))
+		}
+
+		checksums[version] = checksum
+	}
+
+	return checksums, rows.Err()
+}
+
+// ClassifyError ...
+func (d *PostgresDriver) ClassifyError(err error) ErrorClass {
+	if errors.Is(err, ErrConnectionClosed) {
+		return ErrorClassTransient
+	}
+
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return ErrorClassUnknown
+	}
+
+	switch pgErr.Code {
+	case "42P07", "42710", "42701", "42P06", "42723", "42P04":
+		return ErrorClassAlreadyExists
+	case "42P01", "42703", "42704", "42883", "3F000", "3D000":
+		return ErrorClassNotFound
+	case "42501":
+		return ErrorClassPermissionDenied
+	case "42601":
+		return ErrorClassSyntax
+	case pgLockNotAvailable:
+		return ErrorClassLockTimeout
+	case "40001", "40P01":
+		return ErrorClassSerialization
+	}
+
+	// Other transaction rollbacks, connection exceptions, insufficient resources, and
+	// operator intervention (e.g. the server shutting down) are all worth retrying.
+	switch pgErr.Code[:2] {
+	case "40", "08", "53", "57":
+		return ErrorClassTransient
+	}
+
+	return ErrorClassUnknown
+}
+
+// CreateRepeatableTable ...
+func (d *PostgresDriver) CreateRepeatableTable(ctx context.Context) error {
+	if d.shared {
+		return fmt.Errorf("repeatable migrations can't be used with a shared versions table: %w", ErrSharedVersionsTableNotSupported)
+	}
+
+	query := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			name text NOT NULL,
+			checksum text NOT NULL,
+			migrated_at timestamp NOT NULL DEFAULT current_timestamp,
+
+			PRIMARY KEY (name)
+		)
+	`, d.tableName("_repeats"))
+
+	_, err := d.conn.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to create repeatable migrations table: %w", d.pgError(err))
+	}
+
+	return nil
+}
+
+// RepeatableChecksums ...
+func (d *PostgresDriver) RepeatableChecksums(ctx context.Context) (map[string]string, error) {
+	if d.tx == nil {
+		return nil, ErrTransactionNotStarted
+	}
+
+	rows, err := d.tx.Query(ctx, fmt.Sprintf(`SELECT name, checksum FROM %s`, d.tableName("_repeats")))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query repeatable migration checksums: %w", d.pgError(err))
+	}
+
+	defer rows.Close()
+
+	checksums := make(map[string]string)
+	for rows.Next() {
+		var name, checksum string
+
+		err := rows.Scan(&name, &checksum)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan repeatable migration checksum: %w", d.pgError(err))
+		}
+
+		checksums[name] = checksum
+	}
+
+	return checksums, rows.Err()
+}
+
+// SetRepeatableChecksum ...
+func (d *PostgresDriver) SetRepeatableChecksum(ctx context.Context, name, checksum string) error {
+	if d.tx == nil {
+		return ErrTransactionNotStarted
+	}
+
+	query := fmt.Sprintf(`
+		INSERT INTO %s (name, checksum) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET checksum = EXCLUDED.checksum, migrated_at = current_timestamp
+	`, d.tableName("_repeats"))
+
+	_, err := d.tx.Exec(ctx, query, name, checksum)
+	if err != nil {
+		return fmt.Errorf("failed to set repeatable migration checksum: %w", d.pgError(err))
+	}
+
+	return nil
+}
+
+// CreateCheckpointTable ...
+func (d *PostgresDriver) CreateCheckpointTable(ctx context.Context) error {
+	if d.shared {
+		return fmt.Errorf("checkpoints can't be used with a shared versions table: %w", ErrSharedVersionsTableNotSupported)
+	}
+
+	query := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			version int NOT NULL,
+			completed int NOT NULL,
+			checksum text NOT NULL,
+			updated_at timestamp NOT NULL DEFAULT current_timestamp,
+
+			PRIMARY KEY (version)
+		)
+	`, d.tableName("_progress"))
+
+	_, err := d.conn.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to create checkpoint table: %w", d.pgError(err))
+	}
+
+	return nil
+}
+
+// Checkpoint ...
+func (d *PostgresDriver) Checkpoint(ctx context.Context, version int) (int, string, error) {
+	if d.tx == nil {
+		return 0, "", ErrTransactionNotStarted
+	}
+
+	var completed int
+	var checksum string
+
+	query := fmt.Sprintf(`SELECT completed, checksum FROM %s WHERE version = $1`, d.tableName("_progress"))
+
+	err := d.tx.QueryRow(ctx, query, version).Scan(&completed, &checksum)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return 0, "", fmt.Errorf("failed to query checkpoint: %w", d.pgError(err))
+	}
+
+	return completed, checksum, nil
+}
+
+// SetCheckpoint ...
+func (d *PostgresDriver) SetCheckpoint(ctx context.Context, version, completed int, checksum string) error {
+	query := fmt.Sprintf(`
+		INSERT INTO %s (version, completed, checksum) VALUES ($1, $2, $3)
+		ON CONFLICT (version) DO UPDATE
+		SET completed = EXCLUDED.completed, checksum = EXCLUDED.checksum, updated_at = current_timestamp
+	`, d.tableName("_progress"))
+
+	// This always uses the pool, like ExecNoTransaction, so it's committed immediately.
+	_, err := d.pool.Exec(ctx, query, version, completed, checksum)
+	if err != nil {
+		return fmt.Errorf("failed to set checkpoint: %w", d.pgError(err))
+	}
+
+	return nil
+}
+
+// DeleteCheckpoint ...
+func (d *PostgresDriver) DeleteCheckpoint(ctx context.Context, version int) error {
+	if d.tx == nil {
+		return ErrTransactionNotStarted
+	}
+
+	_, err := d.tx.Exec(ctx, fmt.Sprintf(`DELETE FROM %s WHERE version = $1`, d.tableName("_progress")), version)
+	if err != nil {
+		return fmt.Errorf("failed to delete checkpoint: %w", d.pgError(err))
+	}
+
+	return nil
+}
+
+// ForTable returns a copy of the driver using the given versions table, sharing the pool.
+func (d *PostgresDriver) ForTable(table string) (Driver, error) {
+	if d.tx != nil {
+		return nil, ErrTransactionAlreadyStarted
+	}
+
+	if err := ValidateIdentifier(table, pgMaxIdentifierLen-len("_checksums")); err != nil {
+		return nil, err
+	}
+
+	copied := *d
+	copied.table = table
+
+	return &copied, nil
+}
+
+// ForNamespace returns a copy of the driver that shares its versions table with other namespaces,
+// only seeing and recording the given namespace's versions, using a namespace column. A versions
+// table created without one is upgraded by CreateNamespaceColumn. The whole table is still locked
+// while migrating, so runs in different namespaces wait for each other.
+func (d *PostgresDriver) ForNamespace(namespace string) (Driver, error) {
+	if d.tx != nil {
+		return nil, ErrTransactionAlreadyStarted
+	}
+
+	copied := *d
+	copied.shared = true
+	copied.namespace = namespace
+
+	return &copied, nil
+}
+
+// CreateNamespaceColumn ...
+func (d *PostgresDriver) CreateNamespaceColumn(ctx context.Context) error {
+	exists, err := d.namespaceColumnExists(ctx)
+	if err != nil || exists {
+		return err
+	}
+
+	// The primary key's name depends on how the table was created, so it has to be looked up.
+	var key string
+
+	query := `SELECT conname FROM pg_constraint WHERE conrelid = $1::regclass AND contype = 'p'`
+
+	err = d.conn.QueryRow(ctx, query, d.tableName("")).Scan(&key)
+	if err != nil {
+		return fmt.Errorf("failed to find versions table primary key: %w", d.pgError(err))
+	}
+
+	// This is one statement, so the table is never left with the column, but the old primary key.
+	alter := fmt.Sprintf(
+		`ALTER TABLE %s ADD COLUMN %s, DROP CONSTRAINT %s, ADD PRIMARY KEY (%s, %s)`,
+		d.tableName(""), pgNamespaceColumn.ddl(), pgx.Identifier{key}.Sanitize(), pgNamespaceColumn.name, pgVersionColumn.name,
+	)
+
+	_, err = d.conn.Exec(ctx, alter)
+	if err != nil {
+		// Another process may have added it since we checked.
+		if exists, cerr := d.namespaceColumnExists(ctx); cerr == nil && exists {
+			return nil
+		}
+
+		return fmt.Errorf("failed to add %s column: %w", pgNamespaceColumn.name, d.pgError(err))
+	}
+
+	return nil
+}
+
+// namespaceColumnExists returns true if the versions table has a namespace column.
+func (d *PostgresDriver) namespaceColumnExists(ctx context.Context) (bool, error) {
+	var exists bool
+
+	query := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM information_schema.columns
+			WHERE table_schema = $1
+			AND table_name = $2
+			AND column_name = $3
+		)
+	`
+
+	// Identifiers are lowercased when quoted, so are stored lowercase.
+	err := d.conn.QueryRow(ctx, query, strings.ToLower(d.schema), strings.ToLower(d.table), pgNamespaceColumn.name).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check if %s column exists: %w", pgNamespaceColumn.name, d.pgError(err))
+	}
+
+	return exists, nil
+}
+
+// RenameNamespace ...
+func (d *PostgresDriver) RenameNamespace(ctx context.Context, oldName, newName string) error {
+	if d.tx == nil {
+		return ErrTransactionNotStarted
+	}
+
+	if !d.shared {
+		return ErrNamespacesNotSupported
+	}
+
+	query := fmt.Sprintf(`UPDATE %[1]s SET %[2]s = $1 WHERE %[2]s = $2`, d.tableName(""), pgNamespaceColumn.name)
+
+	_, err := d.tx.Exec(ctx, query, newName, oldName)
+	if err != nil {
+		return fmt.Errorf("failed to rename namespace: %w", d.pgError(err))
+	}
+
+	// Both namespaces' versions have changed, so both signatures must be, or they'd look tampered.
+	return refreshNamespaceSignatures(ctx, d.inNamespace(oldName), d.inNamespace(newName))
+}
+
+// inNamespace returns a copy of the driver bound to the given namespace, which shares its
+// transaction, unlike ForNamespace.
+func (d *PostgresDriver) inNamespace(namespace string) *PostgresDriver {
+	copied := *d
+	copied.namespace = namespace
+
+	return &copied
+}
+
+// createVersionsTableQuery returns the query that creates the versions table, if it doesn't exist.
+// A shared versions table has a namespace column too, which is part of the primary key.
+func (d *PostgresDriver) createVersionsTableQuery() string {
+	columns, key := pgVersionsTableColumns, pgVersionColumn.name
+	if d.shared {
+		columns = append([]versionsColumn{pgNamespaceColumn}, columns...)
+		key = pgNamespaceColumn.name + ", " + key
+	}
+
+	return fmt.Sprintf(`
+		CREATE SCHEMA IF NOT EXISTS %s;
+		CREATE TABLE IF NOT EXISTS %s (
+			%s,
+
+			PRIMARY KEY (%s)
+		);
+	`, pgQuote(d.schema), d.tableName(""), columnsDDL(columns), key)
+}
+
+// RecreateVersionsTable ...
+func (d *PostgresDriver) RecreateVersionsTable(ctx context.Context) error {
+	if d.tx == nil {
+		return ErrTransactionNotStarted
+	}
+
+	// Dropping a shared versions table would lose every other namespace's versions too.
+	if d.shared {
+		return fmt.Errorf("versions table can't be recreated while shared: %w", ErrSharedVersionsTableNotSupported)
+	}
+
+	_, err := d.tx.Exec(ctx, fmt.Sprintf(`DROP TABLE %s`, d.tableName("")))
+	if err != nil {
+		return fmt.Errorf("failed to drop versions table: %w", d.pgError(err))
+	}
+
+	_, err = d.tx.Exec(ctx, d.createVersionsTableQuery())
+	if err != nil {
+		return fmt.Errorf("failed to create versions table: %w", d.pgError(err))
+	}
+
+	return nil
+}
+
+// Info returns the identity of the database the driver operates against. The host and database
+// are only known once a transaction has been started.
+func (d *PostgresDriver) Info() DriverInfo {
+	return DriverInfo{
+		Dialect:  DialectPostgres,
+		Host:     d.host,
+		Database: d.database,
+		Schema:   d.schema,
+		Table:    d.table,
+	}
+}
+
+// IsSchemaEmpty ...
+func (d *PostgresDriver) IsSchemaEmpty(ctx context.Context) (bool, error) {
+	ctx, cfn := d.bookkeepingContext(ctx)
+	defer cfn()
+
+	var count int
+
+	query := `
+		SELECT COUNT(1)
+		FROM information_schema.tables
+		WHERE table_schema = $1
+		AND table_name <> ALL($2)
+	`
+
+	// Identifiers are lowercased when quoted, so are stored lowercase.
+	tables := ownTables(strings.ToLower(d.table))
+
+	err := d.conn.QueryRow(ctx, query, strings.ToLower(d.schema), tables).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to check if schema is empty: %w", d.pgError(err))
+	}
+
+	return count == 0, nil
+}
@@ -0,0 +1,80 @@
+package migrate
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		identifier string
+		mysql      string
+		postgres   string
+	}{
+		{identifier: "order", mysql: "`order`", postgres: `"order"`},
+		{identifier: "Order", mysql: "`Order`", postgres: `"order"`},
+		{identifier: "schema_versions", mysql: "`schema_versions`", postgres: `"schema_versions"`},
+	}
+
+	for _, test := range tests {
+		if got := mysqlQuote(test.identifier); got != test.mysql {
+			t.Errorf("mysqlQuote(%q): expected %s, got %s", test.identifier, test.mysql, got)
+		}
+
+		if got := pgQuote(test.identifier); got != test.postgres {
+			t.Errorf("pgQuote(%q): expected %s, got %s", test.identifier, test.postgres, got)
+		}
+	}
+}
+
+func TestQueriesQuoteReservedWords(t *testing.T) {
+	mysql, err := NewMySQLDriver(nil, "select", "order")
+	if err != nil {
+		t.Fatalf("unexpected error creating MySQL driver: %v", err)
+	}
+
+	postgres, err := NewPostgresDriver(nil, "Select", "Order")
+	if err != nil {
+		t.Fatalf("unexpected error creating Postgres driver: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		expected []string
+	}{
+		{
+			name:     "mysql versions table",
+			query:    mysql.createVersionsTableQuery(),
+			expected: []string{"CREATE TABLE IF NOT EXISTS `select`.`order` ("},
+		},
+		{
+			name:     "mysql side table",
+			query:    mysql.tableName("_metadata"),
+			expected: []string{"`select`.`order_metadata`"},
+		},
+		{
+			// Unquoted, Postgres would fold Select.Order to select.order, so quoting must use the
+			// same table.
+			name:  "postgres versions table",
+			query: postgres.createVersionsTableQuery(),
+			expected: []string{
+				`CREATE SCHEMA IF NOT EXISTS "select";`,
+				`CREATE TABLE IF NOT EXISTS "select"."order" (`,
+			},
+		},
+		{
+			name:     "postgres side table",
+			query:    postgres.tableName("_metadata"),
+			expected: []string{`"select"."order_metadata"`},
+		},
+	}
+
+	for _, test := range tests {
+		for _, expected := range test.expected {
+			if !strings.Contains(test.query, expected) {
+				t.Errorf("%s: expected query to contain %s, got:\n%s", test.name, expected, test.query)
+			}
+		}
+	}
+}
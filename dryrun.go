@@ -0,0 +1,75 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// writeDryRun writes the commands of every pending migration in the given namespace to the dry
+// run writer, in the order Execute would apply them, without changing anything. Repeatable
+// migrations are always written, as whether they've changed can't be checked without a
+// transaction.
+func (o *options) writeDryRun(ctx context.Context, driver Driver, namespace string, migrationsByVersion Migrations, repeatables []RepeatableMigration) error {
+	applied, err := AppliedVersions(driver, ctx)
+	if err != nil {
+		return err
+	}
+
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "-- Dry run of namespace %q\n", namespace)
+
+	for _, version := range pendingVersions(migrationsByVersion, applied) {
+		migration := migrationsByVersion[version]
+
+		switch {
+		case o.leavePending(version), o.exclude[version]:
+			continue
+		case o.baselineRange != nil && version >= o.baselineRange[0] && version <= o.baselineRange[1]:
+			fmt.Fprintf(&sb, "\n-- Version %d (baselined, not executed)\n", version)
+			continue
+		case migration.isEmpty():
+			continue
+		}
+
+		fmt.Fprintf(&sb, "\n-- Version %d", version)
+		if migration.NoTransaction {
+			sb.WriteString(" (outside of a transaction)")
+		}
+
+		sb.WriteString("\n")
+
+		writeCommands(&sb, migration.Commands)
+
+		if migration.Source != nil {
+			sb.WriteString("-- (followed by the statements read from the migration's source)\n")
+		}
+
+		if migration.Func != nil {
+			sb.WriteString("-- (followed by a call to the migration's Go function)\n")
+		}
+	}
+
+	for _, migration := range repeatables {
+		fmt.Fprintf(&sb, "\n-- Repeatable %q (only if changed)\n", migration.Name)
+		writeCommands(&sb, migration.Commands)
+	}
+
+	_, err = o.dryRun.Write([]byte(sb.String()))
+	if err != nil {
+		return fmt.Errorf("failed to write dry run: %w", err)
+	}
+
+	return nil
+}
+
+// writeCommands writes each of the given commands, on their own lines.
+func writeCommands(sb *strings.Builder, commands []string) {
+	for _, command := range commands {
+		sb.WriteString(command)
+		if !strings.HasSuffix(command, "\n") {
+			sb.WriteString("\n")
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package migrate
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"unicode/utf8"
+)
+
+// utf8BOM is the UTF-8 byte order mark, which some editors insert at the start of files.
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// normalizeFile strips a leading UTF-8 BOM from the contents of a migration file, and normalizes
+// CRLF line endings to LF, as some drivers fail cryptically on the first statement otherwise.
+func normalizeFile(bs []byte) []byte {
+	bs = bytes.TrimPrefix(bs, utf8BOM)
+	return bytes.ReplaceAll(bs, []byte("\r\n"), []byte("\n"))
+}
+
+// checkEncoding returns a description of any encoding problem with the contents of a migration
+// file, or an empty string if there are none.
+func checkEncoding(bs []byte) string {
+	switch {
+	case bytes.HasPrefix(bs, utf8BOM):
+		return "file starts with a UTF-8 byte order mark"
+	case !utf8.Valid(bs):
+		return "file is not valid UTF-8"
+	}
+
+	return ""
+}
+
+// bomStrippingReader is a ReadCloser that skips a leading UTF-8 BOM. Line endings are left alone,
+// as streamed statements are split on semicolons.
+type bomStrippingReader struct {
+	*bufio.Reader
+	io.Closer
+}
+
+// newBOMStrippingReader returns a new bomStrippingReader reading from rc.
+func newBOMStrippingReader(rc io.ReadCloser) (io.ReadCloser, error) {
+	r := bufio.NewReader(rc)
+
+	prefix, err := r.Peek(len(utf8BOM))
+	if err != nil && err != io.EOF {
+		rc.Close()
+		return nil, err
+	}
+
+	if bytes.Equal(prefix, utf8BOM) {
+		_, _ = r.Discard(len(utf8BOM))
+	}
+
+	return bomStrippingReader{Reader: r, Closer: rc}, nil
+}
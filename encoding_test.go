@@ -0,0 +1,65 @@
+package migrate
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRegisterFSNormalizesEncoding(t *testing.T) {
+	const plain = "CREATE TABLE a (\n\tid int\n);\nCREATE TABLE b (id int);\n"
+
+	variants := map[string]string{
+		"bom":      "\xEF\xBB\xBF" + plain,
+		"crlf":     strings.ReplaceAll(plain, "\n", "\r\n"),
+		"bom+crlf": "\xEF\xBB\xBF" + strings.ReplaceAll(plain, "\n", "\r\n"),
+	}
+
+	register := func(t *testing.T, contents string, opts ...FSOption) Migration {
+		t.Helper()
+
+		r := NewRegistry()
+		fsys := fstest.MapFS{"1_init.sql": {Data: []byte(contents)}}
+
+		if err := r.RegisterFS("default", fsys, opts...); err != nil {
+			t.Fatalf("unexpected error registering: %v", err)
+		}
+
+		return r.registered("default")[1]
+	}
+
+	for _, split := range []bool{false, true} {
+		var opts []FSOption
+		if split {
+			opts = append(opts, WithStatementSplitting(DialectPostgres))
+		}
+
+		expected := register(t, plain, opts...)
+		if len(expected.Commands) == 0 {
+			t.Fatalf("expected the plain file to register commands")
+		}
+
+		for name, contents := range variants {
+			if got := register(t, contents, opts...); !reflect.DeepEqual(got.Commands, expected.Commands) {
+				t.Errorf("%s (splitting: %v): expected commands %q, got %q", name, split, expected.Commands, got.Commands)
+			}
+		}
+	}
+}
+
+func TestValidateFSEncodingCheck(t *testing.T) {
+	fsys := fstest.MapFS{
+		"1_init.sql": {Data: []byte("\xEF\xBB\xBFCREATE TABLE a (id int);")},
+		"2_next.sql": {Data: []byte("CREATE TABLE b (id int);")},
+	}
+
+	issues, err := ValidateFS(fsys, WithEncodingCheck())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(issues) != 1 || issues[0].Path != "1_init.sql" {
+		t.Errorf("expected one issue, for 1_init.sql, got %v", issues)
+	}
+}
@@ -0,0 +1,186 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrorClass is a database-independent classification of an error returned by a driver, so that
+// failures can be handled consistently across databases.
+type ErrorClass int
+
+// The classes of errors. ErrorClassUnknown is used if the driver doesn't implement
+// ErrorClassifier, or the error doesn't fit any other class.
+const (
+	ErrorClassUnknown ErrorClass = iota
+	ErrorClassAlreadyExists
+	ErrorClassNotFound
+	ErrorClassPermissionDenied
+	ErrorClassSyntax
+	ErrorClassLockTimeout
+	ErrorClassTransient
+	// ErrorClassSerialization is a serialization failure or deadlock, where the transaction was
+	// aborted because of concurrent transactions. Like ErrorClassTransient, retrying the whole
+	// transaction may well succeed. See WithSerializationRetry.
+	ErrorClassSerialization
+)
+
+// String returns the name of the class.
+func (c ErrorClass) String() string {
+	switch c {
+	case ErrorClassAlreadyExists:
+		return "AlreadyExists"
+	case ErrorClassNotFound:
+		return "NotFound"
+	case ErrorClassPermissionDenied:
+		return "PermissionDenied"
+	case ErrorClassSyntax:
+		return "Syntax"
+	case ErrorClassLockTimeout:
+		return "LockTimeout"
+	case ErrorClassTransient:
+		return "Transient"
+	case ErrorClassSerialization:
+		return "Serialization"
+	}
+
+	return "Unknown"
+}
+
+// MigrationError is returned by Execute when a migration's commands fail.
+type MigrationError struct {
+	Version int
+	// Command is the index of the command that failed. It's the number of commands if executing
+	// the migration's Source failed.
+	Command int
+	// Class is the class of Err, if the driver implements ErrorClassifier.
+	Class ErrorClass
+	// PartiallyApplied is true if the migration's commands before Command (or its Source or Func,
+	// if one of those failed) may have taken effect, and weren't rolled back, as the driver doesn't
+	// support transactions (see TransactionalDriver).
+	PartiallyApplied bool
+	Err              error
+}
+
+// Error returns the error message, including the failed version and command.
+func (e *MigrationError) Error() string {
+	if e.PartiallyApplied {
+		return fmt.Sprintf("failed to execute migration (version %d, command %d, partially applied, not rolled back): %v", e.Version, e.Command, e.Err)
+	}
+
+	return fmt.Sprintf("failed to execute migration (version %d, command %d): %v", e.Version, e.Command, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *MigrationError) Unwrap() error {
+	return e.Err
+}
+
+// PanicError is returned by Execute when a panic occurred while it was running, e.g. in a Func
+// migration, or the driver. The transaction is rolled back, as for any other error.
+type PanicError struct {
+	// Version is the version being applied when the panic occurred, or -1 if it occurred while no
+	// version was being applied.
+	Version int
+	// Value is the value passed to panic.
+	Value interface{}
+	// Stack is the stack trace of the goroutine at the time of the panic.
+	Stack []byte
+}
+
+// Error returns the error message, including the version being applied.
+func (e *PanicError) Error() string {
+	if e.Version < 0 {
+		return fmt.Sprintf("migrate: panic: %v", e.Value)
+	}
+
+	return fmt.Sprintf("migrate: panic while applying version %d: %v", e.Version, e.Value)
+}
+
+// Unwrap returns the value passed to panic, if it's an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// ExecuteErrors is returned by Execute when using WithContinueOnError, and any versions failed,
+// containing an error for each, followed by the error that stopped the run, if any.
+type ExecuteErrors []error
+
+// Error returns all of the errors, one per line.
+func (e ExecuteErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+
+	return fmt.Sprintf("migrate: %d errors:\n%s", len(e), strings.Join(msgs, "\n"))
+}
+
+// Is returns true if any of the errors is target.
+func (e ExecuteErrors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// As finds the first of the errors that matches target, e.g. a *MigrationError.
+func (e ExecuteErrors) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// with returns the errors along with err, which may be nil, or just err if there are no errors.
+func (e ExecuteErrors) with(err error) error {
+	if len(e) == 0 {
+		return err
+	}
+
+	if err != nil {
+		e = append(e, err)
+	}
+
+	return e
+}
+
+// newMigrationError returns a new MigrationError, classifying err if the driver supports it.
+func newMigrationError(driver Driver, version, command int, err error) *MigrationError {
+	class := ErrorClassUnknown
+	if classifier, ok := driver.(ErrorClassifier); ok {
+		class = classifier.ClassifyError(err)
+	}
+
+	return &MigrationError{
+		Version: version,
+		Command: command,
+		Class:   class,
+		Err:     err,
+	}
+}
+
+// ClassOf returns the class of the given error, if it is or wraps a MigrationError, or
+// ErrorClassUnknown otherwise.
+func ClassOf(err error) ErrorClass {
+	var merr *MigrationError
+	if errors.As(err, &merr) {
+		return merr.Class
+	}
+
+	return ErrorClassUnknown
+}
+
+// isSerializationFailure returns true if the driver classifies err as a serialization failure.
+func isSerializationFailure(driver Driver, err error) bool {
+	classifier, ok := driver.(ErrorClassifier)
+	return ok && classifier.ClassifyError(err) == ErrorClassSerialization
+}
@@ -0,0 +1,104 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestExecutePanicInFunc(t *testing.T) {
+	panicking := NewFuncMigration(2, func(ctx context.Context, tx Executor) error {
+		panic("boom")
+	})
+
+	tests := []struct {
+		name string
+		opts []Option
+		want []int
+	}{
+		{name: "single transaction", want: nil},
+		{name: "savepoints", opts: []Option{WithSavepoints()}, want: []int{1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := NewRegistry()
+			r.Register("default", NewMigration(1, "CREATE TABLE a"))
+			r.Register("default", panicking)
+
+			driver := newFakeDriver()
+			handler, events := ChannelEventHandler()
+
+			err := r.ExecuteContext(context.Background(), driver, handler, "default", 0, test.opts...)
+
+			var perr *PanicError
+			if !errors.As(err, &perr) {
+				t.Fatalf("expected a *PanicError, got %v", err)
+			}
+
+			if perr.Version != 2 || perr.Value != "boom" {
+				t.Errorf("unexpected panic error: version %d, value %v", perr.Version, perr.Value)
+			}
+
+			kinds := eventKinds(events)
+			if !hasEvent(kinds, EventExecuteError) {
+				t.Errorf("expected an ExecuteError event, got %v", kinds)
+			}
+
+			if hasEvent(kinds, EventAfterExecute) {
+				t.Errorf("unexpected AfterExecute event, got %v", kinds)
+			}
+
+			if got := driver.db.committedVersions(); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("expected committed versions %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+// nonTransactionalDriver is a fakeDriver without transactions: everything executed or inserted
+// takes effect immediately, so rolling back keeps it, as committing would.
+type nonTransactionalDriver struct {
+	*fakeDriver
+}
+
+// Transactional ...
+func (d nonTransactionalDriver) Transactional() bool {
+	return false
+}
+
+// Rollback ...
+func (d nonTransactionalDriver) Rollback(ctx context.Context) error {
+	return d.fakeDriver.Commit(ctx)
+}
+
+func TestExecuteNonTransactionalDriver(t *testing.T) {
+	r := NewRegistry()
+	r.Register("default", Migration{Version: 1, Commands: []string{"CREATE TABLE a"}, ReleaseID: "r1"})
+	r.Register("default", Migration{Version: 2, Commands: []string{"CREATE TABLE b", "CREATE TABLE c"}, ReleaseID: "r1"})
+
+	driver := newFakeDriver()
+	driver.failOn = "CREATE TABLE c"
+
+	result, err := r.ExecuteResult(context.Background(), nonTransactionalDriver{driver}, NoopEventHandler{}, "default", 0)
+
+	var merr *MigrationError
+	if !errors.As(err, &merr) {
+		t.Fatalf("expected a *MigrationError, got %v", err)
+	}
+
+	if merr.Version != 2 || merr.Command != 1 || !merr.PartiallyApplied {
+		t.Errorf("expected version 2 command 1 to be partially applied, got %+v", merr)
+	}
+
+	// Version 1 is recorded on its own, even though it shares a release with version 2, as it
+	// can't be rolled back with it.
+	if !reflect.DeepEqual(result.Applied, []int{1}) {
+		t.Errorf("expected version 1 to be applied, got %v", result.Applied)
+	}
+
+	if got := driver.db.committedVersions(); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("expected committed versions [1], got %v", got)
+	}
+}
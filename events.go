@@ -12,6 +12,28 @@ type EventHandler interface {
 	OnVersionTableCreated()
 	OnExecuteError(err error)
 	OnRollbackError(err error)
+	OnMigrationPartialFailure(version, succeededCommands, failedCommand int, err error)
+	BeforeVersionCleanup(version int)
+	AfterVersionCleanup(version int)
+	OnCleanupError(version int, err error)
+	OnCommandResult(version, index int, rowsAffected int64)
+	OnVersionExcluded(version int)
+	OnResume(fromVersion int)
+	OnPostCommitError(version int, err error)
+	OnVersionBaselined(version int)
+	BeforeExecute(info DriverInfo)
+	AfterExecute(info DriverInfo)
+	OnSerializationRetry(attempt int, err error)
+	OnRollbackPartialFailure(version, succeededCommands, failedCommand int, err error)
+	BeforeVersionRevert(version int)
+	AfterVersionRevert(version int)
+	OnVersionsOutOfOrder(versions []int)
+	OnChecksumMismatch(version int, err error)
+	BeforeRepeatableMigrate(name string)
+	AfterRepeatableMigrate(name string)
+	OnVersionMarkedApplied(version int)
+	OnVersionMarkedUnapplied(version int)
+	OnMigrationResumed(version, commandIndex int)
 }
 
 // NoopEventHandler is a no-op EventHandler implementation.
@@ -43,3 +65,71 @@ func (n NoopEventHandler) OnExecuteError(err error) {}
 
 // OnRollbackError is a no-op OnRollbackError method.
 func (n NoopEventHandler) OnRollbackError(err error) {}
+
+// OnMigrationPartialFailure is a no-op OnMigrationPartialFailure method.
+func (n NoopEventHandler) OnMigrationPartialFailure(version, succeededCommands, failedCommand int, err error) {
+}
+
+// BeforeVersionCleanup is a no-op BeforeVersionCleanup method.
+func (n NoopEventHandler) BeforeVersionCleanup(version int) {}
+
+// AfterVersionCleanup is a no-op AfterVersionCleanup method.
+func (n NoopEventHandler) AfterVersionCleanup(version int) {}
+
+// OnCleanupError is a no-op OnCleanupError method.
+func (n NoopEventHandler) OnCleanupError(version int, err error) {}
+
+// OnCommandResult is a no-op OnCommandResult method.
+func (n NoopEventHandler) OnCommandResult(version, index int, rowsAffected int64) {}
+
+// OnVersionExcluded is a no-op OnVersionExcluded method.
+func (n NoopEventHandler) OnVersionExcluded(version int) {}
+
+// OnResume is a no-op OnResume method.
+func (n NoopEventHandler) OnResume(fromVersion int) {}
+
+// OnPostCommitError is a no-op OnPostCommitError method.
+func (n NoopEventHandler) OnPostCommitError(version int, err error) {}
+
+// OnVersionBaselined is a no-op OnVersionBaselined method.
+func (n NoopEventHandler) OnVersionBaselined(version int) {}
+
+// BeforeExecute is a no-op BeforeExecute method.
+func (n NoopEventHandler) BeforeExecute(info DriverInfo) {}
+
+// AfterExecute is a no-op AfterExecute method.
+func (n NoopEventHandler) AfterExecute(info DriverInfo) {}
+
+// OnSerializationRetry is a no-op OnSerializationRetry method.
+func (n NoopEventHandler) OnSerializationRetry(attempt int, err error) {}
+
+// OnRollbackPartialFailure is a no-op OnRollbackPartialFailure method.
+func (n NoopEventHandler) OnRollbackPartialFailure(version, succeededCommands, failedCommand int, err error) {
+}
+
+// BeforeVersionRevert is a no-op BeforeVersionRevert method.
+func (n NoopEventHandler) BeforeVersionRevert(version int) {}
+
+// AfterVersionRevert is a no-op AfterVersionRevert method.
+func (n NoopEventHandler) AfterVersionRevert(version int) {}
+
+// OnVersionsOutOfOrder is a no-op OnVersionsOutOfOrder method.
+func (n NoopEventHandler) OnVersionsOutOfOrder(versions []int) {}
+
+// OnChecksumMismatch is a no-op OnChecksumMismatch method.
+func (n NoopEventHandler) OnChecksumMismatch(version int, err error) {}
+
+// BeforeRepeatableMigrate is a no-op BeforeRepeatableMigrate method.
+func (n NoopEventHandler) BeforeRepeatableMigrate(name string) {}
+
+// AfterRepeatableMigrate is a no-op AfterRepeatableMigrate method.
+func (n NoopEventHandler) AfterRepeatableMigrate(name string) {}
+
+// OnVersionMarkedApplied is a no-op OnVersionMarkedApplied method.
+func (n NoopEventHandler) OnVersionMarkedApplied(version int) {}
+
+// OnVersionMarkedUnapplied is a no-op OnVersionMarkedUnapplied method.
+func (n NoopEventHandler) OnVersionMarkedUnapplied(version int) {}
+
+// OnMigrationResumed is a no-op OnMigrationResumed method.
+func (n NoopEventHandler) OnMigrationResumed(version, commandIndex int) {}
@@ -0,0 +1,240 @@
+package migrate
+
+// channelEventBuffer is the buffer size of the channel returned by ChannelEventHandler.
+const channelEventBuffer = 64
+
+// EventKind identifies which EventHandler method an Event was sent from.
+type EventKind string
+
+// Event kinds, one per EventHandler method.
+const (
+	EventBeforeVersionsMigrate   EventKind = "BeforeVersionsMigrate"
+	EventBeforeVersionMigrate    EventKind = "BeforeVersionMigrate"
+	EventAfterVersionsMigrate    EventKind = "AfterVersionsMigrate"
+	EventAfterVersionMigrate     EventKind = "AfterVersionMigrate"
+	EventVersionSkipped          EventKind = "VersionSkipped"
+	EventVersionTableNotExists   EventKind = "VersionTableNotExists"
+	EventVersionTableCreated     EventKind = "VersionTableCreated"
+	EventExecuteError            EventKind = "ExecuteError"
+	EventRollbackError           EventKind = "RollbackError"
+	EventMigrationPartialFailure EventKind = "MigrationPartialFailure"
+	EventBeforeVersionCleanup    EventKind = "BeforeVersionCleanup"
+	EventAfterVersionCleanup     EventKind = "AfterVersionCleanup"
+	EventCleanupError            EventKind = "CleanupError"
+	EventCommandResult           EventKind = "CommandResult"
+	EventVersionExcluded         EventKind = "VersionExcluded"
+	EventResume                  EventKind = "Resume"
+	EventPostCommitError         EventKind = "PostCommitError"
+	EventVersionBaselined        EventKind = "VersionBaselined"
+	EventBeforeExecute           EventKind = "BeforeExecute"
+	EventAfterExecute            EventKind = "AfterExecute"
+	EventSerializationRetry      EventKind = "SerializationRetry"
+	EventRollbackPartialFailure  EventKind = "RollbackPartialFailure"
+	EventBeforeVersionRevert     EventKind = "BeforeVersionRevert"
+	EventAfterVersionRevert      EventKind = "AfterVersionRevert"
+	EventVersionsOutOfOrder      EventKind = "VersionsOutOfOrder"
+	EventChecksumMismatch        EventKind = "ChecksumMismatch"
+	EventBeforeRepeatableMigrate EventKind = "BeforeRepeatableMigrate"
+	EventAfterRepeatableMigrate  EventKind = "AfterRepeatableMigrate"
+	EventVersionMarkedApplied    EventKind = "VersionMarkedApplied"
+	EventVersionMarkedUnapplied  EventKind = "VersionMarkedUnapplied"
+	EventMigrationResumed        EventKind = "MigrationResumed"
+)
+
+// Event is a single event sent by the EventHandler returned from ChannelEventHandler. Only the
+// fields relevant to the Kind of event are set; the rest are left as zero values.
+type Event struct {
+	Kind              EventKind
+	Version           int
+	Name              string
+	Versions          []int
+	SucceededCommands int
+	FailedCommand     int
+	CommandIndex      int
+	RowsAffected      int64
+	Info              DriverInfo
+	Attempt           int
+	Err               error
+}
+
+// channelEventHandler is an EventHandler that sends every event to a channel.
+type channelEventHandler struct {
+	events chan Event
+}
+
+// ChannelEventHandler returns an EventHandler that sends each event to the returned channel, as an
+// alternative to implementing EventHandler. The channel is buffered, but once the buffer is full
+// each event blocks the migration until it's received, so the channel must be drained for as long
+// as the handler is in use, e.g. from another goroutine. The channel is never closed, as the same
+// handler may be used for many runs; stop receiving once the run has finished.
+func ChannelEventHandler() (EventHandler, <-chan Event) {
+	events := make(chan Event, channelEventBuffer)
+	return channelEventHandler{events: events}, events
+}
+
+// BeforeVersionsMigrate sends an EventBeforeVersionsMigrate event.
+func (h channelEventHandler) BeforeVersionsMigrate(versions []int) {
+	h.events <- Event{Kind: EventBeforeVersionsMigrate, Versions: versions}
+}
+
+// BeforeVersionMigrate sends an EventBeforeVersionMigrate event.
+func (h channelEventHandler) BeforeVersionMigrate(version int) {
+	h.events <- Event{Kind: EventBeforeVersionMigrate, Version: version}
+}
+
+// AfterVersionsMigrate sends an EventAfterVersionsMigrate event.
+func (h channelEventHandler) AfterVersionsMigrate(versions []int) {
+	h.events <- Event{Kind: EventAfterVersionsMigrate, Versions: versions}
+}
+
+// AfterVersionMigrate sends an EventAfterVersionMigrate event.
+func (h channelEventHandler) AfterVersionMigrate(version int) {
+	h.events <- Event{Kind: EventAfterVersionMigrate, Version: version}
+}
+
+// OnVersionSkipped sends an EventVersionSkipped event.
+func (h channelEventHandler) OnVersionSkipped(version int) {
+	h.events <- Event{Kind: EventVersionSkipped, Version: version}
+}
+
+// OnVersionTableNotExists sends an EventVersionTableNotExists event.
+func (h channelEventHandler) OnVersionTableNotExists() {
+	h.events <- Event{Kind: EventVersionTableNotExists}
+}
+
+// OnVersionTableCreated sends an EventVersionTableCreated event.
+func (h channelEventHandler) OnVersionTableCreated() {
+	h.events <- Event{Kind: EventVersionTableCreated}
+}
+
+// OnExecuteError sends an EventExecuteError event.
+func (h channelEventHandler) OnExecuteError(err error) {
+	h.events <- Event{Kind: EventExecuteError, Err: err}
+}
+
+// OnRollbackError sends an EventRollbackError event.
+func (h channelEventHandler) OnRollbackError(err error) {
+	h.events <- Event{Kind: EventRollbackError, Err: err}
+}
+
+// OnMigrationPartialFailure sends an EventMigrationPartialFailure event.
+func (h channelEventHandler) OnMigrationPartialFailure(version, succeededCommands, failedCommand int, err error) {
+	h.events <- Event{
+		Kind:              EventMigrationPartialFailure,
+		Version:           version,
+		SucceededCommands: succeededCommands,
+		FailedCommand:     failedCommand,
+		Err:               err,
+	}
+}
+
+// BeforeVersionCleanup sends an EventBeforeVersionCleanup event.
+func (h channelEventHandler) BeforeVersionCleanup(version int) {
+	h.events <- Event{Kind: EventBeforeVersionCleanup, Version: version}
+}
+
+// AfterVersionCleanup sends an EventAfterVersionCleanup event.
+func (h channelEventHandler) AfterVersionCleanup(version int) {
+	h.events <- Event{Kind: EventAfterVersionCleanup, Version: version}
+}
+
+// OnCleanupError sends an EventCleanupError event.
+func (h channelEventHandler) OnCleanupError(version int, err error) {
+	h.events <- Event{Kind: EventCleanupError, Version: version, Err: err}
+}
+
+// OnCommandResult sends an EventCommandResult event.
+func (h channelEventHandler) OnCommandResult(version, index int, rowsAffected int64) {
+	h.events <- Event{Kind: EventCommandResult, Version: version, CommandIndex: index, RowsAffected: rowsAffected}
+}
+
+// OnVersionExcluded sends an EventVersionExcluded event.
+func (h channelEventHandler) OnVersionExcluded(version int) {
+	h.events <- Event{Kind: EventVersionExcluded, Version: version}
+}
+
+// OnResume sends an EventResume event.
+func (h channelEventHandler) OnResume(fromVersion int) {
+	h.events <- Event{Kind: EventResume, Version: fromVersion}
+}
+
+// OnPostCommitError sends an EventPostCommitError event.
+func (h channelEventHandler) OnPostCommitError(version int, err error) {
+	h.events <- Event{Kind: EventPostCommitError, Version: version, Err: err}
+}
+
+// OnVersionBaselined sends an EventVersionBaselined event.
+func (h channelEventHandler) OnVersionBaselined(version int) {
+	h.events <- Event{Kind: EventVersionBaselined, Version: version}
+}
+
+// BeforeExecute sends an EventBeforeExecute event.
+func (h channelEventHandler) BeforeExecute(info DriverInfo) {
+	h.events <- Event{Kind: EventBeforeExecute, Info: info}
+}
+
+// AfterExecute sends an EventAfterExecute event.
+func (h channelEventHandler) AfterExecute(info DriverInfo) {
+	h.events <- Event{Kind: EventAfterExecute, Info: info}
+}
+
+// OnSerializationRetry sends an EventSerializationRetry event.
+func (h channelEventHandler) OnSerializationRetry(attempt int, err error) {
+	h.events <- Event{Kind: EventSerializationRetry, Attempt: attempt, Err: err}
+}
+
+// OnRollbackPartialFailure sends an EventRollbackPartialFailure event.
+func (h channelEventHandler) OnRollbackPartialFailure(version, succeededCommands, failedCommand int, err error) {
+	h.events <- Event{
+		Kind:              EventRollbackPartialFailure,
+		Version:           version,
+		SucceededCommands: succeededCommands,
+		FailedCommand:     failedCommand,
+		Err:               err,
+	}
+}
+
+// BeforeVersionRevert sends an EventBeforeVersionRevert event.
+func (h channelEventHandler) BeforeVersionRevert(version int) {
+	h.events <- Event{Kind: EventBeforeVersionRevert, Version: version}
+}
+
+// AfterVersionRevert sends an EventAfterVersionRevert event.
+func (h channelEventHandler) AfterVersionRevert(version int) {
+	h.events <- Event{Kind: EventAfterVersionRevert, Version: version}
+}
+
+// OnVersionsOutOfOrder sends an EventVersionsOutOfOrder event.
+func (h channelEventHandler) OnVersionsOutOfOrder(versions []int) {
+	h.events <- Event{Kind: EventVersionsOutOfOrder, Versions: versions}
+}
+
+// OnChecksumMismatch sends an EventChecksumMismatch event.
+func (h channelEventHandler) OnChecksumMismatch(version int, err error) {
+	h.events <- Event{Kind: EventChecksumMismatch, Version: version, Err: err}
+}
+
+// BeforeRepeatableMigrate sends an EventBeforeRepeatableMigrate event.
+func (h channelEventHandler) BeforeRepeatableMigrate(name string) {
+	h.events <- Event{Kind: EventBeforeRepeatableMigrate, Name: name}
+}
+
+// AfterRepeatableMigrate sends an EventAfterRepeatableMigrate event.
+func (h channelEventHandler) AfterRepeatableMigrate(name string) {
+	h.events <- Event{Kind: EventAfterRepeatableMigrate, Name: name}
+}
+
+// OnVersionMarkedApplied sends an EventVersionMarkedApplied event.
+func (h channelEventHandler) OnVersionMarkedApplied(version int) {
+	h.events <- Event{Kind: EventVersionMarkedApplied, Version: version}
+}
+
+// OnVersionMarkedUnapplied sends an EventVersionMarkedUnapplied event.
+func (h channelEventHandler) OnVersionMarkedUnapplied(version int) {
+	h.events <- Event{Kind: EventVersionMarkedUnapplied, Version: version}
+}
+
+// OnMigrationResumed sends an EventMigrationResumed event.
+func (h channelEventHandler) OnMigrationResumed(version, commandIndex int) {
+	h.events <- Event{Kind: EventMigrationResumed, Version: version, CommandIndex: commandIndex}
+}
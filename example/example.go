@@ -19,7 +19,10 @@ func main() {
 		log.Fatalf("failed to open DB connection: %v", err)
 	}
 
-	driver := migrate.NewPostgresDriver(conn, "example", "migration_versions")
+	driver, err := migrate.NewPostgresDriver(conn, "example", "migration_versions")
+	if err != nil {
+		log.Fatalf("failed to create migration driver: %v", err)
+	}
 
 	err = migrate.Execute(driver, NewEventHandler(), "example", time.Minute)
 	if err != nil {
@@ -101,3 +104,113 @@ func (e EventHandler) OnExecuteError(err error) {
 func (e EventHandler) OnRollbackError(err error) {
 	log.Printf("Failed to rollback migration transaction: %v", err)
 }
+
+// OnMigrationPartialFailure ...
+func (e EventHandler) OnMigrationPartialFailure(version, succeededCommands, failedCommand int, err error) {
+	log.Printf("Version %d failed on command %d (%d commands succeeded): %v", version, failedCommand, succeededCommands, err)
+}
+
+// BeforeVersionCleanup ...
+func (e EventHandler) BeforeVersionCleanup(version int) {
+	log.Printf("Cleaning up failed version: %d...", version)
+}
+
+// AfterVersionCleanup ...
+func (e EventHandler) AfterVersionCleanup(version int) {
+	log.Printf("Cleaned up failed version: %d", version)
+}
+
+// OnCleanupError ...
+func (e EventHandler) OnCleanupError(version int, err error) {
+	log.Printf("Failed to clean up version %d: %v", version, err)
+}
+
+// OnCommandResult ...
+func (e EventHandler) OnCommandResult(version, index int, rowsAffected int64) {
+	log.Printf("Version %d command %d affected %d rows", version, index, rowsAffected)
+}
+
+// OnVersionExcluded ...
+func (e EventHandler) OnVersionExcluded(version int) {
+	log.Printf("Excluding version: %d", version)
+}
+
+// OnResume ...
+func (e EventHandler) OnResume(fromVersion int) {
+	log.Printf("Resuming interrupted run from version: %d", fromVersion)
+}
+
+// OnPostCommitError ...
+func (e EventHandler) OnPostCommitError(version int, err error) {
+	log.Printf("Post-commit callback for version %d failed: %v", version, err)
+}
+
+// OnVersionBaselined ...
+func (e EventHandler) OnVersionBaselined(version int) {
+	log.Printf("Baselined version: %d", version)
+}
+
+// BeforeExecute ...
+func (e EventHandler) BeforeExecute(info migrate.DriverInfo) {
+	log.Printf("Migrating database: %+v", info)
+}
+
+// AfterExecute ...
+func (e EventHandler) AfterExecute(info migrate.DriverInfo) {
+	log.Printf("Migrated database: %+v", info)
+}
+
+// OnSerializationRetry ...
+func (e EventHandler) OnSerializationRetry(attempt int, err error) {
+	log.Printf("Retrying after serialization failure (attempt %d): %v", attempt, err)
+}
+
+// OnRollbackPartialFailure ...
+func (e EventHandler) OnRollbackPartialFailure(version, succeededCommands, failedCommand int, err error) {
+	log.Printf("Reverting version %d failed on command %d (%d commands succeeded): %v", version, failedCommand, succeededCommands, err)
+}
+
+// BeforeVersionRevert ...
+func (e EventHandler) BeforeVersionRevert(version int) {
+	log.Printf("Reverting version: %d...", version)
+}
+
+// AfterVersionRevert ...
+func (e EventHandler) AfterVersionRevert(version int) {
+	log.Printf("Reverted version: %d", version)
+}
+
+// OnVersionsOutOfOrder ...
+func (e EventHandler) OnVersionsOutOfOrder(versions []int) {
+	log.Printf("Applying versions %v, lower than the highest applied version", versions)
+}
+
+// OnChecksumMismatch ...
+func (e EventHandler) OnChecksumMismatch(version int, err error) {
+	log.Printf("Applied version %d has changed since it was applied: %v", version, err)
+}
+
+// BeforeRepeatableMigrate ...
+func (e EventHandler) BeforeRepeatableMigrate(name string) {
+	log.Printf("Applying repeatable migration %q...", name)
+}
+
+// AfterRepeatableMigrate ...
+func (e EventHandler) AfterRepeatableMigrate(name string) {
+	log.Printf("Applied repeatable migration %q", name)
+}
+
+// OnVersionMarkedApplied ...
+func (e EventHandler) OnVersionMarkedApplied(version int) {
+	log.Printf("Marked version %d as applied, without executing it", version)
+}
+
+// OnVersionMarkedUnapplied ...
+func (e EventHandler) OnVersionMarkedUnapplied(version int) {
+	log.Printf("Marked version %d as not applied, without reverting it", version)
+}
+
+// OnMigrationResumed ...
+func (e EventHandler) OnMigrationResumed(version, commandIndex int) {
+	log.Printf("Resuming version %d from command %d, as the commands before it already completed", version, commandIndex)
+}
@@ -0,0 +1,74 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrExplainNotSupported is returned by ExplainPlan if the driver doesn't implement Explainer.
+var ErrExplainNotSupported = errors.New("migrate: driver does not support explaining commands")
+
+// dmlKeywords are the keywords that DML statements start with.
+var dmlKeywords = map[string]bool{
+	"DELETE": true,
+	"INSERT": true,
+	"MERGE":  true,
+	"SELECT": true,
+	"UPDATE": true,
+	"WITH":   true,
+}
+
+// ExplainPlan returns the query plans of the DML statements (e.g. UPDATE and DELETE) in the
+// pending migrations of the given namespace, by version, to preview the cost of large backfills
+// before running them. Each command is split into statements, and the plans of each version's DML
+// statements are returned one after another, in order. DDL statements are skipped. Nothing is
+// executed, so statements that depend on objects created by earlier pending migrations can't be
+// explained, and return an error. The driver must implement Explainer.
+func ExplainPlan(driver Driver, namespace string, ctx context.Context) (map[int][]string, error) {
+	explainer, ok := driver.(Explainer)
+	if !ok {
+		return nil, ErrExplainNotSupported
+	}
+
+	plan, err := Plan(driver, namespace, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	plans := make(map[int][]string)
+	for _, migration := range plan {
+		for i, command := range migration.Commands {
+			scanner := newStatementScanner(strings.NewReader(command), Info(driver).Dialect)
+			for scanner.Scan() {
+				if !isDML(scanner.Statement()) {
+					continue
+				}
+
+				lines, err := explainer.Explain(ctx, scanner.Statement())
+				if err != nil {
+					return nil, fmt.Errorf("version %d: failed to explain command %d: %w", migration.Version, i, err)
+				}
+
+				plans[migration.Version] = append(plans[migration.Version], lines...)
+			}
+
+			if err := scanner.Err(); err != nil {
+				return nil, fmt.Errorf("version %d: failed to split command %d: %w", migration.Version, i, err)
+			}
+		}
+	}
+
+	return plans, nil
+}
+
+// isDML returns true if the given statement is a DML statement, ignoring any leading comments.
+func isDML(statement string) bool {
+	fields := strings.Fields(NormalizeWhitespace(statement))
+	if len(fields) == 0 {
+		return false
+	}
+
+	return dmlKeywords[strings.ToUpper(strings.TrimLeft(fields[0], "("))]
+}
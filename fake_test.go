@@ -0,0 +1,213 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+)
+
+// errFakeExec is returned by fakeDriver.Exec for the command it's configured to fail on.
+var errFakeExec = errors.New("fake: command failed")
+
+// fakeDB is the state shared by every fakeDriver connected to it, as a database would be.
+type fakeDB struct {
+	// lock is the versions table lock, held from Lock until the transaction ends.
+	lock sync.Mutex
+
+	mu       sync.Mutex
+	created  bool
+	versions []int
+	execs    []string
+}
+
+// committedVersions returns the committed versions, in order.
+func (db *fakeDB) committedVersions() []int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	versions := append([]int(nil), db.versions...)
+	sort.Ints(versions)
+
+	return versions
+}
+
+// fakeDriver is an in-memory Driver, for testing. Versions inserted and commands executed in a
+// transaction are only added to its fakeDB when it's committed.
+type fakeDriver struct {
+	db *fakeDB
+
+	// failOn is a command that Exec fails on, if not empty.
+	failOn string
+
+	inTx     bool
+	locked   bool
+	inserted []int
+	execs    []string
+
+	// savepoint holds how many versions and commands had been added when the savepoint was set.
+	savepoint [2]int
+}
+
+// newFakeDriver returns a new fakeDriver, connected to a new, empty fakeDB.
+func newFakeDriver() *fakeDriver {
+	return &fakeDriver{db: &fakeDB{}}
+}
+
+// Begin ...
+func (d *fakeDriver) Begin(ctx context.Context) error {
+	if d.inTx {
+		return ErrTransactionAlreadyStarted
+	}
+
+	d.inTx = true
+
+	return nil
+}
+
+// Commit ...
+func (d *fakeDriver) Commit(ctx context.Context) error {
+	if !d.inTx {
+		return ErrTransactionNotStarted
+	}
+
+	d.db.mu.Lock()
+	d.db.versions = append(d.db.versions, d.inserted...)
+	d.db.execs = append(d.db.execs, d.execs...)
+	d.db.mu.Unlock()
+
+	d.end()
+
+	return nil
+}
+
+// Rollback ...
+func (d *fakeDriver) Rollback(ctx context.Context) error {
+	if !d.inTx {
+		return ErrTransactionNotStarted
+	}
+
+	d.end()
+
+	return nil
+}
+
+// end ends the transaction, releasing the lock if it's held.
+func (d *fakeDriver) end() {
+	if d.locked {
+		d.db.lock.Unlock()
+	}
+
+	d.inTx, d.locked = false, false
+	d.inserted, d.execs = nil, nil
+}
+
+// Lock ...
+func (d *fakeDriver) Lock(ctx context.Context) error {
+	if !d.inTx {
+		return ErrTransactionNotStarted
+	}
+
+	d.db.lock.Lock()
+	d.locked = true
+
+	return nil
+}
+
+// Exec ...
+func (d *fakeDriver) Exec(ctx context.Context, command string) error {
+	if !d.inTx {
+		return ErrTransactionNotStarted
+	}
+
+	if command == d.failOn {
+		return errFakeExec
+	}
+
+	d.execs = append(d.execs, command)
+
+	return nil
+}
+
+// CreateVersionsTable ...
+func (d *fakeDriver) CreateVersionsTable(ctx context.Context) error {
+	d.db.mu.Lock()
+	defer d.db.mu.Unlock()
+
+	d.db.created = true
+
+	return nil
+}
+
+// InsertVersion ...
+func (d *fakeDriver) InsertVersion(ctx context.Context, version int) error {
+	if !d.inTx {
+		return ErrTransactionNotStarted
+	}
+
+	d.inserted = append(d.inserted, version)
+
+	return nil
+}
+
+// Versions ...
+func (d *fakeDriver) Versions(ctx context.Context) ([]int, error) {
+	if !d.inTx {
+		return nil, ErrTransactionNotStarted
+	}
+
+	d.db.mu.Lock()
+	defer d.db.mu.Unlock()
+
+	return append(append([]int(nil), d.db.versions...), d.inserted...), nil
+}
+
+// VersionTableExists ...
+func (d *fakeDriver) VersionTableExists(ctx context.Context) (bool, error) {
+	d.db.mu.Lock()
+	defer d.db.mu.Unlock()
+
+	return d.db.created, nil
+}
+
+// Savepoint ...
+func (d *fakeDriver) Savepoint(ctx context.Context, name string) error {
+	d.savepoint = [2]int{len(d.inserted), len(d.execs)}
+	return nil
+}
+
+// RollbackToSavepoint ...
+func (d *fakeDriver) RollbackToSavepoint(ctx context.Context, name string) error {
+	d.inserted, d.execs = d.inserted[:d.savepoint[0]], d.execs[:d.savepoint[1]]
+	return nil
+}
+
+// ReleaseSavepoint ...
+func (d *fakeDriver) ReleaseSavepoint(ctx context.Context, name string) error {
+	return nil
+}
+
+// eventKinds returns the kinds of the events received on the given channel so far.
+func eventKinds(events <-chan Event) []EventKind {
+	var kinds []EventKind
+
+	for {
+		select {
+		case event := <-events:
+			kinds = append(kinds, event.Kind)
+		default:
+			return kinds
+		}
+	}
+}
+
+// hasEvent returns true if kinds contains kind.
+func hasEvent(kinds []EventKind, kind EventKind) bool {
+	for _, k := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+
+	return false
+}
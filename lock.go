@@ -0,0 +1,90 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrLockScopeNotSupported is returned when a lock scope is set, but the driver doesn't implement
+// ScopedLocker.
+var ErrLockScopeNotSupported = errors.New("migrate: driver does not support lock scopes")
+
+const (
+	// lockRetryInitialBackoff is the delay before the first retry of a contended lock.
+	lockRetryInitialBackoff = 50 * time.Millisecond
+	// lockRetryMaxBackoff is the maximum delay between retries of a contended lock.
+	lockRetryMaxBackoff = 2 * time.Second
+)
+
+// lock locks the versions table. If the driver implements LockContentionClassifier and the lock
+// is held by another process, acquiring it is retried with backoff until the configured lock wait
+// has elapsed, or the context is done. Any other error is returned immediately. The transaction
+// must already have begun, and is restarted between attempts, as a failed statement may abort it.
+func (o *options) lock(ctx context.Context, driver Driver) error {
+	err := o.tryLock(ctx, driver)
+	if err == nil {
+		return nil
+	}
+
+	classifier, ok := driver.(LockContentionClassifier)
+	if !ok {
+		return err
+	}
+
+	var deadline <-chan time.Time
+	if o.lockWait > 0 {
+		timer := time.NewTimer(o.lockWait)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	backoff := lockRetryInitialBackoff
+
+	for classifier.IsLockContention(err) {
+		rerr := driver.Rollback(ctx)
+		if rerr != nil {
+			return fmt.Errorf("failed to rollback transaction to retry lock: %w", rerr)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%v: %w", err, ctx.Err())
+		case <-deadline:
+			return fmt.Errorf("gave up retrying lock after %s: %w", o.lockWait, err)
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > lockRetryMaxBackoff {
+			backoff = lockRetryMaxBackoff
+		}
+
+		err = driver.Begin(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+
+		err = o.tryLock(ctx, driver)
+		if err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// tryLock makes a single attempt to lock the versions table, in the configured lock scope if set.
+func (o *options) tryLock(ctx context.Context, driver Driver) error {
+	if o.lockScope == "" {
+		return driver.Lock(ctx)
+	}
+
+	locker, ok := driver.(ScopedLocker)
+	if !ok {
+		return ErrLockScopeNotSupported
+	}
+
+	return locker.LockScope(ctx, o.lockScope)
+}
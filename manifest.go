@@ -0,0 +1,84 @@
+package migrate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Manifest is a serializable description of the migrations registered in a namespace.
+type Manifest struct {
+	Namespace  string              `json:"namespace"`
+	Migrations []ManifestMigration `json:"migrations"`
+}
+
+// ManifestMigration describes a single registered migration within a Manifest.
+type ManifestMigration struct {
+	Version  int      `json:"version"`
+	Checksum string   `json:"checksum"`
+	Commands []string `json:"commands,omitempty"`
+}
+
+// ManifestOption configures optional behaviour of DumpManifest.
+type ManifestOption func(*manifestOptions)
+
+// manifestOptions holds the configuration built up from ManifestOption values.
+type manifestOptions struct {
+	commands bool
+}
+
+// WithManifestCommands includes the full commands of each migration in the manifest, rather than
+// just their checksums.
+func WithManifestCommands() ManifestOption {
+	return func(o *manifestOptions) {
+		o.commands = true
+	}
+}
+
+// DumpManifest writes the migrations currently registered in the given namespace to w as JSON,
+// ordered by version. By default only a SHA-256 checksum of each migration's commands is included,
+// keeping the manifest compact while still making any change to a migration visible in a diff.
+func DumpManifest(namespace string, w io.Writer, opts ...ManifestOption) error {
+	return defaultRegistry.DumpManifest(namespace, w, opts...)
+}
+
+// DumpManifest is like the package-level DumpManifest, but uses this Registry.
+func (r *Registry) DumpManifest(namespace string, w io.Writer, opts ...ManifestOption) error {
+	var o manifestOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	manifest := Manifest{
+		Namespace:  namespace,
+		Migrations: []ManifestMigration{},
+	}
+
+	for _, migration := range r.registered(namespace) {
+		mm := ManifestMigration{
+			Version:  migration.Version,
+			Checksum: ChecksumSHA256(migration.Commands),
+		}
+
+		if o.commands {
+			mm.Commands = migration.Commands
+		}
+
+		manifest.Migrations = append(manifest.Migrations, mm)
+	}
+
+	sort.Slice(manifest.Migrations, func(i, j int) bool {
+		return manifest.Migrations[i].Version < manifest.Migrations[j].Version
+	})
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	err := enc.Encode(manifest)
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+
+	return nil
+}
@@ -4,11 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"io/ioutil"
+	"os/signal"
 	"path/filepath"
+	"runtime/debug"
 	"sort"
-	"strconv"
 	"strings"
 	"time"
 )
@@ -18,15 +20,134 @@ var (
 	ErrTransactionAlreadyStarted = errors.New("migrate: transaction already started")
 	// ErrTransactionNotStarted ...
 	ErrTransactionNotStarted = errors.New("migrate: transaction not started")
+	// ErrNoTransactionNotSupported is returned when a migration must run outside of a transaction,
+	// but the driver doesn't implement NoTransactionDriver.
+	ErrNoTransactionNotSupported = errors.New("migrate: driver does not support executing outside of a transaction")
+	// ErrSavepointNotSupported is returned when using WithSavepoints, but the driver doesn't
+	// implement SavepointDriver.
+	ErrSavepointNotSupported = errors.New("migrate: driver does not support savepoints")
+	// ErrDescriptionNotSupported is returned when using WithDescriptions, but the driver doesn't
+	// implement DescriptionDriver.
+	ErrDescriptionNotSupported = errors.New("migrate: driver does not support descriptions")
+	// ErrToolVersionNotSupported is returned when a tool version is set, but the driver doesn't
+	// implement ToolVersionDriver.
+	ErrToolVersionNotSupported = errors.New("migrate: driver does not support tool versions")
+	// ErrNotifyNotSupported is returned when a post-commit notification channel is set, but the
+	// driver doesn't implement Notifier.
+	ErrNotifyNotSupported = errors.New("migrate: driver does not support notifications")
+	// ErrTableNamingNotSupported is returned when a table name function is set, but the driver
+	// doesn't implement TableNamer.
+	ErrTableNamingNotSupported = errors.New("migrate: driver does not support runtime table names")
+	// ErrSharedVersionsTableNotSupported is returned when a shared versions table is used, but the
+	// driver doesn't implement NamespaceScoper, or the feature being used can't be shared.
+	ErrSharedVersionsTableNotSupported = errors.New("migrate: driver does not support a shared versions table")
+	// ErrRequiredVersionNotCommitted is returned when a migration's RequiresVersion hasn't been
+	// committed by the time the migration would run.
+	ErrRequiredVersionNotCommitted = errors.New("migrate: required version not committed")
+	// ErrReaderExecNotSupported is returned when a migration has a Source, but the driver doesn't
+	// implement ReaderExecer.
+	ErrReaderExecNotSupported = errors.New("migrate: driver does not support executing from a reader")
+	// ErrInvalidMigration is returned when a migration fails validation.
+	ErrInvalidMigration = errors.New("migrate: invalid migration")
+	// ErrDuplicateVersion is returned when registering a migration with the same version as a
+	// different migration already registered in the same namespace.
+	ErrDuplicateVersion = errors.New("migrate: version already registered")
+	// ErrCommitNotConfirmed is returned when the commit confirmation function denies the commit,
+	// after the transaction has been rolled back.
+	ErrCommitNotConfirmed = errors.New("migrate: commit not confirmed")
+	// ErrConfirmationNeedsOneTransaction is returned when commit confirmation is enabled, but the
+	// pending migrations would be applied in more than one transaction.
+	ErrConfirmationNeedsOneTransaction = errors.New("migrate: commit confirmation requires a single transaction")
+	// ErrTooManyPending is returned when more versions are pending than the configured maximum,
+	// before anything is applied.
+	ErrTooManyPending = errors.New("migrate: too many pending versions")
+	// ErrEmptySchemaCheckNotSupported is returned when the schema must be empty on the first run,
+	// but the driver doesn't implement EmptySchemaChecker.
+	ErrEmptySchemaCheckNotSupported = errors.New("migrate: driver does not support checking if the schema is empty")
+	// ErrSchemaNotEmpty is returned when the schema must be empty on the first run, but already
+	// contains tables.
+	ErrSchemaNotEmpty = errors.New("migrate: schema is not empty")
+	// ErrOutOfOrder is returned when using WithStrictOrdering, and a pending version is lower than
+	// the highest applied version.
+	ErrOutOfOrder = errors.New("migrate: pending version lower than highest applied version")
+	// ErrVersionGap is returned by StatusReport.Gaps when applied versions aren't registered, or
+	// pending versions are lower than the highest applied version.
+	ErrVersionGap = errors.New("migrate: gaps between registered and applied versions")
+	// ErrUnknownVersions is returned when using WithRefuseUnknownVersions, and versions that aren't
+	// registered have been applied, i.e. the database is ahead of the code.
+	ErrUnknownVersions = errors.New("migrate: applied versions are not registered")
+	// ErrConnectionClosed is returned when the database connection was closed while in use, e.g.
+	// because the application is shutting down, rather than because a migration failed.
+	ErrConnectionClosed = errors.New("migrate: connection closed")
 )
 
-// namespacedMigrations contains all registered migrations, by namespace.
-var namespacedMigrations = make(NamespacedMigrations)
-
 // Migration ...
 type Migration struct {
-	Version  int
+	// Version identifies the migration within its namespace, and is the key migrations are ordered
+	// by, so it must be unique, unless the namespace uses sequences (see UseSequences).
+	Version int
+	// Sequence orders migrations with the same Version, e.g. timestamps of migrations created
+	// within the same second, in a namespace that uses sequences. It must be less than
+	// SequenceLimit. Once registered, such a migration's Version is the version it's recorded
+	// and reported as, Version*SequenceLimit+Sequence, and its Sequence is zero.
+	Sequence int
 	Commands []string
+
+	// Name is an optional human-readable name (e.g. "add_users_table"), stored in the versions
+	// table's description column when using WithDescriptions, to make the history easier to read.
+	Name string
+
+	// RequiresVersion is a version that must already be committed before this migration can run,
+	// or zero if there is no such requirement. Versions applied in the same transaction as this
+	// one don't count, so this is mostly useful along with per-migration transactions, or
+	// NoTransaction, to guard against partially applied runs being resumed out of order.
+	RequiresVersion int
+
+	// NoTransaction executes Commands outside of the transaction, for statements that can't run
+	// inside of one (e.g. CREATE INDEX CONCURRENTLY). The version is still recorded in a
+	// transaction, which holds the versions table lock while the commands run. The driver must
+	// implement NoTransactionDriver.
+	NoTransaction bool
+	// Cleanup contains commands to execute if Commands fail when using NoTransaction, as there is
+	// no transaction to roll back. For example, to drop an index left behind in an invalid state.
+	Cleanup []string
+
+	// Timeout bounds how long applying this migration may take, overriding the default set by
+	// WithVersionTimeout, e.g. to give a slow backfill longer than the rest. Zero means the default.
+	Timeout time.Duration
+
+	// Down contains the commands that revert this migration's Commands, if it can be reverted.
+	Down []string
+
+	// Source opens a stream of SQL statements to execute after Commands, for migrations too large
+	// to comfortably hold in memory (e.g. seed data). It's opened when the migration is applied,
+	// and statements are read and executed one at a time. Source isn't included in checksums. The
+	// driver must implement ReaderExecer.
+	Source func() (io.ReadCloser, error)
+
+	// Func is called after Commands and Source, for changes that are easier to make in Go than in
+	// SQL (e.g. data transformations using application types). It's called in the same transaction,
+	// and commands given to tx are executed in it, so the version is only recorded if it succeeds.
+	// Func isn't included in checksums, and can't be used with NoTransaction.
+	Func func(ctx context.Context, tx Executor) error
+
+	// ReleaseID groups migrations into a release. When using a transaction per migration,
+	// consecutive versions sharing a non-empty ReleaseID are applied in the same transaction, so
+	// the whole release is either committed or rolled back together.
+	ReleaseID string
+
+	// NoOp marks a migration that deliberately does nothing, e.g. a placeholder for a version whose
+	// migration was removed. A migration without Commands, Source, or Func is rejected unless it's
+	// marked NoOp, so that an incomplete migration isn't mistaken for one that was applied. No-op
+	// migrations are skipped when applying.
+	NoOp bool
+
+	// PostCommit is called after the transaction this migration was applied in has been committed,
+	// for side effects that must only happen if the migration's changes are committed (e.g.
+	// enqueueing a job). Callbacks are called in version order. As the migrations can't be
+	// uncommitted, an error is reported through the OnPostCommitError event, rather than failing
+	// the run.
+	PostCommit func(ctx context.Context) error
 }
 
 // NewMigration returns a new Migration value.
@@ -37,6 +158,82 @@ func NewMigration(version int, commands ...string) Migration {
 	}
 }
 
+// NewMigrationWithDown returns a new Migration value, with the commands that revert it. See
+// Rollback.
+func NewMigrationWithDown(version int, up, down []string) Migration {
+	return Migration{
+		Version:  version,
+		Commands: up,
+		Down:     down,
+	}
+}
+
+// NewFuncMigration returns a new Migration value that calls the given function. See
+// Migration.Func.
+func NewFuncMigration(version int, fn func(ctx context.Context, tx Executor) error) Migration {
+	return Migration{
+		Version: version,
+		Func:    fn,
+	}
+}
+
+// Validate checks that the migration is well-formed, returning an error wrapping
+// ErrInvalidMigration describing the first problem found if it isn't.
+func (m Migration) Validate() error {
+	invalid := func(format string, args ...interface{}) error {
+		return fmt.Errorf("version %d: %s: %w", m.Version, fmt.Sprintf(format, args...), ErrInvalidMigration)
+	}
+
+	switch {
+	case m.Version < 0:
+		return invalid("version must not be negative")
+	case m.Sequence < 0 || m.Sequence >= SequenceLimit:
+		return invalid("sequence must be from 0 to %d", SequenceLimit-1)
+	case len(m.Down) > 0 && m.isEmpty():
+		return invalid("down commands given without up commands")
+	case m.isEmpty() && !m.NoOp:
+		return invalid("no commands, source, or func given, and not marked NoOp")
+	case m.NoOp && !m.isEmpty():
+		return invalid("NoOp migration must not have commands, a source, or a func")
+	case m.RequiresVersion < 0:
+		return invalid("required version must not be negative")
+	case m.RequiresVersion != 0 && m.RequiresVersion == m.Version:
+		return invalid("migration can't require itself")
+	case len(m.Cleanup) > 0 && !m.NoTransaction:
+		return invalid("cleanup commands are only used with NoTransaction")
+	case m.Source != nil && m.NoTransaction:
+		return invalid("source can't be executed with NoTransaction")
+	case m.Func != nil && m.NoTransaction:
+		return invalid("func can't be called with NoTransaction")
+	case m.Timeout < 0:
+		return invalid("timeout must not be negative")
+	}
+
+	for i, command := range m.Commands {
+		if strings.TrimSpace(command) == "" {
+			return invalid("command %d is empty", i)
+		}
+	}
+
+	return nil
+}
+
+// isEmpty returns true if the migration has nothing to execute.
+func (m Migration) isEmpty() bool {
+	return len(m.Commands) == 0 && m.Source == nil && m.Func == nil
+}
+
+// PendingResult summarises the migrations applied in a transaction that hasn't been committed.
+type PendingResult struct {
+	Namespace string
+	Versions  []int
+	Durations map[int]time.Duration
+
+	// RowsAffected contains the rows affected by each command of each version, if the driver
+	// implements ResultExecer.
+	RowsAffected map[int][]int64
+}
+
 // Migrations ...
 type Migrations map[int]Migration
 
@@ -46,175 +243,1511 @@ type NamespacedMigrations map[string]Migrations
 // Register ...
 // You can call this manually, or you can take advantage of `init` functions and just import a whole
 // package of migrations at once. Sub-packages could easily be the namespace, e.g. migrations/users.
+// Register panics if the migration is invalid, or its version is already taken, as those are
+// programming errors; use RegisterE to handle the error instead.
 func Register(namespace string, migration Migration) {
-	if _, ok := namespacedMigrations[namespace]; !ok {
-		namespacedMigrations[namespace] = make(Migrations)
+	if err := RegisterE(namespace, migration); err != nil {
+		panic(err)
 	}
+}
+
+// RegisterE validates the given migration, and then registers it. An error wrapping
+// ErrDuplicateVersion is returned if a different migration is already registered with the same
+// version in the namespace. Migrations with a Source, Func, or PostCommit are never considered the
+// same, as functions can't be compared. See Register.
+func RegisterE(namespace string, migration Migration) error {
+	return defaultRegistry.RegisterE(namespace, migration)
+}
+
+// OnRegistered adds a hook that's called for every migration registered after it's added, by any
+// of the Register functions, so that custom policies (e.g. naming or versioning conventions) can
+// be enforced in one place. If the hook returns an error, the migration isn't registered, and the
+// error is returned (or, from Register, panics). Hooks should be added before any migrations are
+// registered, e.g. in an init function of a package imported before any migration packages.
+// Hooks must not register migrations themselves.
+func OnRegistered(hook func(namespace string, migration Migration) error) {
+	defaultRegistry.OnRegistered(hook)
+}
 
-	namespacedMigrations[namespace][migration.Version] = migration
+// RegisterFS takes a filesystem and attempts to find SQL files to register as migrations. Files
+// are named "<version>.sql", or "<version>.up.sql" and "<version>.down.sql" to also register the
+// commands to revert a migration, for Rollback. The version may be followed by an underscore and
+// a name, which sets the migration's Name, e.g. "0003_add_users_table.up.sql", in which case the
+// up and down files must have the same name. A leading UTF-8 byte order mark is stripped from each
+// file, and CRLF line endings are normalized to LF.
+//
+// Magic comments in an up file (that isn't streamed) set options for its migration:
+// "-- migrate:no-transaction" sets NoTransaction, and "-- migrate:timeout 5m" sets Timeout. In
+// any file, statements between "-- migrate:statementbegin" and "-- migrate:statementend" lines are
+// registered as one command each, even if they contain semicolons, with the text around them as
+// separate commands (split into statements too if using WithStatementSplitting).
+//
+// In a namespace that uses sequences (see UseSequences), the version may be followed by a hyphen
+// and the migration's Sequence, e.g. "20240101120000-2_add_orders.sql", to order it after
+// "20240101120000_add_users.sql" (whose sequence is zero). An error wrapping ErrDuplicateVersion is
+// returned if more than one file defines the same version and sequence (e.g. "1.sql" and
+// "001.sql").
+func RegisterFS(namespace string, in fs.FS, opts ...FSOption) error {
+	return defaultRegistry.RegisterFS(namespace, in, opts...)
 }
 
-// RegisterFS takes a filesystem and attempts to find SQL files to register as migrations.
-func RegisterFS(namespace string, in fs.FS) error {
-	if _, ok := namespacedMigrations[namespace]; !ok {
-		namespacedMigrations[namespace] = make(Migrations)
+// readFS reads all of the migrations in the given filesystem, ordered by version and sequence. See
+// RegisterFS.
+func readFS(in fs.FS, o fsOptions) ([]Migration, error) {
+	// Up and down files for the same version are merged into one migration, so the whole
+	// filesystem is read before anything is registered.
+	migrationsByKey := make(map[versionKey]Migration)
+
+	// Two files for the same version, sequence, and direction (e.g. "1.sql" and "001.sql", or in
+	// different directories) can't be ordered, and are rejected.
+	paths := make(map[fileKey]string)
+
+	claim := func(path string, keys ...fileKey) error {
+		for _, key := range keys {
+			if other, ok := paths[key]; ok {
+				return fmt.Errorf("files %s and %s: %s: %w", other, path, describeVersion(key.version, key.sequence), ErrDuplicateVersion)
+			}
+		}
+
+		for _, key := range keys {
+			paths[key] = path
+		}
+
+		return nil
 	}
 
-	return fs.WalkDir(in, ".", func(path string, d fs.DirEntry, err error) error {
+	err := fs.WalkDir(in, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
 		if d.IsDir() {
+			if !o.versionDirectories || path == "." {
+				return nil
+			}
+
+			versionStr, name := splitName(d.Name())
+
+			version, sequence, err := parseVersion(versionStr)
+			if err != nil {
+				// Not a version directory, so it may contain migration files.
+				return nil
+			}
+
+			key := versionKey{version, sequence}
+
+			migration := migrationsByKey[key]
+			migration.Version, migration.Sequence = version, sequence
+
+			if err := setName(path, &migration, name); err != nil {
+				return err
+			}
+
+			hasDown, err := readVersionDir(in, path, o, &migration)
+			if err != nil {
+				return err
+			}
+
+			keys := []fileKey{{key, directionUp}}
+			if hasDown {
+				keys = append(keys, fileKey{key, directionDown})
+			}
+
+			err = claim(path, keys...)
+			if err != nil {
+				return err
+			}
+
+			migrationsByKey[key] = migration
+
+			return fs.SkipDir
+		}
+
+		if decode, ok := o.decoders[strings.ToLower(filepath.Ext(path))]; ok {
+			migration, err := decodeFile(in, path, decode)
+			if err != nil {
+				return err
+			}
+
+			key := versionKey{migration.Version, migration.Sequence}
+
+			// Decoded files define the whole migration, so can't be merged with any other file.
+			err = claim(path, fileKey{key, directionUp}, fileKey{key, directionDown})
+			if err != nil {
+				return err
+			}
+
+			migrationsByKey[key] = migration
+
 			return nil
 		}
 
 		// We only accept .sql files
-		ext := filepath.Ext(path)
-		if strings.ToLower(ext) != ".sql" {
+		version, sequence, name, direction, err := parseFilename(path)
+		if errors.Is(err, errNotMigration) {
 			return nil
 		}
 
-		// Get the version name, it must be an int
-		name := strings.TrimSuffix(filepath.Base(path), ext)
-		version, err := strconv.Atoi(name)
 		if err != nil {
-			return fmt.Errorf("failed to parse filename as int: %w", err)
+			return err
 		}
 
-		// Finally, let's read the contents...
-		file, err := in.Open(path)
+		key := versionKey{version, sequence}
+
+		err = claim(path, fileKey{key, direction})
 		if err != nil {
-			return fmt.Errorf("failed to open file: %w", err)
+			return err
+		}
+
+		migration := migrationsByKey[key]
+		migration.Version, migration.Sequence = version, sequence
+
+		if err := setName(path, &migration, name); err != nil {
+			return err
+		}
+
+		if direction == directionUp && o.streamThreshold > 0 {
+			info, err := d.Info()
+			if err != nil {
+				return fmt.Errorf("failed to stat file: %w", err)
+			}
+
+			if info.Size() > o.streamThreshold {
+				migration.Source = func() (io.ReadCloser, error) {
+					file, err := in.Open(path)
+					if err != nil {
+						return nil, err
+					}
+
+					return newBOMStrippingReader(file)
+				}
+
+				migrationsByKey[key] = migration
+				return nil
+			}
 		}
 
-		bs, err := ioutil.ReadAll(file)
+		// Finally, let's read the contents...
+		commands, fileDirectives, err := readSQLFile(in, path, o)
 		if err != nil {
-			return fmt.Errorf("failed to read file: %w", err)
+			return err
 		}
 
-		namespacedMigrations[namespace][version] = Migration{
-			Version:  version,
-			Commands: []string{string(bs)},
+		if direction == directionDown {
+			migration.Down = commands
+		} else {
+			migration.Commands = commands
+			fileDirectives.apply(&migration)
 		}
 
-		return err
+		migrationsByKey[key] = migration
+
+		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]Migration, 0, len(migrationsByKey))
+	for _, migration := range migrationsByKey {
+		migrations = append(migrations, migration)
+	}
+
+	sortMigrations(migrations)
+
+	return migrations, nil
 }
 
-// MustRegisterFS calls RegisterFS, but panics if an error is returned.
-func MustRegisterFS(namespace string, in fs.FS) {
-	if err := RegisterFS(namespace, in); err != nil {
-		panic(err)
+// setName sets the migration's name to the one given by the file or directory at path, if any,
+// returning an error if it doesn't match a name already given by another file.
+func setName(path string, migration *Migration, name string) error {
+	if name == "" {
+		return nil
 	}
+
+	if migration.Name != "" && migration.Name != name {
+		return fmt.Errorf("file %s: name %q doesn't match %q: %w", path, name, migration.Name, ErrInvalidMigration)
+	}
+
+	migration.Name = name
+
+	return nil
 }
 
-// Execute ...
-func Execute(driver Driver, events EventHandler, namespace string, timeout time.Duration) (err error) {
-	ctx, cfn := context.WithTimeout(context.Background(), timeout)
-	defer cfn()
+// readSQLFile reads the commands from the SQL file at path, along with its directives.
+func readSQLFile(in fs.FS, path string, o fsOptions) ([]string, directives, error) {
+	file, err := in.Open(path)
+	if err != nil {
+		return nil, directives{}, fmt.Errorf("failed to open file: %w", err)
+	}
 
-	// Check if we can possibly have any work to do. If we don't, bail.
-	migrationsByVersion, ok := namespacedMigrations[namespace]
-	if !ok {
-		return nil
+	defer file.Close()
+
+	bs, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, directives{}, fmt.Errorf("failed to read file: %w", err)
 	}
 
-	defer func() {
-		// We always want to roll back the transaction if any error occurred, if we've started doing
-		// some work. If we haven't started doing work, then we won't rollback. This just means we
-		// don't have to handle rolling back all over the place.
-		if err != nil {
-			rerr := driver.Rollback(ctx)
-			if rerr != nil && rerr != ErrTransactionNotStarted {
-				events.OnRollbackError(rerr)
-			}
+	bs = normalizeFile(bs)
 
-			events.OnExecuteError(err)
-		}
-	}()
+	fileDirectives, err := parseDirectives(string(bs))
+	if err != nil {
+		return nil, directives{}, fmt.Errorf("file %s: %w", path, err)
+	}
+
+	commands := []string{string(bs)}
+	switch {
+	case fileDirectives.statements:
+		commands, err = splitStatementBlocks(string(bs), o.splitDialect)
+	case o.splitDialect != "":
+		commands, err = splitStatements(string(bs), o.splitDialect)
+	}
 
-	// Before we can run migrations, lets check that the table exists?
-	exists, err := driver.VersionTableExists(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to check if versions table exists: %w", err)
+		return nil, directives{}, fmt.Errorf("failed to split file: %s: %w", path, err)
 	}
 
-	if !exists {
-		events.OnVersionTableNotExists()
+	return commands, fileDirectives, nil
+}
 
-		err := driver.CreateVersionsTable(ctx)
+// readVersionDir reads the SQL files in the version directory at path into migration, in name
+// order, as its commands, or as its down commands for ".down.sql" files. It returns whether any
+// down commands were read. See WithVersionDirectories.
+func readVersionDir(in fs.FS, path string, o fsOptions, migration *Migration) (bool, error) {
+	entries, err := fs.ReadDir(in, path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read version directory: %w", err)
+	}
+
+	var up, down []string
+	var dirDirectives []directives
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.ToLower(filepath.Ext(name)) != ".sql" {
+			continue
+		}
+
+		commands, fileDirectives, err := readSQLFile(in, path+"/"+name, o)
 		if err != nil {
-			return err
+			return false, err
 		}
 
-		events.OnVersionTableCreated()
+		if strings.HasSuffix(strings.ToLower(name), ".down.sql") {
+			down = append(down, commands...)
+			continue
+		}
+
+		up = append(up, commands...)
+		dirDirectives = append(dirDirectives, fileDirectives)
 	}
 
-	err = driver.Begin(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+	migration.Commands = up
+	migration.Down = append(migration.Down, down...)
+
+	for _, fileDirectives := range dirDirectives {
+		fileDirectives.apply(migration)
 	}
 
-	// Lock outside migrations. We want to lock before seeing what versions already exist so that we
-	// can be certain about the versions we are yet to insert.
-	err = driver.Lock(ctx)
+	return len(down) > 0, nil
+}
+
+// FSOption configures optional behaviour of RegisterFS.
+type FSOption func(*fsOptions)
+
+// fsOptions holds the configuration built up from FSOption values.
+type fsOptions struct {
+	streamThreshold int64
+	decoders        map[string]DecodeFunc
+	splitDialect    string
+
+	versionDirectories bool
+}
+
+// DecodeFunc decodes the contents of a migration file into a Migration. See WithDecoder.
+type DecodeFunc func(path string, data []byte) (Migration, error)
+
+// WithDecoder makes RegisterFS decode files with the given extension (e.g. ".json") using fn,
+// instead of ignoring them, so that migrations can be stored in structured formats including
+// their down commands and other fields. The version is taken from the decoded Migration, rather
+// than the filename. Files are passed to fn with any UTF-8 byte order mark stripped, and line
+// endings normalized. A decoder given for ".sql" replaces the default handling of SQL files.
+func WithDecoder(ext string, fn DecodeFunc) FSOption {
+	return func(o *fsOptions) {
+		if o.decoders == nil {
+			o.decoders = make(map[string]DecodeFunc)
+		}
+
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+
+		o.decoders[strings.ToLower(ext)] = fn
+	}
+}
+
+// decodeFile decodes the file at path with the given decoder.
+func decodeFile(in fs.FS, path string, decode DecodeFunc) (Migration, error) {
+	bs, err := fs.ReadFile(in, path)
 	if err != nil {
-		return fmt.Errorf("failed to lock versions table: %w", err)
+		return Migration{}, fmt.Errorf("failed to read file: %w", err)
 	}
 
-	existingVersions, err := driver.Versions(ctx)
+	migration, err := decode(path, normalizeFile(bs))
 	if err != nil {
-		return fmt.Errorf("failed to get current versions: %w", err)
+		return Migration{}, fmt.Errorf("failed to decode file: %s: %w", path, err)
 	}
 
-	for _, version := range existingVersions {
-		if _, ok := migrationsByVersion[version]; ok {
-			delete(migrationsByVersion, version)
-		}
+	return migration, nil
+}
+
+// versionKey identifies a migration by its version and sequence, before it's registered.
+type versionKey struct {
+	version  int
+	sequence int
+}
+
+// fileKey identifies the file that a version's commands in one direction were read from.
+type fileKey struct {
+	versionKey
+	direction string
+}
+
+// WithStreamThreshold registers files larger than the given number of bytes as streamed
+// migrations, using Migration.Source, instead of reading them into memory at registration time.
+func WithStreamThreshold(bytes int64) FSOption {
+	return func(o *fsOptions) {
+		o.streamThreshold = bytes
 	}
+}
 
-	var versions []int
-	for version := range migrationsByVersion {
-		versions = append(versions, version)
+// WithStatementSplitting registers one command per statement in each SQL file, rather than the
+// whole file as one command, for drivers that can't execute many statements at once. Statements
+// are split on semicolons, as the given dialect's client would (e.g. DialectPostgres), ignoring
+// those in strings, comments, and dollar-quoted strings, or, for DialectMySQL, changing the
+// delimiter with DELIMITER commands. Streamed files are always executed one statement at a time.
+//
+// Checksums (see WithChecksum) are computed over a migration's commands, so turning splitting on or
+// off changes the checksum of every file with more than one statement. Versions applied before then
+// fail verification (or are only reported, with WithChecksumWarnOnly), so when it's changed, their
+// stored checksums must be rebaselined.
+func WithStatementSplitting(dialect string) FSOption {
+	return func(o *fsOptions) {
+		o.splitDialect = dialect
 	}
+}
 
-	sort.Ints(versions)
+// WithVersionDirectories registers each directory named like a migration file, e.g. "0004" or
+// "0004_add_orders", as a single migration, rather than looking in it for migration files, so that
+// a large change can be split across readable files, but still applied atomically. The SQL files
+// in it become the migration's commands, in name order (e.g. "01_create.sql", "02_seed.sql"), or
+// its down commands for ".down.sql" files. Magic comments in any of its up files apply to the
+// whole migration. Files in its subdirectories are ignored, and it's never streamed.
+func WithVersionDirectories() FSOption {
+	return func(o *fsOptions) {
+		o.versionDirectories = true
+	}
+}
 
-	events.BeforeVersionsMigrate(versions)
+// MustRegisterFS calls RegisterFS, but panics if an error is returned.
+func MustRegisterFS(namespace string, in fs.FS, opts ...FSOption) {
+	if err := RegisterFS(namespace, in, opts...); err != nil {
+		panic(err)
+	}
+}
 
-	for _, version := range versions {
-		migration, ok := migrationsByVersion[version]
-		if !ok {
-			// This migration probably already existed, and was removed.
-			events.OnVersionSkipped(version)
+// Run applies all pending migrations in the given namespace, stopping if the given context is
+// done. Everything else is configured with options, including the EventHandler (WithEvents) and
+// timeout (WithTimeout), so new behaviour can be added without changing its signature. It's
+// equivalent to ExecuteContext.
+func Run(ctx context.Context, driver Driver, namespace string, opts ...Option) error {
+	o := newOptions(opts...)
+	return ExecuteContext(ctx, driver, o.events, namespace, o.timeout, opts...)
+}
+
+// Execute applies all pending migrations in the given namespace. If timeout is zero, the one given
+// with WithTimeout is used, if any. See ExecuteContext.
+func Execute(driver Driver, events EventHandler, namespace string, timeout time.Duration, opts ...Option) error {
+	return ExecuteContext(context.Background(), driver, events, namespace, timeout, opts...)
+}
+
+// ExecuteContext applies all pending migrations in the given namespace, stopping if the given
+// context is done, at the latest before the next command is executed. The timeout and the context's
+// deadline work together: if timeout is zero (and WithTimeout isn't given), only the context's
+// deadline applies (if it has one); if both are set, whichever is earlier applies. If events is
+// nil, the EventHandler given with WithEvents is used. The registered migrations are never
+// modified, so it's safe to call more than once, or concurrently (e.g. against several databases).
+func ExecuteContext(ctx context.Context, driver Driver, events EventHandler, namespace string, timeout time.Duration, opts ...Option) error {
+	_, err := ExecuteResult(ctx, driver, events, namespace, timeout, opts...)
+	return err
+}
+
+// Result describes what a run of ExecuteResult did.
+type Result struct {
+	// Applied contains the versions that were executed and committed, in the order they were
+	// applied. If an error is returned, this still includes those committed before it (e.g. when
+	// using WithTransactionPerMigration).
+	Applied []int
+	// Baselined contains the versions recorded without being executed. See WithBaselineRange.
+	Baselined []int
+	// Skipped contains the pending versions that weren't applied because they were no-ops,
+	// excluded, or no longer registered, or because another process applied them first.
+	Skipped []int
+	// Failed contains the versions that failed, in the order they failed, when using
+	// WithContinueOnError.
+	Failed []int
+	// Durations contains how long executing each applied version took.
+	Durations map[int]time.Duration
+	// Repeated contains the names of the repeatable migrations that were applied, in the order they
+	// were applied. See RepeatableMigration.
+	Repeated []string
+	// VersionsTableCreated is true if the versions table didn't exist, and was created.
+	VersionsTableCreated bool
+}
+
+// ExecuteResult is ExecuteContext, but also returns a Result describing what was done, even if
+// an error is returned.
+func ExecuteResult(ctx context.Context, driver Driver, events EventHandler, namespace string, timeout time.Duration, opts ...Option) (Result, error) {
+	o := newOptions(opts...)
+
+	if events == nil {
+		events = o.events
+	}
+
+	if timeout == 0 {
+		timeout = o.timeout
+	}
+
+	var cfn context.CancelFunc
+	if timeout > 0 {
+		ctx, cfn = context.WithTimeout(ctx, timeout)
+	} else {
+		ctx, cfn = context.WithCancel(ctx)
+	}
+
+	defer cfn()
+
+	if len(o.stopSignals) > 0 {
+		stopCtx, stop := signal.NotifyContext(context.Background(), o.stopSignals...)
+		defer stop()
+
+		o.stops = append(o.stops, stopCtx.Done())
+	}
+
+	result := Result{Durations: make(map[int]time.Duration)}
+	backoff := serializationRetryBackoff
+
+	// The errors of versions that failed, when continuing on error.
+	var failures ExecuteErrors
+
+	for attempt := 1; ; attempt++ {
+		// Anything committed by earlier attempts stays in the result, but what was skipped is
+		// decided again.
+		result.Skipped = nil
+
+		err := execute(ctx, driver, events, namespace, o, &result)
+
+		// Only the failed version's transaction was rolled back, so the rest are applied by
+		// another attempt, which leaves it out.
+		var merr *MigrationError
+		if o.continueOnError && errors.As(err, &merr) && !o.failed[merr.Version] {
+			o.failed[merr.Version] = true
+			result.Failed = append(result.Failed, merr.Version)
+			failures = append(failures, err)
+			attempt, backoff = 0, serializationRetryBackoff
 			continue
 		}
 
-		if len(migration.Commands) == 0 {
-			// Skip empty migrations
-			events.OnVersionSkipped(version)
-			continue
+		if err == nil || attempt > o.serializationRetries || !isSerializationFailure(driver, err) {
+			return result, failures.with(err)
 		}
 
-		events.BeforeVersionMigrate(version)
+		events.OnSerializationRetry(attempt, err)
 
-		for i, command := range migration.Commands {
-			err = driver.Exec(ctx, command)
-			if err != nil {
-				return fmt.Errorf("failed to execute migration (command %d): %w", i, err)
-			}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return result, err
 		}
 
-		err = driver.InsertVersion(ctx, version)
+		backoff *= 2
+	}
+}
+
+// execute makes a single attempt at applying all pending migrations in the given namespace.
+func execute(ctx context.Context, driver Driver, events EventHandler, namespace string, o *options, result *Result) (err error) {
+	// Check if we can possibly have any work to do. If we don't, bail. This is a snapshot, so the
+	// registry is never affected by what's done with it below.
+	migrationsByVersion, repeatables, done, err := o.registry.beginExecute(ctx, namespace)
+	if err != nil {
+		return err
+	}
+
+	defer done()
+
+	if migrationsByVersion == nil && len(repeatables) == 0 {
+		return nil
+	}
+
+	if o.preExecuteGate != nil {
+		err = o.preExecuteGate(ctx)
 		if err != nil {
-			return fmt.Errorf("failed to insert version: %w", err)
+			return fmt.Errorf("pre-execute gate refused run: %w", err)
+		}
+	}
+
+	if o.tableNameFunc != nil {
+		namer, ok := driver.(TableNamer)
+		if !ok {
+			return ErrTableNamingNotSupported
 		}
 
-		events.AfterVersionMigrate(version)
+		driver, err = namer.ForTable(o.tableNameFunc(namespace))
+		if err != nil {
+			return fmt.Errorf("failed to use versions table for namespace %q: %w", namespace, err)
+		}
 	}
 
-	events.AfterVersionsMigrate(versions)
+	var scoper NamespaceScoper
+	if o.sharedVersionsTable {
+		var ok bool
+		scoper, ok = driver.(NamespaceScoper)
+		if !ok {
+			return ErrSharedVersionsTableNotSupported
+		}
 
-	err = driver.Commit(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
+		driver, err = scoper.ForNamespace(namespace)
+		if err != nil {
+			return fmt.Errorf("failed to scope versions table to namespace %q: %w", namespace, err)
+		}
+	}
+
+	// Without transactions, each version takes effect as it's applied, so each is applied and
+	// recorded on its own, and the result reflects what's really applied if one fails.
+	if !isTransactional(driver) {
+		nonTransactional := *o
+		nonTransactional.transactionPerMigration = true
+		nonTransactional.noTransactions = true
+		o = &nonTransactional
+	}
+
+	if o.dryRun != nil {
+		return o.writeDryRun(ctx, driver, namespace, migrationsByVersion, repeatables)
+	}
+
+	// The session must be closed after any rollback, so this is deferred first.
+	session, _ := driver.(SessionDriver)
+	if session != nil {
+		defer func() {
+			cerr := session.Close(ctx)
+			if cerr != nil && err == nil {
+				err = fmt.Errorf("failed to close driver session: %w", cerr)
+			}
+		}()
+	}
+
+	// The version currently being applied, for diagnostics. -1 when not applying a version.
+	current := -1
+
+	runEntry := newRunLogEntry(namespace, o.appVersion)
+
+	// The run log, if enabled, and the versions committed so far, for recording failed runs.
+	var runLog RunLogDriver
+	var committedVersions []int
+
+	// This is deferred before the rollback, so that it runs after any panic has been recovered
+	// below, and doesn't report a run that panicked as successful.
+	defer func() {
+		if err == nil {
+			// The driver's identity is taken again, as some drivers only know it once connected.
+			events.AfterExecute(Info(driver))
+		}
+	}()
+
+	defer func() {
+		// We always want to roll back the transaction if any error occurred, if we've started doing
+		// some work. If we haven't started doing work, then we won't rollback. This just means we
+		// don't have to handle rolling back all over the place.
+		if err != nil {
+			if o.failureDiagnostics != nil {
+				o.diagnose(driver, current)
+			}
+
+			// If the connection was closed, the transaction went with it, so there's nothing to do.
+			rerr := driver.Rollback(ctx)
+			if rerr != nil && rerr != ErrTransactionNotStarted && !errors.Is(rerr, ErrConnectionClosed) {
+				events.OnRollbackError(rerr)
+			}
+
+			if runLog != nil {
+				lerr := recordFailedRun(driver, runLog, runEntry.finished(committedVersions, err))
+				if lerr != nil {
+					err = fmt.Errorf("%w (and failed to record run log: %v)", err, lerr)
+				}
+			}
+
+			events.OnExecuteError(err)
+		}
+	}()
+
+	// A panic (e.g. in a Func migration, or the driver) is turned into an error before the deferred
+	// rollback above, so that the transaction is rolled back, and the lock released, as usual. It's
+	// deferred again after any other deferred function that checks err, so that it runs first.
+	recoverPanic := func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Version: current, Value: r, Stack: debug.Stack()}
+		}
+	}
+
+	defer recoverPanic()
+
+	if session != nil {
+		err = session.Open(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to open driver session: %w", err)
+		}
+	}
+
+	events.BeforeExecute(Info(driver))
+
+	// Before we can run migrations, lets check that the table exists?
+	exists, err := driver.VersionTableExists(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check if versions table exists: %w", err)
+	}
+
+	if !exists {
+		events.OnVersionTableNotExists()
+
+		if o.requireEmptyOnFirstRun {
+			err = checkSchemaEmpty(ctx, driver)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := driver.CreateVersionsTable(ctx)
+		if err != nil {
+			return err
+		}
+
+		result.VersionsTableCreated = true
+		events.OnVersionTableCreated()
+	}
+
+	if scoper != nil {
+		err = scoper.CreateNamespaceColumn(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to create namespace column: %w", err)
+		}
+	}
+
+	var checksums ChecksumDriver
+	if o.checksums {
+		checksums, err = o.checksumDriver(driver)
+		if err != nil {
+			return err
+		}
+
+		err = checksums.CreateChecksumColumn(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to create checksum column: %w", err)
+		}
+	}
+
+	var toolVersions ToolVersionDriver
+	if o.toolVersion != "" {
+		var ok bool
+		toolVersions, ok = driver.(ToolVersionDriver)
+		if !ok {
+			return ErrToolVersionNotSupported
+		}
+
+		err = toolVersions.CreateToolVersionColumn(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to create tool version column: %w", err)
+		}
+	}
+
+	var savepoints SavepointDriver
+	if o.savepoints && o.commitConfirmation == nil {
+		var ok bool
+		savepoints, ok = driver.(SavepointDriver)
+		if !ok {
+			return ErrSavepointNotSupported
+		}
+	}
+
+	var descriptions DescriptionDriver
+	if o.descriptions {
+		var ok bool
+		descriptions, ok = driver.(DescriptionDriver)
+		if !ok {
+			return ErrDescriptionNotSupported
+		}
+
+		err = descriptions.CreateDescriptionColumn(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to create description column: %w", err)
+		}
+	}
+
+	var notifier Notifier
+	if o.notifyChannel != "" {
+		var ok bool
+		notifier, ok = driver.(Notifier)
+		if !ok {
+			return ErrNotifyNotSupported
+		}
+	}
+
+	if o.runLog {
+		rl, ok := driver.(RunLogDriver)
+		if !ok {
+			return ErrRunLogNotSupported
+		}
+
+		err = rl.CreateRunLogTable(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to create run log table: %w", err)
+		}
+
+		// Only set once the table exists, so that failures before now aren't recorded.
+		runLog = rl
+	}
+
+	var checkpoints CheckpointDriver
+	if o.checkpoints {
+		var ok bool
+		checkpoints, ok = driver.(CheckpointDriver)
+		if !ok {
+			return ErrCheckpointNotSupported
+		}
+
+		err = checkpoints.CreateCheckpointTable(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to create checkpoint table: %w", err)
+		}
+	}
+
+	var repeatableDriver RepeatableDriver
+	if len(repeatables) > 0 {
+		var ok bool
+		repeatableDriver, ok = driver.(RepeatableDriver)
+		if !ok {
+			return ErrRepeatableNotSupported
+		}
+
+		err = repeatableDriver.CreateRepeatableTable(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to create repeatable migrations table: %w", err)
+		}
+	}
+
+	var metadata MetadataDriver
+	if o.tamperDetection {
+		var ok bool
+		metadata, ok = driver.(MetadataDriver)
+		if !ok {
+			return ErrMetadataNotSupported
+		}
+
+		err = metadata.CreateMetadataTable(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to create metadata table: %w", err)
+		}
+	}
+
+	// Runs using a transaction per migration are marked as in progress until they finish, so that
+	// an interrupted run can be detected, if the driver can store metadata.
+	var runMarker MetadataDriver
+	if o.transactionPerMigration {
+		if md, ok := driver.(MetadataDriver); ok {
+			err = md.CreateMetadataTable(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to create metadata table: %w", err)
+			}
+
+			runMarker = md
+		}
+	}
+
+	err = driver.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	// Lock outside migrations. We want to lock before seeing what versions already exist so that we
+	// can be certain about the versions we are yet to insert.
+	err = o.lock(ctx, driver)
+	if err != nil {
+		return fmt.Errorf("failed to lock versions table: %w", err)
+	}
+
+	existingVersions, err := driver.Versions(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get current versions: %w", err)
+	}
+
+	if metadata != nil {
+		err = verifyVersionsSignature(ctx, metadata, existingVersions)
+		if err != nil {
+			return err
+		}
+	}
+
+	if checksums != nil {
+		stored, err := checksums.Checksums(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get current checksums: %w", err)
+		}
+
+		for version, checksum := range stored {
+			if migration, ok := migrationsByVersion[version]; ok {
+				if err := o.verifyChecksum(migration, checksum); err != nil {
+					if !o.checksumWarnOnly {
+						return err
+					}
+
+					events.OnChecksumMismatch(version, err)
+				}
+			}
+		}
+	}
+
+	if o.refuseUnknownVersions {
+		var unknown []int
+		for _, version := range existingVersions {
+			if _, ok := migrationsByVersion[version]; !ok {
+				unknown = append(unknown, version)
+			}
+		}
+
+		if len(unknown) > 0 {
+			sort.Ints(unknown)
+			return fmt.Errorf("versions %v: %w", unknown, ErrUnknownVersions)
+		}
+	}
+
+	// The pending versions are worked out without modifying the registered migrations, so that
+	// Execute can be called again, or concurrently, and see the same migrations.
+	var versions []int
+	for _, version := range pendingVersions(migrationsByVersion, existingVersions) {
+		if o.leavePending(version) {
+			continue
+		}
+
+		versions = append(versions, version)
+	}
+
+	if outOfOrder := o.outOfOrder(versions, existingVersions); len(outOfOrder) > 0 {
+		if o.strictOrdering {
+			return fmt.Errorf("versions %v: %w", outOfOrder, ErrOutOfOrder)
+		}
+
+		events.OnVersionsOutOfOrder(outOfOrder)
+	}
+
+	if o.maxPending > 0 && !o.overridePendingGuard {
+		var count int
+		for _, version := range versions {
+			if !o.exclude[version] {
+				count++
+			}
+		}
+
+		if count > o.maxPending {
+			return fmt.Errorf("%d pending versions, more than the maximum of %d: %w", count, o.maxPending, ErrTooManyPending)
+		}
+	}
+
+	if runMarker != nil {
+		resuming, err := checkResume(ctx, driver, runMarker)
+		if err != nil {
+			return err
+		}
+
+		if resuming && len(versions) > 0 {
+			events.OnResume(versions[0])
+		}
+	}
+
+	events.BeforeVersionsMigrate(versions)
+
+	// Versions known to have been committed, for checking migrations' required versions.
+	committed := make(map[int]bool, len(existingVersions))
+	for _, version := range existingVersions {
+		committed[version] = true
+	}
+
+	batches := o.batches(versions, migrationsByVersion)
+
+	// Confirmation only makes sense if nothing is committed before it's given.
+	if o.commitConfirmation != nil && len(batches) > 1 {
+		return ErrConfirmationNeedsOneTransaction
+	}
+
+	pending := PendingResult{
+		Namespace:    namespace,
+		Durations:    make(map[int]time.Duration),
+		RowsAffected: make(map[int][]int64),
+	}
+
+	// Migrations applied in the current batch with a PostCommit callback.
+	var postCommits []Migration
+
+	// The versions in the current batch that were executed, rather than baselined.
+	var executed []int
+
+	// Whether the version being applied is within a savepoint.
+	var savepointed bool
+
+	// Cancels the context of the version being applied, if it has a timeout.
+	cancelVersion := func() {}
+	defer func() { cancelVersion() }()
+
+	if savepoints != nil {
+		// This runs before the transaction is rolled back, so that the versions applied before the
+		// one that failed can be committed instead.
+		defer func() {
+			if err == nil || !savepointed {
+				return
+			}
+
+			rerr := savepoints.RollbackToSavepoint(ctx, savepointName)
+			if rerr != nil {
+				events.OnRollbackError(rerr)
+				return
+			}
+
+			cerr := commitBatch(ctx, driver, metadata)
+			if cerr != nil {
+				events.OnRollbackError(cerr)
+				err = fmt.Errorf("%w (and failed to commit the versions applied before it: %v)", err, cerr)
+				return
+			}
+
+			committedVersions = append(committedVersions[:0], pending.Versions...)
+			result.applied(executed, pending.Durations)
+			postCommit(ctx, events, postCommits)
+		}()
+	}
+
+	// A panic while applying a version must be recovered before the savepoint handling above, so
+	// that the versions applied before it are still committed.
+	defer recoverPanic()
+
+	for i, batch := range batches {
+		var applied []int
+
+		if i > 0 {
+			// The previous batch's transaction was committed, releasing the lock. Another process
+			// may have applied some of this batch's versions in the meantime, so check again.
+			remaining, err := o.beginBatch(ctx, driver, events, metadata, batch)
+			if err != nil {
+				return err
+			}
+
+			result.Skipped = append(result.Skipped, difference(batch, remaining)...)
+			batch = remaining
+		}
+
+		for _, version := range batch {
+			migration, ok := migrationsByVersion[version]
+			if !ok {
+				// This migration probably already existed, and was removed.
+				result.Skipped = append(result.Skipped, version)
+				events.OnVersionSkipped(version)
+				continue
+			}
+
+			if o.exclude[version] {
+				result.Skipped = append(result.Skipped, version)
+				events.OnVersionExcluded(version)
+				continue
+			}
+
+			if o.failed[version] {
+				// This version already failed in an earlier attempt. See WithContinueOnError.
+				continue
+			}
+
+			if o.stopRequested() {
+				return fmt.Errorf("stopped before version %d: %w", version, ErrStopped)
+			}
+
+			if o.baselineRange != nil && version >= o.baselineRange[0] && version <= o.baselineRange[1] {
+				err = o.record(ctx, driver, checksums, toolVersions, descriptions, migration)
+				if err != nil {
+					return err
+				}
+
+				result.Baselined = append(result.Baselined, version)
+				events.OnVersionBaselined(version)
+
+				applied = append(applied, version)
+				pending.Versions = append(pending.Versions, version)
+				continue
+			}
+
+			if migration.NoOp {
+				// Skip no-op migrations
+				result.Skipped = append(result.Skipped, version)
+				events.OnVersionSkipped(version)
+				continue
+			}
+
+			if migration.RequiresVersion != 0 && !committed[migration.RequiresVersion] {
+				return fmt.Errorf("version %d requires version %d: %w", version, migration.RequiresVersion, ErrRequiredVersionNotCommitted)
+			}
+
+			exec := driver.Exec
+			if migration.NoTransaction {
+				ntd, ok := driver.(NoTransactionDriver)
+				if !ok {
+					return fmt.Errorf("version %d: %w", version, ErrNoTransactionNotSupported)
+				}
+
+				exec = ntd.ExecNoTransaction
+			}
+
+			// Rows affected are reported when the driver can provide them, which is -1 otherwise.
+			execResult := func(ctx context.Context, command string) (int64, error) {
+				return -1, exec(ctx, command)
+			}
+
+			if re, ok := driver.(ResultExecer); ok && !migration.NoTransaction {
+				execResult = re.ExecResult
+			}
+
+			if savepoints != nil {
+				err = savepoints.Savepoint(ctx, savepointName)
+				if err != nil {
+					return fmt.Errorf("failed to set savepoint: %w", err)
+				}
+
+				savepointed = true
+			}
+
+			events.BeforeVersionMigrate(version)
+			current = version
+			start := time.Now()
+
+			// Commands run with the version's own timeout, if it has one. The version is still
+			// recorded with ctx, so bookkeeping isn't cut short by it.
+			versionTimeout := o.versionTimeout(migration)
+
+			var versionCtx context.Context
+			versionCtx, cancelVersion = withTimeout(ctx, versionTimeout)
+
+			// Commands that completed in an earlier run that failed part way are skipped.
+			checkpointed := checkpoints != nil && migration.NoTransaction
+			resume := 0
+			if checkpointed {
+				resume, err = resumeFrom(ctx, checkpoints, migration)
+				if err != nil {
+					return err
+				}
+
+				if resume > 0 {
+					events.OnMigrationResumed(version, resume)
+				}
+			}
+
+			for i, command := range migration.Commands {
+				if i < resume {
+					continue
+				}
+
+				// Not every driver notices a cancelled context until it's used, so stop here rather
+				// than starting another command.
+				if err = versionCtx.Err(); err != nil {
+					err = timeoutError(ctx, versionCtx, versionCtx, versionTimeout, 0, err)
+					return fmt.Errorf("stopped before version %d command %d: %w", version, i, err)
+				}
+
+				var rowsAffected int64
+
+				commandCtx, cancelCommand := withTimeout(versionCtx, o.commandTimeout)
+				rowsAffected, err = execResult(commandCtx, command)
+				if err != nil {
+					err = timeoutError(ctx, versionCtx, commandCtx, versionTimeout, o.commandTimeout, err)
+				}
+				cancelCommand()
+
+				if err != nil {
+					// This is fired before rolling back, as on databases without transactional DDL
+					// the succeeded commands may have been committed implicitly, and need fixing.
+					events.OnMigrationPartialFailure(version, i, i, err)
+
+					if migration.NoTransaction && len(migration.Cleanup) > 0 {
+						cleanup(ctx, exec, events, migration)
+					}
+
+					merr := newMigrationError(driver, version, i, err)
+					merr.PartiallyApplied = o.noTransactions && i > 0
+
+					return merr
+				}
+
+				if rowsAffected >= 0 {
+					events.OnCommandResult(version, i, rowsAffected)
+					pending.RowsAffected[version] = append(pending.RowsAffected[version], rowsAffected)
+				}
+
+				if checkpointed {
+					err = checkpoints.SetCheckpoint(ctx, version, i+1, ChecksumSHA256(migration.Commands))
+					if err != nil {
+						return fmt.Errorf("failed to set checkpoint: %w", err)
+					}
+				}
+			}
+
+			if migration.Source != nil {
+				err = execSource(versionCtx, driver, migration)
+				if err != nil {
+					err = timeoutError(ctx, versionCtx, versionCtx, versionTimeout, 0, err)
+					events.OnMigrationPartialFailure(version, len(migration.Commands), len(migration.Commands), err)
+
+					merr := newMigrationError(driver, version, len(migration.Commands), err)
+					merr.PartiallyApplied = o.noTransactions
+
+					return merr
+				}
+			}
+
+			if migration.Func != nil {
+				err = migration.Func(versionCtx, executorFunc(driver.Exec))
+				if err != nil {
+					err = timeoutError(ctx, versionCtx, versionCtx, versionTimeout, 0, err)
+					events.OnMigrationPartialFailure(version, len(migration.Commands), len(migration.Commands), err)
+
+					merr := newMigrationError(driver, version, len(migration.Commands), err)
+					merr.PartiallyApplied = o.noTransactions
+
+					return merr
+				}
+			}
+
+			cancelVersion()
+
+			err = o.record(ctx, driver, checksums, toolVersions, descriptions, migration)
+			if err != nil {
+				return err
+			}
+
+			if checkpointed {
+				err = checkpoints.DeleteCheckpoint(ctx, version)
+				if err != nil {
+					return fmt.Errorf("failed to delete checkpoint: %w", err)
+				}
+			}
+
+			if savepointed {
+				err = savepoints.ReleaseSavepoint(ctx, savepointName)
+				if err != nil {
+					return fmt.Errorf("failed to release savepoint: %w", err)
+				}
+
+				savepointed = false
+			}
+
+			events.AfterVersionMigrate(version)
+			current = -1
+
+			applied = append(applied, version)
+			if migration.PostCommit != nil {
+				postCommits = append(postCommits, migration)
+			}
+			pending.Versions = append(pending.Versions, version)
+			pending.Durations[version] = time.Since(start)
+			executed = append(executed, version)
+		}
+
+		// The final batch is committed below, along with the planning transaction if there were no
+		// batches at all.
+		if i < len(batches)-1 {
+			err = commitBatch(ctx, driver, metadata)
+			if err != nil {
+				return err
+			}
+
+			for _, version := range applied {
+				committed[version] = true
+			}
+
+			committedVersions = append(committedVersions, applied...)
+			result.applied(executed, pending.Durations)
+			executed = nil
+
+			postCommit(ctx, events, postCommits)
+			postCommits = nil
+		}
+	}
+
+	events.AfterVersionsMigrate(versions)
+
+	var repeated []string
+	if repeatableDriver != nil {
+		repeated, err = applyRepeatables(ctx, driver, repeatableDriver, events, repeatables)
+		if err != nil {
+			return err
+		}
+	}
+
+	if o.commitConfirmation != nil {
+		confirmed, err := o.commitConfirmation(pending)
+		if err != nil {
+			return fmt.Errorf("failed to confirm commit: %w", err)
+		}
+
+		if !confirmed {
+			return ErrCommitNotConfirmed
+		}
+	}
+
+	if runMarker != nil {
+		err = finishRun(ctx, runMarker)
+		if err != nil {
+			return err
+		}
+	}
+
+	if runLog != nil {
+		err = runLog.InsertRunLog(ctx, runEntry.finished(pending.Versions, nil))
+		if err != nil {
+			return fmt.Errorf("failed to insert run log entry: %w", err)
+		}
+	}
+
+	err = commitBatch(ctx, driver, metadata)
+	if err != nil {
+		return err
+	}
+
+	result.applied(executed, pending.Durations)
+	result.Repeated = append(result.Repeated, repeated...)
+	postCommit(ctx, events, postCommits)
+
+	if notifier != nil && len(pending.Versions) > 0 {
+		o.notify(ctx, notifier, events, namespace, pending.Versions)
+	}
+
+	return nil
+}
+
+// difference returns the versions in a that aren't in b.
+func difference(a, b []int) []int {
+	in := versionSet(b)
+
+	var diff []int
+	for _, version := range a {
+		if !in[version] {
+			diff = append(diff, version)
+		}
+	}
+
+	return diff
+}
+
+// applied adds the given versions, which have just been committed, to the result.
+func (r *Result) applied(versions []int, durations map[int]time.Duration) {
+	for _, version := range versions {
+		r.Applied = append(r.Applied, version)
+		r.Durations[version] = durations[version]
+	}
+}
+
+// record records the given migration as applied, along with its checksum, the tool version, and
+// its description if they're enabled, as part of the transaction.
+func (o *options) record(ctx context.Context, driver Driver, checksums ChecksumDriver, toolVersions ToolVersionDriver, descriptions DescriptionDriver, migration Migration) error {
+	err := driver.InsertVersion(ctx, migration.Version)
+	if err != nil {
+		return fmt.Errorf("failed to insert version: %w", err)
+	}
+
+	if checksums != nil {
+		err = checksums.SetChecksum(ctx, migration.Version, o.checksum(migration))
+		if err != nil {
+			return fmt.Errorf("failed to set checksum: %w", err)
+		}
+	}
+
+	if toolVersions != nil {
+		err = toolVersions.SetToolVersion(ctx, migration.Version, o.toolVersion)
+		if err != nil {
+			return fmt.Errorf("failed to set tool version: %w", err)
+		}
+	}
+
+	if descriptions != nil && migration.Name != "" {
+		err = descriptions.SetDescription(ctx, migration.Version, migration.Name)
+		if err != nil {
+			return fmt.Errorf("failed to set description: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// postCommit calls the PostCommit callbacks of the given committed migrations, in order. Errors
+// are reported through events, as the migrations are already committed.
+func postCommit(ctx context.Context, events EventHandler, migrations []Migration) {
+	for _, migration := range migrations {
+		err := migration.PostCommit(ctx)
+		if err != nil {
+			events.OnPostCommitError(migration.Version, err)
+		}
+	}
+}
+
+// checkSchemaEmpty returns an error wrapping ErrSchemaNotEmpty if the driver's schema contains
+// any tables other than migrate's own.
+func checkSchemaEmpty(ctx context.Context, driver Driver) error {
+	checker, ok := driver.(EmptySchemaChecker)
+	if !ok {
+		return ErrEmptySchemaCheckNotSupported
+	}
+
+	empty, err := checker.IsSchemaEmpty(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !empty {
+		return fmt.Errorf("refusing to create versions table on first run: %w", ErrSchemaNotEmpty)
+	}
+
+	return nil
+}
+
+// batches splits the given sorted versions into the groups that should each be applied in their
+// own transaction. By default, that's a single group containing every version. When using a
+// transaction per migration, each version gets its own group, except that consecutive versions
+// sharing a ReleaseID are kept together so that they're committed or rolled back as one. Versions
+// that can't run in a transaction, or any version if the driver doesn't support transactions,
+// always get a group of their own.
+func (o *options) batches(versions []int, migrationsByVersion Migrations) [][]int {
+	var batches [][]int
+	var previous Migration
+
+	for _, version := range versions {
+		migration := migrationsByVersion[version]
+
+		join := len(batches) > 0 && !migration.NoTransaction && !previous.NoTransaction && !o.noTransactions
+		if o.transactionPerMigration {
+			join = join && migration.ReleaseID != "" && migration.ReleaseID == previous.ReleaseID
+		}
+
+		if join {
+			batches[len(batches)-1] = append(batches[len(batches)-1], version)
+		} else {
+			batches = append(batches, []int{version})
+		}
+
+		previous = migration
+	}
+
+	return batches
+}
+
+// beginBatch begins a new transaction and locks the versions table for the given batch, returning
+// the versions in the batch that still haven't been applied.
+func (o *options) beginBatch(ctx context.Context, driver Driver, events EventHandler, metadata MetadataDriver, batch []int) ([]int, error) {
+	err := driver.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	err = o.lock(ctx, driver)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock versions table: %w", err)
+	}
+
+	existingVersions, err := driver.Versions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current versions: %w", err)
+	}
+
+	if metadata != nil {
+		err = verifyVersionsSignature(ctx, metadata, existingVersions)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	existing := make(map[int]bool, len(existingVersions))
+	for _, version := range existingVersions {
+		existing[version] = true
+	}
+
+	var pending []int
+	for _, version := range batch {
+		if existing[version] {
+			events.OnVersionSkipped(version)
+			continue
+		}
+
+		pending = append(pending, version)
+	}
+
+	return pending, nil
+}
+
+// savepointName is the name of the savepoint each version is applied within. See WithSavepoints.
+const savepointName = "migrate_version"
+
+// commitBatch commits the current transaction, first updating the versions table signature if
+// tamper detection is enabled.
+func commitBatch(ctx context.Context, driver Driver, metadata MetadataDriver) error {
+	if metadata != nil {
+		err := updateVersionsSignature(ctx, driver, metadata)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := driver.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// cleanup executes the given migration's cleanup commands after it failed. Errors are reported via
+// events, rather than returned, as the original failure is the more important error.
+func cleanup(ctx context.Context, exec func(context.Context, string) error, events EventHandler, migration Migration) {
+	events.BeforeVersionCleanup(migration.Version)
+
+	for i, command := range migration.Cleanup {
+		err := exec(ctx, command)
+		if err != nil {
+			events.OnCleanupError(migration.Version, fmt.Errorf("failed to execute cleanup (command %d): %w", i, err))
+			return
+		}
+	}
+
+	events.AfterVersionCleanup(migration.Version)
+}
+
+// execSource streams the given migration's Source to the driver.
+func execSource(ctx context.Context, driver Driver, migration Migration) error {
+	execer, ok := driver.(ReaderExecer)
+	if !ok {
+		return fmt.Errorf("version %d: %w", migration.Version, ErrReaderExecNotSupported)
+	}
+
+	r, err := migration.Source()
+	if err != nil {
+		return fmt.Errorf("failed to open migration source: %w", err)
+	}
+
+	defer r.Close()
+
+	err = execer.ExecReader(ctx, r)
+	if err != nil {
+		return fmt.Errorf("failed to execute migration source: %w", err)
 	}
 
 	return nil
@@ -0,0 +1,93 @@
+// Package migratehttp provides HTTP handlers for exposing migration status, e.g. for readiness
+// checks.
+package migratehttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/seeruk/go-migrate"
+)
+
+// Status is the JSON body written by the status handler.
+type Status struct {
+	Namespace      string `json:"namespace"`
+	CurrentVersion int    `json:"current_version"`
+	Pending        int    `json:"pending"`
+	Migrated       bool   `json:"migrated"`
+	Error          string `json:"error,omitempty"`
+}
+
+// StatusOption configures optional behaviour of the status handler.
+type StatusOption func(*statusHandler)
+
+// WithPendingStatus sets the HTTP status code written when there are pending migrations. The
+// default is http.StatusServiceUnavailable. Use http.StatusOK to only report, never fail.
+func WithPendingStatus(code int) StatusOption {
+	return func(h *statusHandler) {
+		h.pendingStatus = code
+	}
+}
+
+// statusHandler is an http.Handler that reports migration status as JSON.
+type statusHandler struct {
+	// Drivers track transaction state, so may only be used by one request at a time.
+	mu            sync.Mutex
+	driver        migrate.Driver
+	namespace     string
+	pendingStatus int
+}
+
+// NewStatusHandler returns an http.Handler that reports the migration status of the given
+// namespace as JSON: the current (highest applied) version, the number of pending migrations,
+// and whether the namespace is fully migrated. It responds with 200 when fully migrated, 503 when
+// migrations are pending (see WithPendingStatus), and 500 if the status couldn't be determined.
+// The driver shouldn't be shared with anything else that uses it concurrently, such as Execute.
+func NewStatusHandler(driver migrate.Driver, namespace string, opts ...StatusOption) http.Handler {
+	h := &statusHandler{
+		driver:        driver,
+		namespace:     namespace,
+		pendingStatus: http.StatusServiceUnavailable,
+	}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	return h
+}
+
+// ServeHTTP ...
+func (h *statusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status, code := h.status(r)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	_ = json.NewEncoder(w).Encode(status)
+}
+
+// status determines the current migration status, and the HTTP status code to respond with.
+func (h *statusHandler) status(r *http.Request) (Status, int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	status := Status{Namespace: h.namespace}
+
+	state, err := migrate.Observe(h.driver, h.namespace, r.Context())
+	if err != nil {
+		status.Error = err.Error()
+		return status, http.StatusInternalServerError
+	}
+
+	status.CurrentVersion = state.Current()
+	status.Pending = len(state.Pending)
+	status.Migrated = len(state.Pending) == 0
+
+	if !status.Migrated {
+		return status, h.pendingStatus
+	}
+
+	return status, http.StatusOK
+}
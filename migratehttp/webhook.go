@@ -0,0 +1,150 @@
+package migratehttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/seeruk/go-migrate"
+)
+
+// webhookTimeout is how long delivering a webhook may take.
+const webhookTimeout = 5 * time.Second
+
+// WebhookPayload is the JSON body POSTed by the webhook event handler after a successful run.
+type WebhookPayload struct {
+	Versions []int     `json:"versions"`
+	Dialect  string    `json:"dialect,omitempty"`
+	Host     string    `json:"host,omitempty"`
+	Database string    `json:"database,omitempty"`
+	Schema   string    `json:"schema,omitempty"`
+	Table    string    `json:"table,omitempty"`
+	SentAt   time.Time `json:"sent_at"`
+}
+
+// WebhookOption configures optional behaviour of the webhook event handler.
+type WebhookOption func(*webhookEventHandler)
+
+// WithWebhookErrorHandler sets a function that's called with the error when a webhook can't be
+// delivered. By default, delivery failures are ignored.
+func WithWebhookErrorHandler(fn func(err error)) WebhookOption {
+	return func(h *webhookEventHandler) {
+		h.onError = fn
+	}
+}
+
+// webhookEventHandler is a migrate.EventHandler that POSTs a summary of each successful run.
+type webhookEventHandler struct {
+	migrate.NoopEventHandler
+
+	url     string
+	client  *http.Client
+	onError func(err error)
+
+	// Events don't identify their run, so these are the versions applied by the current run.
+	mu       sync.Mutex
+	versions []int
+}
+
+// NewWebhookEventHandler returns a migrate.EventHandler that POSTs a WebhookPayload as JSON to the
+// given URL after each successful run that applied at least one version, e.g. to notify a chat
+// channel or deploy tracker. If client is nil, http.DefaultClient is used. Delivery is best
+// effort: it's given a few seconds, and failures are reported to the function given with
+// WithWebhookErrorHandler rather than failing the migration, as it has already been committed.
+// Other events are ignored; wrap the handler to handle them too. The handler collects the versions
+// applied by a run from its events, so it mustn't be shared by runs that may execute concurrently,
+// e.g. in different goroutines; create one per run instead.
+func NewWebhookEventHandler(url string, client *http.Client, opts ...WebhookOption) migrate.EventHandler {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	h := &webhookEventHandler{
+		url:    url,
+		client: client,
+	}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	return h
+}
+
+// AfterVersionMigrate records the applied version.
+func (h *webhookEventHandler) AfterVersionMigrate(version int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.versions = append(h.versions, version)
+}
+
+// OnExecuteError forgets the versions recorded so far, as the run failed.
+func (h *webhookEventHandler) OnExecuteError(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.versions = nil
+}
+
+// AfterExecute delivers the webhook, if any versions were applied.
+func (h *webhookEventHandler) AfterExecute(info migrate.DriverInfo) {
+	h.mu.Lock()
+	versions := h.versions
+	h.versions = nil
+	h.mu.Unlock()
+
+	if len(versions) == 0 {
+		return
+	}
+
+	payload := WebhookPayload{
+		Versions: versions,
+		Dialect:  info.Dialect,
+		Host:     info.Host,
+		Database: info.Database,
+		Schema:   info.Schema,
+		Table:    info.Table,
+		SentAt:   time.Now().UTC(),
+	}
+
+	err := h.deliver(payload)
+	if err != nil && h.onError != nil {
+		h.onError(fmt.Errorf("failed to deliver webhook: %w", err))
+	}
+}
+
+// deliver POSTs the given payload to the webhook URL.
+func (h *webhookEventHandler) deliver(payload WebhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode payload: %w", err)
+	}
+
+	ctx, cfn := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cfn()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	return nil
+}
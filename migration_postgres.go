@@ -0,0 +1,38 @@
+package migrate
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// concurrentIndexPattern matches a Postgres CREATE INDEX CONCURRENTLY statement, capturing the
+// name of the index, and the schema of the table it's created on, if one is given.
+var concurrentIndexPattern = regexp.MustCompile(
+	`(?is)^\s*CREATE\s+(?:UNIQUE\s+)?INDEX\s+CONCURRENTLY\s+(?:IF\s+NOT\s+EXISTS\s+)?("[^"]+"|\w+)\s+ON\s+(?:ONLY\s+)?(?:("[^"]+"|\w+)\.)?(?:"[^"]+"|\w+)`,
+)
+
+// NewConcurrentIndexMigration returns a new Migration that creates a Postgres index using the
+// given CREATE INDEX CONCURRENTLY statement. The statement is executed outside of the transaction,
+// as Postgres requires. If it fails, Postgres leaves an invalid index behind, which would make
+// retrying the migration fail too, so the migration drops the index again as part of its Cleanup.
+// Clear Cleanup on the returned Migration to leave the invalid index in place for inspection
+// instead. This panics if indexSQL isn't a CREATE INDEX CONCURRENTLY statement.
+func NewConcurrentIndexMigration(version int, indexSQL string) Migration {
+	matches := concurrentIndexPattern.FindStringSubmatch(indexSQL)
+	if matches == nil {
+		panic(fmt.Sprintf("migrate: version %d: not a CREATE INDEX CONCURRENTLY statement", version))
+	}
+
+	// Indexes are always created in the same schema as their table.
+	index := matches[1]
+	if schema := matches[2]; schema != "" {
+		index = schema + "." + index
+	}
+
+	return Migration{
+		Version:       version,
+		Commands:      []string{indexSQL},
+		NoTransaction: true,
+		Cleanup:       []string{fmt.Sprintf("DROP INDEX CONCURRENTLY IF EXISTS %s", index)},
+	}
+}
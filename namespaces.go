@@ -0,0 +1,171 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var (
+	// ErrNamespaceDependency is returned by ExecuteAll when the namespaces' dependencies can't be
+	// satisfied, i.e. a dependency isn't being executed, or dependencies form a cycle.
+	ErrNamespaceDependency = errors.New("migrate: namespace dependency can't be satisfied")
+	// ErrNamespaceScopeRequired is returned by ExecuteAll when neither WithSharedVersionsTable nor
+	// WithTableNameFunc is given, as every namespace would then use the same versions, and the
+	// versions of one would be skipped as already applied by another.
+	ErrNamespaceScopeRequired = errors.New("migrate: namespaces must not share versions")
+	// ErrNamespaceRegistered is returned by UseSequences when migrations have already been
+	// registered in the namespace, as they were registered without sequences.
+	ErrNamespaceRegistered = errors.New("migrate: namespace already has migrations registered")
+)
+
+// SequenceLimit is the number of sequences each version has in a namespace that uses sequences.
+// See UseSequences.
+const SequenceLimit = 1000
+
+// DependsOn declares that the given namespace's migrations must be applied after those of each
+// of the given dependencies, e.g. DependsOn("billing", "users") if billing's tables reference
+// users'. It's only used to order namespaces in ExecuteAll.
+func DependsOn(namespace string, dependencies ...string) {
+	defaultRegistry.DependsOn(namespace, dependencies...)
+}
+
+// DependsOn is like the package-level DependsOn, but only applies to this Registry.
+func (r *Registry) DependsOn(namespace string, dependencies ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.dependencies[namespace] = append(r.dependencies[namespace], dependencies...)
+}
+
+// UseSequences makes the given namespace order migrations by their Version, and then their
+// Sequence, so that migrations with the same Version (e.g. timestamps of migrations generated
+// within the same second) are applied in an order their author controls. Only migrations with the
+// same Version and Sequence are duplicates. Each migration is recorded, and reported, as the
+// version Version*SequenceLimit+Sequence. It must be called before any migrations are registered
+// in the namespace, otherwise an error wrapping ErrNamespaceRegistered is returned. A namespace
+// with versions already applied can adopt sequences by rewriting them with ApplyCompaction,
+// mapping each version to itself times SequenceLimit.
+func UseSequences(namespace string) error {
+	return defaultRegistry.UseSequences(namespace)
+}
+
+// UseSequences is like the package-level UseSequences, but only applies to this Registry.
+func (r *Registry) UseSequences(namespace string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.migrations[namespace]) > 0 {
+		return fmt.Errorf("namespace %q: %w", namespace, ErrNamespaceRegistered)
+	}
+
+	r.sequenced[namespace] = true
+
+	return nil
+}
+
+// ExecuteAll applies all pending migrations in each of the given namespaces, or every registered
+// namespace if none are given, one namespace at a time, each in its own run (see ExecuteContext),
+// so that each holds the versions table lock while it's migrated. Namespaces are executed in the
+// given order (or in name order, if none are given), except that each namespace is executed after
+// the namespaces it depends on (see DependsOn), which must also be given. It stops at the first
+// namespace that fails. The options are used for every namespace, and must include either
+// WithSharedVersionsTable or WithTableNameFunc, so that each namespace has its own versions;
+// otherwise, ErrNamespaceScopeRequired is returned. A timeout given with WithTimeout applies to
+// each namespace's run.
+func ExecuteAll(ctx context.Context, driver Driver, events EventHandler, namespaces []string, opts ...Option) error {
+	return defaultRegistry.ExecuteAll(ctx, driver, events, namespaces, opts...)
+}
+
+// ExecuteAll is like the package-level ExecuteAll, but applies migrations from this Registry.
+func (r *Registry) ExecuteAll(ctx context.Context, driver Driver, events EventHandler, namespaces []string, opts ...Option) error {
+	o := newOptions(opts...)
+	if !o.sharedVersionsTable && o.tableNameFunc == nil {
+		return ErrNamespaceScopeRequired
+	}
+
+	order, err := r.executionOrder(namespaces)
+	if err != nil {
+		return err
+	}
+
+	for _, namespace := range order {
+		err = r.ExecuteContext(ctx, driver, events, namespace, o.timeout, opts...)
+		if err != nil {
+			return fmt.Errorf("namespace %q: %w", namespace, err)
+		}
+	}
+
+	return nil
+}
+
+// executionOrder returns the given namespaces (or every registered namespace, in name order, if
+// none are given) in the order ExecuteAll executes them: as given, except that each namespace
+// comes after its dependencies.
+func (r *Registry) executionOrder(namespaces []string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(namespaces) == 0 {
+		for namespace := range r.migrations {
+			namespaces = append(namespaces, namespace)
+		}
+
+		for namespace := range r.repeatables {
+			if _, ok := r.migrations[namespace]; !ok {
+				namespaces = append(namespaces, namespace)
+			}
+		}
+
+		sort.Strings(namespaces)
+	}
+
+	given := make(map[string]bool, len(namespaces))
+	for _, namespace := range namespaces {
+		given[namespace] = true
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+
+	state := make(map[string]int, len(namespaces))
+	order := make([]string, 0, len(namespaces))
+
+	var visit func(namespace string) error
+	visit = func(namespace string) error {
+		switch state[namespace] {
+		case visiting:
+			return fmt.Errorf("namespace %q is part of a dependency cycle: %w", namespace, ErrNamespaceDependency)
+		case visited:
+			return nil
+		}
+
+		state[namespace] = visiting
+
+		for _, dependency := range r.dependencies[namespace] {
+			if !given[dependency] {
+				return fmt.Errorf("namespace %q depends on %q, which isn't being executed: %w", namespace, dependency, ErrNamespaceDependency)
+			}
+
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+
+		state[namespace] = visited
+		order = append(order, namespace)
+
+		return nil
+	}
+
+	for _, namespace := range namespaces {
+		if err := visit(namespace); err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
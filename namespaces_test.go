@@ -0,0 +1,50 @@
+package migrate
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// deadlineDriver is a fakeDriver that uses a versions table per namespace, and records whether
+// each transaction was begun with a context deadline.
+type deadlineDriver struct {
+	*fakeDriver
+
+	table     string
+	deadlines map[string]bool
+}
+
+// ForTable ...
+func (d *deadlineDriver) ForTable(table string) (Driver, error) {
+	return &deadlineDriver{fakeDriver: &fakeDriver{db: &fakeDB{}}, table: table, deadlines: d.deadlines}, nil
+}
+
+// Begin ...
+func (d *deadlineDriver) Begin(ctx context.Context) error {
+	_, ok := ctx.Deadline()
+	d.deadlines[d.table] = ok
+
+	return d.fakeDriver.Begin(ctx)
+}
+
+func TestExecuteAllTimeout(t *testing.T) {
+	r := NewRegistry()
+	r.Register("a", NewMigration(1, "CREATE TABLE a"))
+	r.Register("b", NewMigration(1, "CREATE TABLE b"))
+
+	driver := &deadlineDriver{fakeDriver: newFakeDriver(), deadlines: make(map[string]bool)}
+
+	err := r.ExecuteAll(context.Background(), driver, NoopEventHandler{}, nil,
+		WithTableNameFunc(func(namespace string) string { return namespace }),
+		WithTimeout(time.Minute),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if expected := map[string]bool{"a": true, "b": true}; !reflect.DeepEqual(driver.deadlines, expected) {
+		t.Errorf("expected every namespace's run to have a deadline, got %v", driver.deadlines)
+	}
+}
@@ -0,0 +1,120 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// State is the state of a namespace observed by Observe.
+type State struct {
+	Namespace string
+	// Applied contains every recorded version, in ascending order.
+	Applied []int
+	// Pending contains the registered migrations that haven't been applied yet, in the order
+	// Execute would apply them. Empty migrations are omitted, as Execute skips them.
+	Pending []Migration
+}
+
+// Current returns the highest applied version, or 0 if none have been applied.
+func (s State) Current() int {
+	if len(s.Applied) == 0 {
+		return 0
+	}
+
+	return s.Applied[len(s.Applied)-1]
+}
+
+// Observe reads the current state of the given namespace without beginning a transaction (if the
+// driver implements ReadOnlyVersioner) or locking the versions table, so it's cheap, and can't
+// interfere with a run in progress. This is the read path used by the read-only helpers (e.g.
+// Plan), whereas Execute reads versions after locking the versions table. Both decide what's
+// pending in the same way. Another process may apply migrations at any time, so the state may be
+// out of date as soon as it's returned.
+func Observe(driver Driver, namespace string, ctx context.Context) (State, error) {
+	return defaultRegistry.Observe(driver, namespace, ctx)
+}
+
+// Observe is like the package-level Observe, but uses this Registry.
+func (r *Registry) Observe(driver Driver, namespace string, ctx context.Context) (State, error) {
+	applied, err := AppliedVersions(driver, ctx)
+	if err != nil {
+		return State{}, err
+	}
+
+	sort.Ints(applied)
+
+	migrationsByVersion := r.registered(namespace)
+
+	state := State{
+		Namespace: namespace,
+		Applied:   applied,
+	}
+
+	for _, version := range pendingVersions(migrationsByVersion, applied) {
+		if migration := migrationsByVersion[version]; !migration.isEmpty() {
+			state.Pending = append(state.Pending, migration)
+		}
+	}
+
+	return state, nil
+}
+
+// AppliedVersions returns the versions that have been applied, in no particular order, without
+// locking the versions table. If the driver implements ReadOnlyVersioner, no transaction is used
+// either. If the versions table doesn't exist yet, no versions have been applied, and it isn't
+// created.
+func AppliedVersions(driver Driver, ctx context.Context) ([]int, error) {
+	exists, err := driver.VersionTableExists(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check if versions table exists: %w", err)
+	}
+
+	if !exists {
+		return nil, nil
+	}
+
+	if reader, ok := driver.(ReadOnlyVersioner); ok {
+		versions, err := reader.VersionsReadOnly(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get current versions: %w", err)
+		}
+
+		return versions, nil
+	}
+
+	err = driver.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	// This transaction is only used for reading.
+	defer driver.Rollback(ctx)
+
+	versions, err := driver.Versions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current versions: %w", err)
+	}
+
+	return versions, nil
+}
+
+// pendingVersions returns the versions of the given migrations that aren't in applied, in the
+// order they'd be applied. Both Observe and Execute use this, so they can't disagree.
+func pendingVersions(migrationsByVersion Migrations, applied []int) []int {
+	existing := make(map[int]bool, len(applied))
+	for _, version := range applied {
+		existing[version] = true
+	}
+
+	var versions []int
+	for version := range migrationsByVersion {
+		if !existing[version] {
+			versions = append(versions, version)
+		}
+	}
+
+	sort.Ints(versions)
+
+	return versions
+}
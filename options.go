@@ -0,0 +1,569 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// failureDiagnosticsTimeout is how long the failure diagnostics function is given to run.
+const failureDiagnosticsTimeout = 30 * time.Second
+
+// serializationRetryBackoff is how long to wait before the first serialization failure retry. It
+// doubles for each retry after that.
+const serializationRetryBackoff = 100 * time.Millisecond
+
+// Option configures optional behaviour of Execute.
+type Option func(*options)
+
+// options holds the configuration built up from Option values.
+type options struct {
+	checksums         bool
+	checksumAlgo      ChecksumFunc
+	checksumNormalize NormalizeFunc
+	checksumTable     bool
+	checksumWarnOnly  bool
+
+	transactionPerMigration bool
+	noTransactions          bool
+	tamperDetection         bool
+	lockWait                time.Duration
+	lockScope               string
+	toolVersion             string
+	appVersion              string
+	descriptions            bool
+	savepoints              bool
+	continueOnError         bool
+	failed                  map[int]bool
+	notifyChannel           string
+	runLog                  bool
+	exclude                 map[int]bool
+	since                   *int64
+	target                  *int
+	baselineRange           *[2]int
+	tableNameFunc           func(namespace string) string
+	sharedVersionsTable     bool
+	checkpoints             bool
+	maxPending              int
+	overridePendingGuard    bool
+	serializationRetries    int
+	requireEmptyOnFirstRun  bool
+	strictOrdering          bool
+	refuseUnknownVersions   bool
+	dryRun                  io.Writer
+	events                  EventHandler
+	timeout                 time.Duration
+	defaultVersionTimeout   time.Duration
+	commandTimeout          time.Duration
+	stops                   []<-chan struct{}
+	stopSignals             []os.Signal
+	registry                *Registry
+
+	preExecuteGate     func(ctx context.Context) error
+	failureDiagnostics func(ctx context.Context, driver Driver, failedVersion int)
+	commitConfirmation func(result PendingResult) (bool, error)
+}
+
+// newOptions returns options with defaults applied, and then the given Option values.
+func newOptions(opts ...Option) *options {
+	o := &options{
+		checksumAlgo:      ChecksumSHA256,
+		checksumNormalize: NormalizeExact,
+		events:            NoopEventHandler{},
+		registry:          defaultRegistry,
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// WithRegistry sets the Registry that migrations are read from, instead of the default one used
+// by the package-level Register functions.
+func WithRegistry(r *Registry) Option {
+	return func(o *options) {
+		o.registry = r
+	}
+}
+
+// WithEvents sets the EventHandler used by Run, and by any other function given a nil
+// EventHandler, e.g. Execute or Rollback. The default ignores every event.
+func WithEvents(events EventHandler) Option {
+	return func(o *options) {
+		o.events = events
+	}
+}
+
+// WithTimeout sets the timeout used by Run, and by Execute and its variants when they're given a
+// zero timeout. It also bounds any other function given it, e.g. Baseline or Rollback, and each
+// namespace's run in ExecuteAll. The default is no timeout, other than the context's.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// WithGracefulStop stops the run before the next version once stop is closed, rather than part way
+// through a version, as cancelling the context would. The versions applied in the current
+// transaction are rolled back, and an error wrapping ErrStopped is returned. Only committed
+// versions stay applied, so this is most useful with WithTransactionPerMigration, and they're
+// listed in ExecuteResult's Result.Applied.
+func WithGracefulStop(stop <-chan struct{}) Option {
+	return func(o *options) {
+		o.stops = append(o.stops, stop)
+	}
+}
+
+// WithStopOnSignal is WithGracefulStop, stopping once any of the given signals is received, or
+// SIGTERM or an interrupt if none are given, e.g. so a pod being stopped by Kubernetes doesn't
+// leave a version half applied. The signals are only caught while Execute is running, so a second
+// signal after it returns isn't ignored. The context still bounds how long stopping may take.
+func WithStopOnSignal(signals ...os.Signal) Option {
+	return func(o *options) {
+		o.stopSignals = signals
+		if len(o.stopSignals) == 0 {
+			o.stopSignals = defaultStopSignals
+		}
+	}
+}
+
+// WithVersionTimeout bounds how long applying each version may take, within the overall timeout,
+// so that one slow migration fails on its own rather than using up the time of those after it.
+// A migration's own Timeout overrides it. If a version times out, the returned MigrationError
+// wraps ErrMigrationTimeout.
+func WithVersionTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.defaultVersionTimeout = timeout
+	}
+}
+
+// WithCommandTimeout bounds how long each command may take, within the version's timeout. If a
+// command times out, the returned MigrationError wraps ErrMigrationTimeout, and names the command.
+func WithCommandTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.commandTimeout = timeout
+	}
+}
+
+// WithChecksum enables storing a checksum of each migration's commands as it's applied, and
+// verifying that already applied migrations haven't changed since. The algorithm and the
+// normalization applied to each command beforehand are both pluggable; if either is nil, the
+// default is used (SHA-256 over the exact command bytes). The driver must implement ChecksumDriver.
+func WithChecksum(algo func([]string) string, normalize func(string) string) Option {
+	return func(o *options) {
+		o.checksums = true
+
+		if algo != nil {
+			o.checksumAlgo = algo
+		}
+
+		if normalize != nil {
+			o.checksumNormalize = normalize
+		}
+	}
+}
+
+// WithChecksumTable stores checksums in a separate checksum table, created if it doesn't exist,
+// instead of adding a column to the versions table, for environments where the versions table
+// can't be altered. It enables checksums, using the defaults unless WithChecksum is also given.
+// The driver must implement ChecksumTableDriver.
+func WithChecksumTable() Option {
+	return func(o *options) {
+		o.checksums = true
+		o.checksumTable = true
+	}
+}
+
+// WithChecksumWarnOnly reports applied migrations whose checksums don't match through the
+// OnChecksumMismatch event, and carries on, instead of failing the run. This is for adopting
+// checksums where old migrations are known to have been edited. It enables checksums, using the
+// defaults unless WithChecksum is also given.
+func WithChecksumWarnOnly() Option {
+	return func(o *options) {
+		o.checksums = true
+		o.checksumWarnOnly = true
+	}
+}
+
+// WithTransactionPerMigration applies each migration in its own transaction, instead of applying
+// every pending migration in one transaction. This keeps transactions (and the locks they hold)
+// short, at the cost of a failure leaving earlier migrations applied. Migrations sharing a
+// ReleaseID are still applied together. If the driver implements MetadataDriver, a run that was
+// interrupted part way through is detected by the next run, which fires OnResume, after checking
+// that nothing was left in an inconsistent state if the driver implements InconsistencyDetector.
+func WithTransactionPerMigration() Option {
+	return func(o *options) {
+		o.transactionPerMigration = true
+	}
+}
+
+// WithTamperDetection records a signature of the versions table at the end of each run, and
+// verifies it at the start of the next, returning ErrVersionTableTampered if versions were added
+// or removed by something other than migrate in between. The driver must implement MetadataDriver.
+func WithTamperDetection() Option {
+	return func(o *options) {
+		o.tamperDetection = true
+	}
+}
+
+// WithLockWait limits how long Execute keeps retrying to acquire the versions table lock while
+// it's held by another process. By default, it keeps retrying until the Execute timeout. Errors
+// other than contention are never retried. The driver must implement LockContentionClassifier for
+// contention to be retried at all.
+func WithLockWait(d time.Duration) Option {
+	return func(o *options) {
+		o.lockWait = d
+	}
+}
+
+// WithLockScope locks only the given scope instead of the whole versions table, so that runs for
+// namespaces whose migrations touch unrelated tables can proceed concurrently, rather than queuing
+// behind a single lock. Runs sharing a scope still serialize. An empty scope keeps the default
+// single lock. The driver must implement ScopedLocker. It, and WithLockWait, can also be given to
+// the functions that change the versions table outside of Execute, e.g. Baseline and Rollback.
+func WithLockScope(scope string) Option {
+	return func(o *options) {
+		o.lockScope = scope
+	}
+}
+
+// WithFailureDiagnostics registers a function that's called when Execute fails, before the
+// transaction is rolled back, so that diagnostic information can be captured while the failure
+// is still in progress (e.g. querying pg_stat_activity for blocking locks). The failed version is
+// -1 if the failure didn't happen while applying a version. The transaction may already have been
+// aborted by the failure, so diagnostic queries should use their own connection. The given
+// context is independent of the Execute timeout, which may be why the run failed.
+func WithFailureDiagnostics(fn func(ctx context.Context, driver Driver, failedVersion int)) Option {
+	return func(o *options) {
+		o.failureDiagnostics = fn
+	}
+}
+
+// diagnose calls the failure diagnostics function, with its own timeout.
+func (o *options) diagnose(driver Driver, failedVersion int) {
+	ctx, cfn := context.WithTimeout(context.Background(), failureDiagnosticsTimeout)
+	defer cfn()
+
+	o.failureDiagnostics(ctx, driver, failedVersion)
+}
+
+// LogInconsistentObjects is a failure diagnostics function, for use with WithFailureDiagnostics,
+// that logs any schema objects left in an inconsistent state by the failure, so that operators
+// know what needs cleaning up. The driver must implement InconsistencyDetector, otherwise nothing
+// is logged.
+func LogInconsistentObjects(ctx context.Context, driver Driver, failedVersion int) {
+	detector, ok := driver.(InconsistencyDetector)
+	if !ok {
+		return
+	}
+
+	objects, err := detector.DetectInconsistentObjects(ctx)
+	if err != nil {
+		log.Printf("migrate: failed to detect inconsistent objects after version %d failed: %v", failedVersion, err)
+		return
+	}
+
+	for _, object := range objects {
+		log.Printf("migrate: inconsistent object after version %d failed: %s", failedVersion, object)
+	}
+}
+
+// WithToolVersion records the given tool version against each version applied, to help diagnose
+// changes in behaviour across upgrades. If toolVersion is empty, the version of this library is
+// used, as recorded in the binary's build information. The driver must implement
+// ToolVersionDriver.
+func WithToolVersion(toolVersion string) Option {
+	return func(o *options) {
+		o.toolVersion = toolVersion
+		if o.toolVersion == "" {
+			o.toolVersion = libraryVersion()
+		}
+	}
+}
+
+// WithContinueOnError carries on applying the remaining pending versions after one fails, rather
+// than stopping, for namespaces whose versions are unrelated (e.g. large backfills). Each failed
+// version is rolled back on its own, so it implies WithTransactionPerMigration, and the versions
+// are listed in Result.Failed. Once every other version has been applied, an ExecuteErrors is
+// returned, containing an error for each. Versions that require a failed version still stop the
+// run, as they can't be applied.
+func WithContinueOnError() Option {
+	return func(o *options) {
+		o.transactionPerMigration = true
+		o.continueOnError = true
+		o.failed = make(map[int]bool)
+	}
+}
+
+// WithSavepoints applies each version within a savepoint, so that if one fails, only it is rolled
+// back, and the versions applied before it in the same transaction are committed before the error
+// (which names the failed version) is returned, rather than being discarded too. If that commit
+// fails, an OnRollbackError event is fired and its error is included in the one returned. It has
+// no effect with WithCommitConfirmation, as nothing is committed without confirmation. The driver
+// must implement SavepointDriver.
+func WithSavepoints() Option {
+	return func(o *options) {
+		o.savepoints = true
+	}
+}
+
+// WithDescriptions stores each migration's Name in a description column of the versions table, as
+// it's applied, so the history is readable without the code. The driver must implement
+// DescriptionDriver.
+func WithDescriptions() Option {
+	return func(o *options) {
+		o.descriptions = true
+	}
+}
+
+// WithCommitConfirmation calls fn after all pending migrations have been applied, but before the
+// transaction is committed, with a summary of what ran. If fn returns false, the transaction is
+// rolled back and ErrCommitNotConfirmed is returned, so an operator can take a last look before
+// the changes are made permanent. Every pending migration must be applied in one transaction, so
+// this can't be combined with WithTransactionPerMigration or NoTransaction migrations.
+func WithCommitConfirmation(fn func(result PendingResult) (bool, error)) Option {
+	return func(o *options) {
+		o.commitConfirmation = fn
+	}
+}
+
+// WithExclude skips the given versions, neither applying nor recording them, so that they remain
+// pending and are applied by a later run without this option. This is an escape hatch for when
+// one pending migration is broken, but the rest need to be applied in the meantime. Migrations
+// that require an excluded version will fail.
+func WithExclude(versions ...int) Option {
+	return func(o *options) {
+		if o.exclude == nil {
+			o.exclude = make(map[int]bool, len(versions))
+		}
+
+		for _, version := range versions {
+			o.exclude[version] = true
+		}
+	}
+}
+
+// WithSince only applies pending versions greater than the given version, e.g. only migrations
+// authored since the last release cut, when using timestamp versions. Pending versions up to and
+// including it are left pending, and will be applied by a later run without this option, after
+// (i.e. out of order with) the newer versions applied by this run.
+func WithSince(version int64) Option {
+	return func(o *options) {
+		o.since = &version
+	}
+}
+
+// WithTargetVersion only applies pending versions up to and including the given version, so that
+// risky changes can be staged, and applied incrementally. Later versions are left pending.
+func WithTargetVersion(version int) Option {
+	return func(o *options) {
+		o.target = &version
+	}
+}
+
+// leavePending returns true if the given pending version is to be left pending by this run,
+// because of WithSince or WithTargetVersion.
+func (o *options) leavePending(version int) bool {
+	return (o.since != nil && int64(version) <= *o.since) || (o.target != nil && version > *o.target)
+}
+
+// WithStrictOrdering refuses to apply anything if a pending version is lower than the highest
+// applied version (e.g. a migration backfilled on a long-lived branch), returning an error wrapping
+// ErrOutOfOrder that names them. Without it, such versions are applied, and reported by the
+// OnVersionsOutOfOrder event. Versions left pending by other options aren't checked.
+func WithStrictOrdering() Option {
+	return func(o *options) {
+		o.strictOrdering = true
+	}
+}
+
+// outOfOrder returns the given pending versions that are lower than the highest applied version,
+// other than excluded versions, which aren't going to be applied.
+func (o *options) outOfOrder(pending, applied []int) []int {
+	var highest int
+	for _, version := range applied {
+		if version > highest {
+			highest = version
+		}
+	}
+
+	var versions []int
+	for _, version := range pending {
+		if version < highest && !o.exclude[version] {
+			versions = append(versions, version)
+		}
+	}
+
+	return versions
+}
+
+// WithRefuseUnknownVersions refuses to apply anything if any applied version isn't registered,
+// returning an error wrapping ErrUnknownVersions that names them. This means a newer deployment
+// has already migrated further than this binary knows about, e.g. during a rolling deployment or
+// a rollback of the code, and it's not safe for this binary to change the schema.
+func WithRefuseUnknownVersions() Option {
+	return func(o *options) {
+		o.refuseUnknownVersions = true
+	}
+}
+
+// WithTableNameFunc uses the versions table named by fn for the namespace being migrated (e.g.
+// "<namespace>_versions") instead of the one the driver was constructed with, so that one driver
+// can serve many namespaces with isolated versions tables. The driver must implement TableNamer.
+func WithTableNameFunc(fn func(namespace string) string) Option {
+	return func(o *options) {
+		o.tableNameFunc = fn
+	}
+}
+
+// WithCommandCheckpoints records each command of a NoTransaction migration as it completes, so
+// that if the migration fails part way through, the next run resumes it from the failed command
+// instead of executing its completed commands again. The checkpoint is discarded if the
+// migration's commands have changed since. The driver must implement CheckpointDriver.
+func WithCommandCheckpoints() Option {
+	return func(o *options) {
+		o.checkpoints = true
+	}
+}
+
+// WithSharedVersionsTable stores the versions of every namespace in the driver's one versions
+// table, with a namespace column, instead of needing a table per namespace. The driver must
+// implement NamespaceScoper. An existing versions table is upgraded with the namespace column,
+// and the versions already in it are given the empty namespace; use RenameNamespace to move them
+// to the namespace they belong to. Other functions, e.g. Observe and Rollback, see the whole
+// table, so pass them the driver returned by ForNamespace instead.
+func WithSharedVersionsTable() Option {
+	return func(o *options) {
+		o.sharedVersionsTable = true
+	}
+}
+
+// WithBaselineRange records pending versions from from to to (inclusive) as applied, without
+// executing them, and then applies the versions after them as normal, in the same run. This is for
+// adopting a new database (e.g. when moving from MySQL to Postgres) whose schema was created some
+// other way, where the historical migrations can't be run. Unlike Baseline, the range's versions
+// are recorded with checksums and the tool version, if they're enabled.
+func WithBaselineRange(from, to int) Option {
+	return func(o *options) {
+		o.baselineRange = &[2]int{from, to}
+	}
+}
+
+// WithMaxPendingGuard refuses to apply anything if more than n versions are pending, returning
+// ErrTooManyPending, to catch e.g. a bad merge bringing a pile of unrelated migrations into a
+// deploy. Excluded versions aren't counted. Zero or less means no limit, which is the default.
+// See WithPendingGuardOverride.
+func WithMaxPendingGuard(n int) Option {
+	return func(o *options) {
+		o.maxPending = n
+	}
+}
+
+// WithPendingGuardOverride disables the WithMaxPendingGuard check, for when a large number of
+// pending versions is expected and has been confirmed.
+func WithPendingGuardOverride() Option {
+	return func(o *options) {
+		o.overridePendingGuard = true
+	}
+}
+
+// WithPreExecuteGate calls gate before anything touches the database, i.e. before the versions
+// table is checked, any transaction is begun, or any lock is taken. If it returns an error, the
+// run is aborted with that error, without side effects. This is for enforcing policies such as
+// only migrating during a maintenance window, or only when a deploy flag is set. The gate isn't
+// called if no migrations are registered in the namespace.
+func WithPreExecuteGate(gate func(ctx context.Context) error) Option {
+	return func(o *options) {
+		o.preExecuteGate = gate
+	}
+}
+
+// WithSerializationRetry retries the whole run up to n times if it fails with a serialization
+// failure or deadlock (ErrorClassSerialization), e.g. because a data migration running under
+// SERIALIZABLE isolation conflicted with live traffic, waiting a little longer before each retry.
+// An OnSerializationRetry event is sent before each retry, and OnExecuteError is sent for each
+// failed attempt. Migrations committed before the failure (e.g. when using
+// WithTransactionPerMigration) aren't applied again. The driver must implement ErrorClassifier,
+// otherwise failures are never retried.
+func WithSerializationRetry(n int) Option {
+	return func(o *options) {
+		o.serializationRetries = n
+	}
+}
+
+// WithRequireEmptyOnFirstRun refuses to create the versions table, returning ErrSchemaNotEmpty,
+// if the schema already contains other tables, to guard against running a fresh install's
+// migrations against the wrong, already populated, database. It has no effect once the versions
+// table exists. The driver must implement EmptySchemaChecker.
+func WithRequireEmptyOnFirstRun() Option {
+	return func(o *options) {
+		o.requireEmptyOnFirstRun = true
+	}
+}
+
+// WithDryRun makes Execute write the SQL that would be executed to w, instead of executing it,
+// without changing anything in the database. Pending versions are determined without locking the
+// versions table, so another process may apply some of them before a real run.
+func WithDryRun(w io.Writer) Option {
+	return func(o *options) {
+		o.dryRun = w
+	}
+}
+
+// WithRunLog records one entry per run in an append-only run log table, with a unique run ID, when
+// it started and finished, the namespace, the versions committed, the outcome, who ran it, on which
+// host, and the application's version (see WithAppVersion). Successful runs are recorded in the
+// same transaction as the migrations, so the entry is committed if and only if they are. Failed
+// runs are recorded in a transaction of their own after rolling back. Use RunLog to read it back.
+// The driver must implement RunLogDriver.
+func WithRunLog() Option {
+	return func(o *options) {
+		o.runLog = true
+	}
+}
+
+// WithAppVersion records the given version of the application in the run log, so that runs can be
+// traced back to a deployment. If version is empty, the main module's version is used, as recorded
+// in the binary's build information.
+func WithAppVersion(version string) Option {
+	return func(o *options) {
+		o.appVersion = version
+		if o.appVersion == "" {
+			o.appVersion = appVersion()
+		}
+	}
+}
+
+// WithPostCommitNotify sends a notification on the given channel after a run that applied at
+// least one migration has committed, with a payload of "<namespace>:<max applied version>", so
+// that other services can react to schema changes without polling the versions table. When using
+// WithTransactionPerMigration, it's only sent if the whole run succeeds. Sending is best effort,
+// as the migrations are already committed, so failures are reported through the OnPostCommitError
+// event, for the max applied version, rather than returned. The driver must implement Notifier.
+func WithPostCommitNotify(channel string) Option {
+	return func(o *options) {
+		o.notifyChannel = channel
+	}
+}
+
+// notify sends the post-commit notification for the given applied versions.
+func (o *options) notify(ctx context.Context, notifier Notifier, events EventHandler, namespace string, applied []int) {
+	max := applied[0]
+	for _, version := range applied {
+		if version > max {
+			max = version
+		}
+	}
+
+	payload := fmt.Sprintf("%s:%d", namespace, max)
+
+	err := notifier.Notify(ctx, o.notifyChannel, payload)
+	if err != nil {
+		events.OnPostCommitError(max, fmt.Errorf("failed to send post-commit notification on %q: %w", o.notifyChannel, err))
+	}
+}
@@ -0,0 +1,80 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEventsAndTimeoutOptions(t *testing.T) {
+	r := NewRegistry()
+	r.Register("default", NewMigration(1, "CREATE TABLE a"))
+	r.Register("default", NewMigration(2, "CREATE TABLE b"))
+
+	driver := &deadlineDriver{fakeDriver: newFakeDriver(), deadlines: make(map[string]bool)}
+	handler, events := ChannelEventHandler()
+
+	err := r.ExecuteContext(context.Background(), driver, nil, "default", 0, WithEvents(handler), WithTimeout(time.Minute))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if kinds := eventKinds(events); !hasEvent(kinds, EventAfterExecute) {
+		t.Errorf("expected events to be sent to the WithEvents handler, got %v", kinds)
+	}
+
+	if !driver.deadlines[""] {
+		t.Errorf("expected Execute to use the WithTimeout timeout")
+	}
+
+	delete(driver.deadlines, "")
+
+	err = r.Baseline(driver, "default", 2, context.Background(), WithTimeout(time.Minute))
+	if err != nil {
+		t.Fatalf("unexpected error baselining: %v", err)
+	}
+
+	if !driver.deadlines[""] {
+		t.Errorf("expected Baseline to use the WithTimeout timeout")
+	}
+}
+
+// failingNotifierDriver is a fakeDriver whose notifications always fail.
+type failingNotifierDriver struct {
+	*fakeDriver
+}
+
+func (d failingNotifierDriver) Notify(ctx context.Context, channel, payload string) error {
+	return errors.New("notify failed")
+}
+
+func TestPostCommitNotifyFailure(t *testing.T) {
+	r := NewRegistry()
+	r.Register("default", NewMigration(1, "CREATE TABLE a"))
+	r.Register("default", NewMigration(2, "CREATE TABLE b"))
+
+	handler, events := ChannelEventHandler()
+
+	err := r.ExecuteContext(context.Background(), failingNotifierDriver{newFakeDriver()}, handler, "default", 0, WithPostCommitNotify("migrations"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for {
+		select {
+		case event := <-events:
+			if event.Kind != EventPostCommitError {
+				continue
+			}
+
+			if event.Version != 2 || event.Err == nil {
+				t.Errorf("expected a PostCommitError for version 2 with an error, got %+v", event)
+			}
+
+			return
+		default:
+			t.Fatalf("expected a PostCommitError event for the failed notification")
+		}
+	}
+}
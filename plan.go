@@ -0,0 +1,167 @@
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// ReportFormatJSON is the PlanReport format for a JSON report.
+	ReportFormatJSON = "json"
+	// ReportFormatMarkdown is the PlanReport format for a Markdown report, e.g. for a PR comment.
+	ReportFormatMarkdown = "markdown"
+)
+
+// defaultReportTruncate is the default maximum length of each command in a PlanReport.
+const defaultReportTruncate = 1000
+
+// Plan returns the migrations registered in the given namespace that haven't been applied yet, in
+// the order Execute would apply them. Nothing is executed, and the versions table isn't locked,
+// so another process may apply some of these before Execute gets to run.
+func Plan(driver Driver, namespace string, ctx context.Context) ([]Migration, error) {
+	state, err := Observe(driver, namespace, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return state.Pending, nil
+}
+
+// PlanHash returns a deterministic hash of the pending migrations in the given namespace, i.e.
+// Plan, covering each pending version, in order, and its commands. Any change to which versions
+// are pending, or to their commands, changes the hash, so it can be computed when a deploy is
+// approved and checked again before applying it. Migrations with a streamed Source or a Func only
+// have their commands hashed, as reading the source would consume it, and a function can't be hashed.
+func PlanHash(driver Driver, namespace string, ctx context.Context) (string, error) {
+	plan, err := Plan(driver, namespace, ctx)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	for _, migration := range plan {
+		fmt.Fprintf(h, "%d:%s\n", migration.Version, ChecksumSHA256(migration.Commands))
+	}
+
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// PendingReport is the structure of a JSON plan report.
+type PendingReport struct {
+	Namespace string                 `json:"namespace"`
+	Pending   []PendingReportVersion `json:"pending"`
+}
+
+// PendingReportVersion describes a single pending version within a PendingReport.
+type PendingReportVersion struct {
+	Version   int      `json:"version"`
+	Commands  []string `json:"commands"`
+	Truncated bool     `json:"truncated"`
+}
+
+// ReportOption configures optional behaviour of PlanReport.
+type ReportOption func(*reportOptions)
+
+// reportOptions holds the configuration built up from ReportOption values.
+type reportOptions struct {
+	truncate int
+}
+
+// WithReportTruncate sets the maximum length of each command included in a plan report. Longer
+// commands are cut short, and marked as truncated. Zero or less disables truncation.
+func WithReportTruncate(n int) ReportOption {
+	return func(o *reportOptions) {
+		o.truncate = n
+	}
+}
+
+// PlanReport writes a report of the pending migrations in the given namespace to w, in the
+// given format (ReportFormatJSON or ReportFormatMarkdown). It's Plan, with a stable output format
+// suitable for posting as a PR comment, or for processing in CI.
+func PlanReport(driver Driver, namespace string, ctx context.Context, w io.Writer, format string, opts ...ReportOption) error {
+	o := reportOptions{truncate: defaultReportTruncate}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if format != ReportFormatJSON && format != ReportFormatMarkdown {
+		return fmt.Errorf("migrate: unknown report format: %q", format)
+	}
+
+	plan, err := Plan(driver, namespace, ctx)
+	if err != nil {
+		return err
+	}
+
+	report := PendingReport{
+		Namespace: namespace,
+		Pending:   []PendingReportVersion{},
+	}
+
+	for _, migration := range plan {
+		rv := PendingReportVersion{Version: migration.Version}
+
+		for _, command := range migration.Commands {
+			command = strings.TrimSpace(command)
+			if o.truncate > 0 && len(command) > o.truncate {
+				command = command[:o.truncate]
+				rv.Truncated = true
+			}
+
+			rv.Commands = append(rv.Commands, command)
+		}
+
+		report.Pending = append(report.Pending, rv)
+	}
+
+	if format == ReportFormatJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		err = enc.Encode(report)
+		if err != nil {
+			return fmt.Errorf("failed to encode report: %w", err)
+		}
+
+		return nil
+	}
+
+	return writeMarkdownReport(w, report)
+}
+
+// writeMarkdownReport writes the given report to w as Markdown.
+func writeMarkdownReport(w io.Writer, report PendingReport) error {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "### Pending migrations: `%s`\n\n", report.Namespace)
+
+	if len(report.Pending) == 0 {
+		sb.WriteString("No pending migrations.\n")
+	} else {
+		fmt.Fprintf(&sb, "%d pending version(s).\n", len(report.Pending))
+	}
+
+	for _, rv := range report.Pending {
+		fmt.Fprintf(&sb, "\n#### Version %d\n", rv.Version)
+
+		for _, command := range rv.Commands {
+			fmt.Fprintf(&sb, "\n```sql\n%s\n```\n", command)
+		}
+
+		if rv.Truncated {
+			sb.WriteString("\n_Some commands were truncated._\n")
+		}
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	if err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,373 @@
+// Package postgres provides a migrate.Driver for Postgres, using database/sql, for use with any
+// database/sql Postgres driver (e.g. lib/pq, or pgx's stdlib package) without depending on pgx.
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/seeruk/go-migrate"
+)
+
+// maxIdentifierLen is the maximum length of an identifier in Postgres.
+const maxIdentifierLen = 63
+
+var _ migrate.Driver = (*Driver)(nil)
+
+// Driver is a migrate.Driver for Postgres, using database/sql.
+type Driver struct {
+	db     *sql.DB
+	tx     *sql.Tx
+	schema string
+	table  string
+}
+
+// NewDriver returns a new Driver instance. The schema and table names are used in queries, so an
+// error is returned if either isn't a safe identifier.
+func NewDriver(db *sql.DB, schema, table string) (*Driver, error) {
+	for _, name := range []string{schema, table} {
+		if err := migrate.ValidateIdentifier(name, maxIdentifierLen); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Driver{
+		db:     db,
+		schema: schema,
+		table:  table,
+	}, nil
+}
+
+// tableName returns the quoted, schema-qualified name of the versions table.
+func (d *Driver) tableName() string {
+	return quote(d.schema) + "." + quote(d.table)
+}
+
+// quote quotes the given identifier, so that reserved words (e.g. "order") can be used. It's
+// lowercased first, as Postgres folds unquoted identifiers to lowercase, so that quoting doesn't
+// change which table is used. Identifiers are validated, so never contain quotes themselves.
+func quote(identifier string) string {
+	return `"` + strings.ToLower(identifier) + `"`
+}
+
+// Begin ...
+func (d *Driver) Begin(ctx context.Context) error {
+	if d.tx != nil {
+		return migrate.ErrTransactionAlreadyStarted
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+
+	d.tx = tx
+	return nil
+}
+
+// Commit ...
+func (d *Driver) Commit(_ context.Context) error {
+	if d.tx == nil {
+		return migrate.ErrTransactionNotStarted
+	}
+
+	defer func() { d.tx = nil }()
+
+	err := d.tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// Rollback ...
+func (d *Driver) Rollback(_ context.Context) error {
+	if d.tx == nil {
+		return migrate.ErrTransactionNotStarted
+	}
+
+	defer func() { d.tx = nil }()
+
+	err := d.tx.Rollback()
+	if err != nil {
+		return fmt.Errorf("failed to rollback transaction: %w", err)
+	}
+
+	return nil
+}
+
+// Savepoint ...
+func (d *Driver) Savepoint(ctx context.Context, name string) error {
+	return d.execSavepoint(ctx, "SAVEPOINT "+name)
+}
+
+// RollbackToSavepoint ...
+func (d *Driver) RollbackToSavepoint(ctx context.Context, name string) error {
+	return d.execSavepoint(ctx, "ROLLBACK TO SAVEPOINT "+name)
+}
+
+// ReleaseSavepoint ...
+func (d *Driver) ReleaseSavepoint(ctx context.Context, name string) error {
+	return d.execSavepoint(ctx, "RELEASE SAVEPOINT "+name)
+}
+
+// execSavepoint executes the given savepoint statement in the transaction.
+func (d *Driver) execSavepoint(ctx context.Context, query string) error {
+	if d.tx == nil {
+		return migrate.ErrTransactionNotStarted
+	}
+
+	_, err := d.tx.ExecContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to execute %q: %w", query, err)
+	}
+
+	return nil
+}
+
+// Lock ...
+func (d *Driver) Lock(ctx context.Context) error {
+	if d.tx == nil {
+		return migrate.ErrTransactionNotStarted
+	}
+
+	_, err := d.tx.ExecContext(ctx, fmt.Sprintf("LOCK TABLE %s IN ACCESS EXCLUSIVE MODE", d.tableName()))
+	if err != nil {
+		return fmt.Errorf("failed to lock versions table: %w", err)
+	}
+
+	return nil
+}
+
+// Exec ...
+func (d *Driver) Exec(ctx context.Context, command string) error {
+	_, err := d.ExecResult(ctx, command)
+	return err
+}
+
+// ExecResult ...
+func (d *Driver) ExecResult(ctx context.Context, command string) (int64, error) {
+	if d.tx == nil {
+		return 0, migrate.ErrTransactionNotStarted
+	}
+
+	res, err := d.tx.ExecContext(ctx, command)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute command: %w", err)
+	}
+
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected by command: %w", err)
+	}
+
+	return ra, nil
+}
+
+// ExecNoTransaction ...
+func (d *Driver) ExecNoTransaction(ctx context.Context, command string) error {
+	_, err := d.db.ExecContext(ctx, command)
+	if err != nil {
+		return fmt.Errorf("failed to execute command: %w", err)
+	}
+
+	return nil
+}
+
+// CreateVersionsTable ...
+func (d *Driver) CreateVersionsTable(ctx context.Context) error {
+	// We use IF NOT EXISTS here because we're not doing this part in a transaction or with any sort
+	// of lock. If the table already exists, then we can just skip creating it. Not every
+	// database/sql driver supports multiple statements in one query, so these are run separately.
+	queries := []string{
+		fmt.Sprintf(`CREATE SCHEMA IF NOT EXISTS %s`, quote(d.schema)),
+		fmt.Sprintf(`
+			CREATE TABLE IF NOT EXISTS %s (
+				version int NOT NULL,
+				migrated_at timestamp NOT NULL DEFAULT current_timestamp,
+
+				PRIMARY KEY (version)
+			)
+		`, d.tableName()),
+	}
+
+	for _, query := range queries {
+		_, err := d.db.ExecContext(ctx, query)
+		if err != nil {
+			return fmt.Errorf("failed to create versions table: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// InsertVersion ...
+func (d *Driver) InsertVersion(ctx context.Context, version int) error {
+	if d.tx == nil {
+		return migrate.ErrTransactionNotStarted
+	}
+
+	query := fmt.Sprintf(`INSERT INTO %s (version) VALUES ($1)`, d.tableName())
+
+	res, err := d.tx.ExecContext(ctx, query, version)
+	if err != nil {
+		return fmt.Errorf("failed to insert version: %w", err)
+	}
+
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected by version insert: %w", err)
+	}
+
+	if ra == 0 {
+		return errors.New("expected new version row to be inserted, but no rows affected")
+	}
+
+	return nil
+}
+
+// DeleteVersion ...
+func (d *Driver) DeleteVersion(ctx context.Context, version int) error {
+	if d.tx == nil {
+		return migrate.ErrTransactionNotStarted
+	}
+
+	query := fmt.Sprintf(`DELETE FROM %s WHERE version = $1`, d.tableName())
+
+	_, err := d.tx.ExecContext(ctx, query, version)
+	if err != nil {
+		return fmt.Errorf("failed to delete version: %w", err)
+	}
+
+	return nil
+}
+
+// Versions ...
+func (d *Driver) Versions(ctx context.Context) ([]int, error) {
+	if d.tx == nil {
+		return d.VersionsReadOnly(ctx)
+	}
+
+	return d.versions(ctx, d.tx.QueryContext)
+}
+
+// VersionsReadOnly returns the recorded versions using the connection pool, rather than the
+// transaction, so it doesn't need a transaction to have begun, and doesn't lock anything.
+func (d *Driver) VersionsReadOnly(ctx context.Context) ([]int, error) {
+	return d.versions(ctx, d.db.QueryContext)
+}
+
+// AppliedAt ...
+func (d *Driver) AppliedAt(ctx context.Context) (map[int]time.Time, error) {
+	query := fmt.Sprintf(`SELECT version, migrated_at FROM %s`, d.tableName())
+
+	rows, err := d.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query applied times: %w", err)
+	}
+
+	defer rows.Close()
+
+	appliedAt := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var migratedAt time.Time
+
+		err := rows.Scan(&version, &migratedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan applied time: %w", err)
+		}
+
+		appliedAt[version] = migratedAt
+	}
+
+	return appliedAt, rows.Err()
+}
+
+// versions returns the recorded versions, using the given query function.
+func (d *Driver) versions(ctx context.Context, queryFn func(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)) ([]int, error) {
+	query := fmt.Sprintf(`SELECT version FROM %s`, d.tableName())
+
+	rows, err := queryFn(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query current versions: %w", err)
+	}
+
+	defer rows.Close()
+
+	var versions []int
+	for rows.Next() {
+		var version int
+
+		err := rows.Scan(&version)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan current version: %w", err)
+		}
+
+		versions = append(versions, version)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query current versions: %w", err)
+	}
+
+	return versions, nil
+}
+
+// VersionTableExists ...
+func (d *Driver) VersionTableExists(ctx context.Context) (bool, error) {
+	var name sql.NullString
+
+	query := fmt.Sprintf(`SELECT to_regclass('%s')::text`, d.tableName())
+
+	err := d.db.QueryRowContext(ctx, query).Scan(&name)
+	if err != nil {
+		return false, fmt.Errorf("failed to check if version table exists: %w", err)
+	}
+
+	return name.Valid, nil
+}
+
+// Notify ...
+func (d *Driver) Notify(ctx context.Context, channel, payload string) error {
+	_, err := d.db.ExecContext(ctx, `SELECT pg_notify($1, $2)`, channel, payload)
+	if err != nil {
+		return fmt.Errorf("failed to notify: %w", err)
+	}
+
+	return nil
+}
+
+// Info returns the identity of the database the driver operates against.
+func (d *Driver) Info() migrate.DriverInfo {
+	return migrate.DriverInfo{
+		Dialect: migrate.DialectPostgres,
+		Schema:  d.schema,
+		Table:   d.table,
+	}
+}
+
+// IsSchemaEmpty ...
+func (d *Driver) IsSchemaEmpty(ctx context.Context) (bool, error) {
+	var count int
+
+	query := `
+		SELECT COUNT(1)
+		FROM information_schema.tables
+		WHERE table_schema = $1
+		AND table_name <> $2
+	`
+
+	// Identifiers are lowercased when quoted, so are stored lowercase.
+	err := d.db.QueryRowContext(ctx, query, strings.ToLower(d.schema), strings.ToLower(d.table)).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to check if schema is empty: %w", err)
+	}
+
+	return count == 0, nil
+}
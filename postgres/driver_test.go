@@ -0,0 +1,354 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/seeruk/go-migrate"
+)
+
+// errFakeExec is returned by the fake database for the query it's configured to fail on.
+var errFakeExec = errors.New("fake: query failed")
+
+// fakeDB is a minimal, in-memory stand-in for a Postgres database behind database/sql. It only
+// understands the queries Driver makes against the versions table, and records every query.
+type fakeDB struct {
+	mu       sync.Mutex
+	created  bool
+	versions []int64
+	queries  []string
+	failOn   string
+}
+
+// open returns a *sql.DB connected to the fake database.
+func (db *fakeDB) open() *sql.DB {
+	return sql.OpenDB(fakeConnector{db: db})
+}
+
+// executed returns whether a query containing the given text has been run.
+func (db *fakeDB) executed(text string) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, query := range db.queries {
+		if strings.Contains(query, text) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type fakeConnector struct {
+	db *fakeDB
+}
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	return &fakeConn{db: c.db}, nil
+}
+
+func (c fakeConnector) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("fake: use the connector")
+}
+
+// fakeConn is a connection to a fakeDB. Versions inserted in a transaction are only visible to
+// other connections once it's committed.
+type fakeConn struct {
+	db      *fakeDB
+	inTx    bool
+	pending []int64
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("fake: prepared statements aren't supported")
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.inTx = true
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	c.db.versions = append(c.db.versions, c.pending...)
+	c.inTx, c.pending = false, nil
+
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	c.inTx, c.pending = false, nil
+	return nil
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	c.db.queries = append(c.db.queries, query)
+
+	if c.db.failOn != "" && strings.Contains(query, c.db.failOn) {
+		return nil, errFakeExec
+	}
+
+	switch {
+	case strings.Contains(query, "CREATE TABLE IF NOT EXISTS"):
+		c.db.created = true
+	case strings.HasPrefix(query, "INSERT INTO"):
+		c.pending = append(c.pending, args[0].Value.(int64))
+		return driver.RowsAffected(1), nil
+	}
+
+	return driver.RowsAffected(0), nil
+}
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	c.db.queries = append(c.db.queries, query)
+
+	switch {
+	case strings.HasPrefix(query, "SELECT to_regclass"):
+		var name driver.Value
+		if c.db.created {
+			name = "schema_versions"
+		}
+
+		return &fakeRows{columns: []string{"to_regclass"}, values: [][]driver.Value{{name}}}, nil
+	case strings.HasPrefix(query, "SELECT version FROM"):
+		rows := &fakeRows{columns: []string{"version"}}
+		for _, version := range append(append([]int64(nil), c.db.versions...), c.pending...) {
+			rows.values = append(rows.values, []driver.Value{version})
+		}
+
+		return rows, nil
+	}
+
+	return nil, errors.New("fake: unexpected query: " + query)
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+
+	return nil
+}
+
+func newTestDriver(t *testing.T, db *fakeDB) *Driver {
+	t.Helper()
+
+	d, err := NewDriver(db.open(), "public", "schema_versions")
+	if err != nil {
+		t.Fatalf("unexpected error creating driver: %v", err)
+	}
+
+	return d
+}
+
+func TestDriver(t *testing.T) {
+	ctx := context.Background()
+	db := &fakeDB{}
+	d := newTestDriver(t, db)
+
+	exists, err := d.VersionTableExists(ctx)
+	if err != nil || exists {
+		t.Fatalf("expected versions table not to exist, got %v, %v", exists, err)
+	}
+
+	if err := d.CreateVersionsTable(ctx); err != nil {
+		t.Fatalf("unexpected error creating versions table: %v", err)
+	}
+
+	exists, err = d.VersionTableExists(ctx)
+	if err != nil || !exists {
+		t.Fatalf("expected versions table to exist, got %v, %v", exists, err)
+	}
+
+	// Committed versions are recorded.
+	if err := d.Begin(ctx); err != nil {
+		t.Fatalf("unexpected error beginning: %v", err)
+	}
+
+	if err := d.Lock(ctx); err != nil {
+		t.Fatalf("unexpected error locking: %v", err)
+	}
+
+	if !db.executed(`LOCK TABLE "public"."schema_versions" IN ACCESS EXCLUSIVE MODE`) {
+		t.Errorf("expected the versions table to be locked")
+	}
+
+	if err := d.Exec(ctx, "CREATE TABLE a ()"); err != nil {
+		t.Fatalf("unexpected error executing: %v", err)
+	}
+
+	for _, version := range []int{1, 2} {
+		if err := d.InsertVersion(ctx, version); err != nil {
+			t.Fatalf("unexpected error inserting version %d: %v", version, err)
+		}
+	}
+
+	assertVersions(t, d.Versions, []int{1, 2})
+	assertVersions(t, d.VersionsReadOnly, nil)
+
+	if err := d.Commit(ctx); err != nil {
+		t.Fatalf("unexpected error committing: %v", err)
+	}
+
+	assertVersions(t, d.Versions, []int{1, 2})
+
+	// Rolled back versions aren't.
+	if err := d.Begin(ctx); err != nil {
+		t.Fatalf("unexpected error beginning: %v", err)
+	}
+
+	if err := d.InsertVersion(ctx, 3); err != nil {
+		t.Fatalf("unexpected error inserting version 3: %v", err)
+	}
+
+	if err := d.Rollback(ctx); err != nil {
+		t.Fatalf("unexpected error rolling back: %v", err)
+	}
+
+	assertVersions(t, d.Versions, []int{1, 2})
+}
+
+func TestDriverExecError(t *testing.T) {
+	ctx := context.Background()
+	db := &fakeDB{failOn: "CREATE TABLE a"}
+	d := newTestDriver(t, db)
+
+	if err := d.Begin(ctx); err != nil {
+		t.Fatalf("unexpected error beginning: %v", err)
+	}
+
+	if err := d.Exec(ctx, "CREATE TABLE a ()"); !errors.Is(err, errFakeExec) {
+		t.Errorf("expected the query's error to be wrapped, got %v", err)
+	}
+
+	if err := d.Rollback(ctx); err != nil {
+		t.Fatalf("unexpected error rolling back: %v", err)
+	}
+}
+
+func TestDriverTransactionNotStarted(t *testing.T) {
+	ctx := context.Background()
+	d := newTestDriver(t, &fakeDB{})
+
+	tests := map[string]func() error{
+		"Commit":              func() error { return d.Commit(ctx) },
+		"Rollback":            func() error { return d.Rollback(ctx) },
+		"Lock":                func() error { return d.Lock(ctx) },
+		"Exec":                func() error { return d.Exec(ctx, "SELECT 1") },
+		"InsertVersion":       func() error { return d.InsertVersion(ctx, 1) },
+		"DeleteVersion":       func() error { return d.DeleteVersion(ctx, 1) },
+		"Savepoint":           func() error { return d.Savepoint(ctx, "sp") },
+		"RollbackToSavepoint": func() error { return d.RollbackToSavepoint(ctx, "sp") },
+		"ReleaseSavepoint":    func() error { return d.ReleaseSavepoint(ctx, "sp") },
+	}
+
+	for name, fn := range tests {
+		if err := fn(); !errors.Is(err, migrate.ErrTransactionNotStarted) {
+			t.Errorf("%s: expected ErrTransactionNotStarted, got %v", name, err)
+		}
+	}
+
+	if err := d.Begin(ctx); err != nil {
+		t.Fatalf("unexpected error beginning: %v", err)
+	}
+
+	if err := d.Begin(ctx); !errors.Is(err, migrate.ErrTransactionAlreadyStarted) {
+		t.Errorf("expected ErrTransactionAlreadyStarted, got %v", err)
+	}
+}
+
+// assertVersions checks that the given versions function returns the expected versions.
+func assertVersions(t *testing.T, versionsFn func(context.Context) ([]int, error), expected []int) {
+	t.Helper()
+
+	versions, err := versionsFn(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error getting versions: %v", err)
+	}
+
+	if !reflect.DeepEqual(versions, expected) {
+		t.Errorf("expected versions %v, got %v", expected, versions)
+	}
+}
+
+func TestDriverQuotesReservedWords(t *testing.T) {
+	ctx := context.Background()
+	db := &fakeDB{}
+
+	// Unquoted, Postgres would fold Order to order, so quoting must use the same table.
+	d, err := NewDriver(db.open(), "public", "Order")
+	if err != nil {
+		t.Fatalf("unexpected error creating driver: %v", err)
+	}
+
+	if err := d.CreateVersionsTable(ctx); err != nil {
+		t.Fatalf("unexpected error creating versions table: %v", err)
+	}
+
+	if err := d.Begin(ctx); err != nil {
+		t.Fatalf("unexpected error beginning: %v", err)
+	}
+
+	if err := d.Lock(ctx); err != nil {
+		t.Fatalf("unexpected error locking: %v", err)
+	}
+
+	if err := d.InsertVersion(ctx, 1); err != nil {
+		t.Fatalf("unexpected error inserting version: %v", err)
+	}
+
+	if err := d.Commit(ctx); err != nil {
+		t.Fatalf("unexpected error committing: %v", err)
+	}
+
+	for _, query := range []string{
+		`CREATE TABLE IF NOT EXISTS "public"."order" (`,
+		`LOCK TABLE "public"."order" IN ACCESS EXCLUSIVE MODE`,
+		`INSERT INTO "public"."order" (version) VALUES ($1)`,
+	} {
+		if !db.executed(query) {
+			t.Errorf("expected query to be executed: %s", query)
+		}
+	}
+}
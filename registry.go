@@ -0,0 +1,364 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNamespaceExecuting is returned when registering migrations in a namespace while Execute is
+// running for it, as they wouldn't be applied by that run.
+var ErrNamespaceExecuting = errors.New("migrate: namespace is being executed")
+
+// Registry holds migrations by namespace. The package-level Register functions and Execute use a
+// default Registry; separate Registries allow independent sets of migrations in one process, e.g.
+// in parallel tests. The zero value isn't usable; use NewRegistry. A Registry is safe for
+// concurrent use.
+//
+// The registration/execution ordering contract is:
+//
+//   - Every Register function registers all of its migrations atomically, or none of them.
+//   - Execute waits for registrations in progress in its namespace (see RegisterOnce) to finish,
+//     and then applies a snapshot of the namespace taken at that point.
+//   - Registering in a namespace while Execute is running for it fails with ErrNamespaceExecuting.
+//   - Registering after Execute has returned is fine; the migrations are applied by the next run.
+type Registry struct {
+	// mu guards every field below.
+	mu sync.Mutex
+
+	// migrations contains all registered migrations, by namespace.
+	migrations NamespacedMigrations
+	// repeatables contains all registered repeatable migrations, by namespace and name.
+	repeatables map[string]map[string]RepeatableMigration
+	// dependencies contains the namespaces each namespace depends on. See DependsOn.
+	dependencies map[string][]string
+	// sequenced contains the namespaces that use sequences. See UseSequences.
+	sequenced map[string]bool
+	// hooks are called for each migration as it's registered. See OnRegistered.
+	hooks []func(namespace string, migration Migration) error
+
+	// inFlight counts the RegisterOnce calls in progress, by namespace.
+	inFlight map[string]int
+	// idle is closed when a namespace has no more RegisterOnce calls in progress.
+	idle map[string]chan struct{}
+	// executing counts the Execute calls in progress, by namespace.
+	executing map[string]int
+	// onceCalls contains the RegisterOnce calls made so far, by namespace and key.
+	onceCalls map[[2]string]*onceCall
+}
+
+// defaultRegistry is the Registry used by the package-level functions.
+var defaultRegistry = NewRegistry()
+
+// NewRegistry returns a new, empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		migrations:   make(NamespacedMigrations),
+		repeatables:  make(map[string]map[string]RepeatableMigration),
+		dependencies: make(map[string][]string),
+		sequenced:    make(map[string]bool),
+		inFlight:     make(map[string]int),
+		idle:         make(map[string]chan struct{}),
+		executing:    make(map[string]int),
+		onceCalls:    make(map[[2]string]*onceCall),
+	}
+}
+
+// onceCall is a single call to a RegisterOnce function, shared by every caller with its key.
+type onceCall struct {
+	done chan struct{}
+	err  error
+}
+
+// RegisterOnce calls fn, and registers the migrations it returns in the given namespace, at most
+// once per key; it's for registering migrations lazily, e.g. as feature modules are enabled, where
+// the same module may be started more than once, possibly concurrently. Concurrent callers with
+// the same key wait for the first call to finish, and every caller gets its result, so a key that
+// failed isn't retried. Migrations are registered all at once, or not at all if fn or registering
+// any of them fails. While fn is running, Execute for the namespace waits for it, so a namespace
+// is never partially registered when migrated. fn must not register migrations itself.
+func RegisterOnce(namespace, key string, fn func() ([]Migration, error)) error {
+	return defaultRegistry.RegisterOnce(namespace, key, fn)
+}
+
+// RegisterOnce is like the package-level RegisterOnce, but uses this Registry.
+func (r *Registry) RegisterOnce(namespace, key string, fn func() ([]Migration, error)) error {
+	r.mu.Lock()
+
+	if call, ok := r.onceCalls[[2]string{namespace, key}]; ok {
+		r.mu.Unlock()
+		<-call.done
+		return call.err
+	}
+
+	if r.executing[namespace] > 0 {
+		r.mu.Unlock()
+		return fmt.Errorf("namespace %q: %w", namespace, ErrNamespaceExecuting)
+	}
+
+	call := &onceCall{done: make(chan struct{})}
+	r.onceCalls[[2]string{namespace, key}] = call
+
+	if r.inFlight[namespace] == 0 {
+		r.idle[namespace] = make(chan struct{})
+	}
+
+	r.inFlight[namespace]++
+	r.mu.Unlock()
+
+	defer close(call.done)
+	// Deferred, so that Execute doesn't wait forever for a call where fn panicked.
+	defer r.endRegisterOnce(namespace)
+
+	// If fn panics, this is the result every other caller with the key gets.
+	call.err = fmt.Errorf("namespace %q: %q: panicked", namespace, key)
+
+	migrations, err := fn()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err != nil {
+		call.err = fmt.Errorf("namespace %q: %q: %w", namespace, key, err)
+		return call.err
+	}
+
+	call.err = r.registerLocked(namespace, migrations)
+
+	return call.err
+}
+
+// endRegisterOnce marks a RegisterOnce call in the given namespace as finished, letting Execute
+// continue once there are none left.
+func (r *Registry) endRegisterOnce(namespace string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.inFlight[namespace]--
+	if r.inFlight[namespace] == 0 {
+		close(r.idle[namespace])
+		delete(r.idle, namespace)
+		delete(r.inFlight, namespace)
+	}
+}
+
+// register registers all of the given migrations in the namespace, or none of them if any fail.
+func (r *Registry) register(namespace string, migrations []Migration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.registerLocked(namespace, migrations)
+}
+
+// registerLocked is register, for callers already holding mu.
+func (r *Registry) registerLocked(namespace string, migrations []Migration) error {
+	if r.executing[namespace] > 0 {
+		return fmt.Errorf("namespace %q: %w", namespace, ErrNamespaceExecuting)
+	}
+
+	existing := r.migrations[namespace]
+	added := make(Migrations, len(migrations))
+
+	for _, migration := range migrations {
+		if err := migration.Validate(); err != nil {
+			return fmt.Errorf("namespace %q: %w", namespace, err)
+		}
+
+		described := describeVersion(migration.Version, migration.Sequence)
+
+		registered, err := r.sequencedLocked(namespace, migration)
+		if err != nil {
+			return fmt.Errorf("namespace %q: %s: %w", namespace, described, err)
+		}
+
+		// Registering the exact same migration again is harmless, but anything else is probably
+		// two migrations accidentally given the same version, one of which would otherwise be lost.
+		for _, other := range []Migrations{existing, added} {
+			if prev, ok := other[registered.Version]; ok && !reflect.DeepEqual(prev, registered) {
+				return fmt.Errorf("namespace %q: %s: %w", namespace, described, ErrDuplicateVersion)
+			}
+		}
+
+		// Hooks are given the migration as it was given, e.g. to check its version's format.
+		for _, hook := range r.hooks {
+			if err := hook(namespace, migration); err != nil {
+				return fmt.Errorf("namespace %q: %s: rejected by registration hook: %w", namespace, described, err)
+			}
+		}
+
+		added[registered.Version] = registered
+	}
+
+	if existing == nil {
+		existing = make(Migrations, len(added))
+		r.migrations[namespace] = existing
+	}
+
+	for version, migration := range added {
+		existing[version] = migration
+	}
+
+	return nil
+}
+
+// sequencedLocked returns the migration as it's registered in the given namespace, i.e. with its
+// Sequence folded into its Version if the namespace uses sequences. See UseSequences.
+func (r *Registry) sequencedLocked(namespace string, migration Migration) (Migration, error) {
+	if !r.sequenced[namespace] {
+		if migration.Sequence != 0 {
+			return Migration{}, fmt.Errorf("sequence given, but the namespace doesn't use sequences: %w", ErrInvalidMigration)
+		}
+
+		return migration, nil
+	}
+
+	if migration.Version > math.MaxInt/SequenceLimit {
+		return Migration{}, fmt.Errorf("version too large to be sequenced: %w", ErrInvalidMigration)
+	}
+
+	migration.Version = migration.Version*SequenceLimit + migration.Sequence
+	migration.Sequence = 0
+
+	return migration, nil
+}
+
+// registered returns a copy of the migrations registered in the given namespace, or nil if the
+// namespace doesn't exist.
+func (r *Registry) registered(namespace string) Migrations {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return copyMigrations(r.migrations[namespace])
+}
+
+// beginExecute waits for any registrations in progress in the given namespace to finish, and then
+// returns a snapshot of its migrations and repeatable migrations, along with a function to call
+// once Execute has finished, during which registering in the namespace is refused. The snapshot of
+// migrations is nil if the namespace doesn't exist.
+func (r *Registry) beginExecute(ctx context.Context, namespace string) (Migrations, []RepeatableMigration, func(), error) {
+	for {
+		r.mu.Lock()
+
+		ch, ok := r.idle[namespace]
+		if !ok {
+			break
+		}
+
+		r.mu.Unlock()
+
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return nil, nil, nil, fmt.Errorf("failed waiting for registrations to finish: %w", ctx.Err())
+		}
+	}
+
+	defer r.mu.Unlock()
+
+	r.executing[namespace]++
+
+	done := func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		r.executing[namespace]--
+		if r.executing[namespace] == 0 {
+			delete(r.executing, namespace)
+		}
+	}
+
+	return copyMigrations(r.migrations[namespace]), sortedRepeatables(r.repeatables[namespace]), done, nil
+}
+
+// copyMigrations returns a shallow copy of the given migrations, or nil if it is nil.
+func copyMigrations(migrations Migrations) Migrations {
+	if migrations == nil {
+		return nil
+	}
+
+	cp := make(Migrations, len(migrations))
+	for version, migration := range migrations {
+		cp[version] = migration
+	}
+
+	return cp
+}
+
+// sortMigrations sorts the given migrations by version, and then sequence.
+func sortMigrations(migrations []Migration) {
+	sort.Slice(migrations, func(i, j int) bool {
+		if migrations[i].Version != migrations[j].Version {
+			return migrations[i].Version < migrations[j].Version
+		}
+
+		return migrations[i].Sequence < migrations[j].Sequence
+	})
+}
+
+// Register is like the package-level Register, but uses this Registry.
+func (r *Registry) Register(namespace string, migration Migration) {
+	if err := r.RegisterE(namespace, migration); err != nil {
+		panic(err)
+	}
+}
+
+// RegisterE is like the package-level RegisterE, but uses this Registry.
+func (r *Registry) RegisterE(namespace string, migration Migration) error {
+	return r.register(namespace, []Migration{migration})
+}
+
+// RegisterFS is like the package-level RegisterFS, but uses this Registry.
+func (r *Registry) RegisterFS(namespace string, in fs.FS, opts ...FSOption) error {
+	var o fsOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	migrations, err := readFS(in, o)
+	if err != nil {
+		return err
+	}
+
+	return r.register(namespace, migrations)
+}
+
+// OnRegistered is like the package-level OnRegistered, but only applies to this Registry.
+func (r *Registry) OnRegistered(hook func(namespace string, migration Migration) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.hooks = append(r.hooks, hook)
+}
+
+// Execute is like the package-level Execute, but applies migrations from this Registry.
+func (r *Registry) Execute(driver Driver, events EventHandler, namespace string, timeout time.Duration, opts ...Option) error {
+	return Execute(driver, events, namespace, timeout, r.withOptions(opts)...)
+}
+
+// ExecuteContext is like the package-level ExecuteContext, but applies migrations from this
+// Registry.
+func (r *Registry) ExecuteContext(ctx context.Context, driver Driver, events EventHandler, namespace string, timeout time.Duration, opts ...Option) error {
+	return ExecuteContext(ctx, driver, events, namespace, timeout, r.withOptions(opts)...)
+}
+
+// ExecuteResult is like the package-level ExecuteResult, but applies migrations from this
+// Registry.
+func (r *Registry) ExecuteResult(ctx context.Context, driver Driver, events EventHandler, namespace string, timeout time.Duration, opts ...Option) (Result, error) {
+	return ExecuteResult(ctx, driver, events, namespace, timeout, r.withOptions(opts)...)
+}
+
+// Run is like the package-level Run, but applies migrations from this Registry.
+func (r *Registry) Run(ctx context.Context, driver Driver, namespace string, opts ...Option) error {
+	return Run(ctx, driver, namespace, r.withOptions(opts)...)
+}
+
+// withOptions returns the given options, preceded by WithRegistry for this Registry.
+func (r *Registry) withOptions(opts []Option) []Option {
+	return append([]Option{WithRegistry(r)}, opts...)
+}
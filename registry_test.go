@@ -0,0 +1,255 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestExecuteIsRepeatable(t *testing.T) {
+	r := NewRegistry()
+	r.Register("default", NewMigration(1, "CREATE TABLE a"))
+	r.Register("default", NewMigration(2, "CREATE TABLE b"))
+	r.Register("default", NewMigration(3, "CREATE TABLE c"))
+
+	before := r.registered("default")
+	driver := newFakeDriver()
+
+	for i := 0; i < 2; i++ {
+		if err := r.ExecuteContext(context.Background(), driver, NoopEventHandler{}, "default", 0); err != nil {
+			t.Fatalf("run %d: unexpected error: %v", i+1, err)
+		}
+
+		if got := driver.db.committedVersions(); !reflect.DeepEqual(got, []int{1, 2, 3}) {
+			t.Fatalf("run %d: expected committed versions [1 2 3], got %v", i+1, got)
+		}
+	}
+
+	if got := len(driver.db.execs); got != 3 {
+		t.Errorf("expected 3 commands to be executed, got %d", got)
+	}
+
+	if after := r.registered("default"); !reflect.DeepEqual(before, after) {
+		t.Errorf("expected registered migrations to be unchanged, got %v", after)
+	}
+}
+
+func TestExecuteConcurrently(t *testing.T) {
+	const goroutines = 8
+
+	r := NewRegistry()
+	r.Register("default", NewMigration(1, "CREATE TABLE a"))
+	r.Register("default", NewMigration(2, "CREATE TABLE b"))
+	r.Register("default", NewMigration(3, "CREATE TABLE c"))
+
+	before := r.registered("default")
+	db := &fakeDB{}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines)
+
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			errs <- r.ExecuteContext(context.Background(), &fakeDriver{db: db}, NoopEventHandler{}, "default", 0)
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	// Every run holds the lock, so each version must have been applied exactly once.
+	if got := db.committedVersions(); !reflect.DeepEqual(got, []int{1, 2, 3}) {
+		t.Errorf("expected committed versions [1 2 3], got %v", got)
+	}
+
+	if got := len(db.execs); got != 3 {
+		t.Errorf("expected 3 commands to be executed, got %d", got)
+	}
+
+	if after := r.registered("default"); !reflect.DeepEqual(before, after) {
+		t.Errorf("expected registered migrations to be unchanged, got %v", after)
+	}
+}
+
+func TestExecuteWaitsForRegisterOnce(t *testing.T) {
+	r := NewRegistry()
+	r.Register("default", NewMigration(1, "CREATE TABLE a"))
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	registered := make(chan error, 1)
+
+	go func() {
+		registered <- r.RegisterOnce("default", "module", func() ([]Migration, error) {
+			close(started)
+			<-release
+			return []Migration{NewMigration(2, "CREATE TABLE b")}, nil
+		})
+	}()
+
+	<-started
+
+	driver := newFakeDriver()
+	executed := make(chan error, 1)
+
+	go func() {
+		executed <- r.ExecuteContext(context.Background(), driver, NoopEventHandler{}, "default", 0)
+	}()
+
+	select {
+	case err := <-executed:
+		t.Fatalf("expected Execute to wait for RegisterOnce, but it returned %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+
+	if err := <-registered; err != nil {
+		t.Fatalf("unexpected error registering: %v", err)
+	}
+
+	if err := <-executed; err != nil {
+		t.Fatalf("unexpected error executing: %v", err)
+	}
+
+	if got := driver.db.committedVersions(); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("expected committed versions [1 2], got %v", got)
+	}
+}
+
+func TestRegisterDuringExecuteIsRejected(t *testing.T) {
+	r := NewRegistry()
+
+	var onceErr, registerErr error
+
+	r.Register("default", NewFuncMigration(1, func(ctx context.Context, tx Executor) error {
+		onceErr = r.RegisterOnce("default", "module", func() ([]Migration, error) {
+			return []Migration{NewMigration(2, "CREATE TABLE b")}, nil
+		})
+		registerErr = r.RegisterE("default", NewMigration(3, "CREATE TABLE c"))
+		return nil
+	}))
+
+	if err := r.ExecuteContext(context.Background(), newFakeDriver(), NoopEventHandler{}, "default", 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !errors.Is(onceErr, ErrNamespaceExecuting) {
+		t.Errorf("expected RegisterOnce to fail with ErrNamespaceExecuting, got %v", onceErr)
+	}
+
+	if !errors.Is(registerErr, ErrNamespaceExecuting) {
+		t.Errorf("expected RegisterE to fail with ErrNamespaceExecuting, got %v", registerErr)
+	}
+
+	if got := len(r.registered("default")); got != 1 {
+		t.Errorf("expected 1 registered migration, got %d", got)
+	}
+
+	// Once Execute has returned, registering is fine again.
+	if err := r.RegisterE("default", NewMigration(3, "CREATE TABLE c")); err != nil {
+		t.Errorf("unexpected error registering after Execute: %v", err)
+	}
+}
+
+func TestRegisterOnceFailureLeavesRegistryUsable(t *testing.T) {
+	errModule := errors.New("module failed")
+
+	tests := []struct {
+		name string
+		fn   func() ([]Migration, error)
+	}{
+		{
+			name: "error",
+			fn: func() ([]Migration, error) {
+				return nil, errModule
+			},
+		},
+		{
+			name: "panic",
+			fn: func() ([]Migration, error) {
+				panic("boom")
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := NewRegistry()
+			r.Register("default", NewMigration(1, "CREATE TABLE a"))
+
+			func() {
+				defer func() { recover() }()
+				if err := r.RegisterOnce("default", "module", test.fn); err == nil {
+					t.Errorf("expected an error")
+				}
+			}()
+
+			// The key isn't retried; every later caller gets the first call's error.
+			err := r.RegisterOnce("default", "module", func() ([]Migration, error) {
+				return []Migration{NewMigration(2, "CREATE TABLE b")}, nil
+			})
+			if err == nil {
+				t.Errorf("expected the failed key's error to be returned again")
+			}
+
+			if err := r.RegisterOnce("default", "other", func() ([]Migration, error) {
+				return []Migration{NewMigration(3, "CREATE TABLE c")}, nil
+			}); err != nil {
+				t.Fatalf("unexpected error registering another key: %v", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			driver := newFakeDriver()
+			if err := r.ExecuteContext(ctx, driver, NoopEventHandler{}, "default", 0); err != nil {
+				t.Fatalf("unexpected error executing: %v", err)
+			}
+
+			if got := driver.db.committedVersions(); !reflect.DeepEqual(got, []int{1, 3}) {
+				t.Errorf("expected committed versions [1 3], got %v", got)
+			}
+		})
+	}
+}
+
+func TestRegisterEmptyMigration(t *testing.T) {
+	r := NewRegistry()
+
+	for _, migration := range []Migration{
+		{Version: 1},
+		NewMigration(1, "  "),
+		{Version: 1, Commands: []string{"CREATE TABLE a"}, NoOp: true},
+	} {
+		if err := r.RegisterE("default", migration); !errors.Is(err, ErrInvalidMigration) {
+			t.Errorf("expected ErrInvalidMigration registering %+v, got %v", migration, err)
+		}
+	}
+
+	r.Register("default", Migration{Version: 1, NoOp: true})
+	r.Register("default", NewMigration(2, "CREATE TABLE b"))
+
+	driver := newFakeDriver()
+
+	result, err := r.ExecuteResult(context.Background(), driver, NoopEventHandler{}, "default", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !reflect.DeepEqual(result.Skipped, []int{1}) || !reflect.DeepEqual(result.Applied, []int{2}) {
+		t.Errorf("expected version 1 to be skipped, and 2 applied, got %+v", result)
+	}
+}
@@ -0,0 +1,157 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// ErrRepeatableNotSupported is returned when repeatable migrations are registered, but the driver
+// doesn't implement RepeatableDriver.
+var ErrRepeatableNotSupported = errors.New("migrate: driver does not support repeatable migrations")
+
+// RepeatableMigration is a migration that's applied again whenever its commands change, rather
+// than once, for objects that are replaced wholesale, e.g. views, functions, and stored
+// procedures. Its commands must be safe to run again (e.g. CREATE OR REPLACE VIEW).
+type RepeatableMigration struct {
+	// Name identifies the migration within its namespace. Repeatable migrations are applied in
+	// name order, after any pending versions, so they can depend on the latest schema.
+	Name     string
+	Commands []string
+}
+
+// NewRepeatableMigration returns a new RepeatableMigration value.
+func NewRepeatableMigration(name string, commands ...string) RepeatableMigration {
+	return RepeatableMigration{
+		Name:     name,
+		Commands: commands,
+	}
+}
+
+// Validate checks that the migration is well-formed, returning an error wrapping
+// ErrInvalidMigration describing the first problem found if it isn't.
+func (m RepeatableMigration) Validate() error {
+	switch {
+	case m.Name == "":
+		return fmt.Errorf("repeatable migration must have a name: %w", ErrInvalidMigration)
+	case len(m.Commands) == 0:
+		return fmt.Errorf("repeatable migration %q has no commands: %w", m.Name, ErrInvalidMigration)
+	}
+
+	return nil
+}
+
+// checksum returns the checksum that identifies the migration's current commands.
+func (m RepeatableMigration) checksum() string {
+	return ChecksumSHA256(m.Commands)
+}
+
+// RegisterRepeatable registers a repeatable migration in the given namespace. Like Register, it
+// panics if the migration is invalid, or its name is already taken; use RegisterRepeatableE to
+// handle the error instead. The driver must implement RepeatableDriver.
+func RegisterRepeatable(namespace string, migration RepeatableMigration) {
+	if err := RegisterRepeatableE(namespace, migration); err != nil {
+		panic(err)
+	}
+}
+
+// RegisterRepeatableE validates the given repeatable migration, and then registers it. An error
+// wrapping ErrDuplicateVersion is returned if a different repeatable migration is already
+// registered with the same name in the namespace. OnRegistered hooks aren't called.
+func RegisterRepeatableE(namespace string, migration RepeatableMigration) error {
+	return defaultRegistry.RegisterRepeatableE(namespace, migration)
+}
+
+// RegisterRepeatable is like the package-level RegisterRepeatable, but uses this Registry.
+func (r *Registry) RegisterRepeatable(namespace string, migration RepeatableMigration) {
+	if err := r.RegisterRepeatableE(namespace, migration); err != nil {
+		panic(err)
+	}
+}
+
+// RegisterRepeatableE is like the package-level RegisterRepeatableE, but uses this Registry.
+func (r *Registry) RegisterRepeatableE(namespace string, migration RepeatableMigration) error {
+	if err := migration.Validate(); err != nil {
+		return fmt.Errorf("namespace %q: %w", namespace, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.executing[namespace] > 0 {
+		return fmt.Errorf("namespace %q: %w", namespace, ErrNamespaceExecuting)
+	}
+
+	existing := r.repeatables[namespace]
+	if prev, ok := existing[migration.Name]; ok && !reflect.DeepEqual(prev, migration) {
+		return fmt.Errorf("namespace %q: repeatable migration %q: %w", namespace, migration.Name, ErrDuplicateVersion)
+	}
+
+	if existing == nil {
+		existing = make(map[string]RepeatableMigration)
+		r.repeatables[namespace] = existing
+	}
+
+	existing[migration.Name] = migration
+
+	return nil
+}
+
+// sortedRepeatables returns the given repeatable migrations as a slice, in name order.
+func sortedRepeatables(repeatables map[string]RepeatableMigration) []RepeatableMigration {
+	sorted := make([]RepeatableMigration, 0, len(repeatables))
+	for _, migration := range repeatables {
+		sorted = append(sorted, migration)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	return sorted
+}
+
+// applyRepeatables applies the given repeatable migrations whose commands have changed since they
+// were last applied (or that have never been applied), in order, in the driver's current
+// transaction. The names of those applied are returned.
+func applyRepeatables(ctx context.Context, driver Driver, rd RepeatableDriver, events EventHandler, repeatables []RepeatableMigration) ([]string, error) {
+	stored, err := rd.RepeatableChecksums(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get repeatable migration checksums: %w", err)
+	}
+
+	var applied []string
+
+	for _, migration := range repeatables {
+		checksum := migration.checksum()
+		if stored[migration.Name] == checksum {
+			continue
+		}
+
+		events.BeforeRepeatableMigrate(migration.Name)
+
+		for i, command := range migration.Commands {
+			if err := ctx.Err(); err != nil {
+				return nil, fmt.Errorf("stopped before repeatable migration %q command %d: %w", migration.Name, i, err)
+			}
+
+			err = driver.Exec(ctx, command)
+			if err != nil {
+				return nil, fmt.Errorf("repeatable migration %q: command %d failed: %w", migration.Name, i, err)
+			}
+		}
+
+		err = rd.SetRepeatableChecksum(ctx, migration.Name, checksum)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set repeatable migration checksum: %w", err)
+		}
+
+		events.AfterRepeatableMigrate(migration.Name)
+
+		applied = append(applied, migration.Name)
+	}
+
+	return applied, nil
+}
@@ -0,0 +1,169 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// StatusReport describes everything known about the migrations in a namespace, comparing what's
+// registered in this binary to what's recorded in the database. See Report.
+type StatusReport struct {
+	Namespace string
+	// Registered contains every registered version, in ascending order.
+	Registered []int
+	// Applied contains every recorded version, in ascending order.
+	Applied []int
+	// Pending contains the registered versions that haven't been applied yet, in ascending order.
+	// Empty migrations are omitted, as Execute skips them.
+	Pending []int
+	// Orphaned contains the recorded versions that aren't registered, in ascending order, e.g.
+	// because they were applied by a newer binary.
+	Orphaned []int
+	// OutOfOrder contains the pending versions that are lower than the highest applied version, in
+	// ascending order, e.g. because they were merged from a long-lived branch. WithStrictOrdering
+	// refuses to apply them.
+	OutOfOrder []int
+	// Mismatched contains the applied versions whose stored checksum doesn't match the registered
+	// migration, in ascending order. It's only populated if checksums are enabled.
+	Mismatched []int
+}
+
+// Report returns a StatusReport for the given namespace, without applying anything or locking the
+// versions table. If WithChecksum or WithChecksumTable is given, checksums are verified too, and
+// the driver must support them. Versions given to WithExclude aren't reported as out of order.
+// WithTimeout bounds it. Other options are ignored.
+func Report(driver Driver, namespace string, ctx context.Context, opts ...Option) (StatusReport, error) {
+	o := newOptions(opts...)
+
+	ctx, cancel := withTimeout(ctx, o.timeout)
+	defer cancel()
+
+	state, err := o.registry.Observe(driver, namespace, ctx)
+	if err != nil {
+		return StatusReport{}, err
+	}
+
+	migrationsByVersion := o.registry.registered(namespace)
+
+	report := StatusReport{
+		Namespace: namespace,
+		Applied:   state.Applied,
+	}
+
+	for version := range migrationsByVersion {
+		report.Registered = append(report.Registered, version)
+	}
+
+	sort.Ints(report.Registered)
+
+	for _, migration := range state.Pending {
+		report.Pending = append(report.Pending, migration.Version)
+	}
+
+	for _, version := range state.Applied {
+		if _, ok := migrationsByVersion[version]; !ok {
+			report.Orphaned = append(report.Orphaned, version)
+		}
+	}
+
+	report.OutOfOrder = o.outOfOrder(report.Pending, report.Applied)
+
+	if o.checksums {
+		report.Mismatched, _, err = o.checksumMismatches(ctx, driver, namespace)
+		if err != nil {
+			return StatusReport{}, err
+		}
+	}
+
+	return report, nil
+}
+
+// String renders the report as a human-readable table, with one row per version.
+func (r StatusReport) String() string {
+	var sb strings.Builder
+	_, _ = r.WriteTo(&sb)
+	return sb.String()
+}
+
+// Gaps returns an error wrapping ErrVersionGap naming the orphaned and out of order versions, or
+// nil if there are none, so that e.g. CI can fail when branches or environments have drifted.
+func (r StatusReport) Gaps() error {
+	if len(r.Orphaned) == 0 && len(r.OutOfOrder) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("orphaned versions %v, out of order versions %v: %w", r.Orphaned, r.OutOfOrder, ErrVersionGap)
+}
+
+// WriteTo writes the report to w as a human-readable table, with one row per version.
+func (r StatusReport) WriteTo(w io.Writer) (int64, error) {
+	registered := versionSet(r.Registered)
+	applied := versionSet(r.Applied)
+	pending := versionSet(r.Pending)
+	outOfOrder := versionSet(r.OutOfOrder)
+	mismatched := versionSet(r.Mismatched)
+
+	var versions []int
+	versions = append(versions, r.Registered...)
+	versions = append(versions, r.Orphaned...)
+	sort.Ints(versions)
+
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "Namespace: %s\n", r.Namespace)
+
+	tw := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tREGISTERED\tAPPLIED\tSTATUS")
+
+	for _, version := range versions {
+		var status string
+		switch {
+		case mismatched[version]:
+			status = "checksum mismatch"
+		case !registered[version]:
+			status = "orphaned"
+		case outOfOrder[version]:
+			status = "pending (out of order)"
+		case pending[version]:
+			status = "pending"
+		case applied[version]:
+			status = "applied"
+		default:
+			status = "empty"
+		}
+
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", version, yesNo(registered[version]), yesNo(applied[version]), status)
+	}
+
+	_ = tw.Flush()
+
+	n, err := io.WriteString(w, sb.String())
+	if err != nil {
+		return int64(n), fmt.Errorf("failed to write report: %w", err)
+	}
+
+	return int64(n), nil
+}
+
+// versionSet returns the given versions as a set.
+func versionSet(versions []int) map[int]bool {
+	set := make(map[int]bool, len(versions))
+	for _, version := range versions {
+		set[version] = true
+	}
+
+	return set
+}
+
+// yesNo returns "yes" if b is true, otherwise "no".
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+
+	return "no"
+}
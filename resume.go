@@ -0,0 +1,57 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// runInProgressKey is the metadata key marking that a run using a transaction per migration has
+// committed some, but not all, of its versions.
+const runInProgressKey = "run_in_progress"
+
+// ErrInconsistentState is returned when resuming an interrupted run, but schema objects have been
+// left in an inconsistent state by it, which need cleaning up before it can safely resume.
+var ErrInconsistentState = errors.New("migrate: schema objects left in an inconsistent state")
+
+// checkResume returns true if a previous run using a transaction per migration was interrupted
+// before committing all of its versions, and marks this run as in progress, as part of the
+// transaction. If the driver implements InconsistencyDetector, an error wrapping
+// ErrInconsistentState is returned if the interrupted run left anything behind.
+func checkResume(ctx context.Context, driver Driver, metadata MetadataDriver) (bool, error) {
+	marker, err := metadata.Metadata(ctx, runInProgressKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to get run in progress marker: %w", err)
+	}
+
+	if marker != "" {
+		if detector, ok := driver.(InconsistencyDetector); ok {
+			objects, err := detector.DetectInconsistentObjects(ctx)
+			if err != nil {
+				return false, fmt.Errorf("failed to detect inconsistent objects: %w", err)
+			}
+
+			if len(objects) > 0 {
+				return false, fmt.Errorf("%w: %s", ErrInconsistentState, strings.Join(objects, "; "))
+			}
+		}
+	}
+
+	err = metadata.SetMetadata(ctx, runInProgressKey, "true")
+	if err != nil {
+		return false, fmt.Errorf("failed to set run in progress marker: %w", err)
+	}
+
+	return marker != "", nil
+}
+
+// finishRun clears the run in progress marker, as part of the transaction.
+func finishRun(ctx context.Context, metadata MetadataDriver) error {
+	err := metadata.SetMetadata(ctx, runInProgressKey, "")
+	if err != nil {
+		return fmt.Errorf("failed to clear run in progress marker: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrIrreversible is returned when a rollback would need to revert a version that has no down
+// commands registered.
+var ErrIrreversible = errors.New("migrate: migration has no down commands")
+
+// RollbackPlan returns the migrations that rolling back the given number of steps would revert,
+// highest version first, along with their down commands. If steps is zero or less, every applied
+// version is included. Nothing is executed, and the versions table isn't locked. If any version
+// in the plan has no down commands registered (including applied versions that are no longer
+// registered at all) an error wrapping ErrIrreversible is returned, naming those versions.
+func RollbackPlan(driver Driver, namespace string, steps int, ctx context.Context) ([]Migration, error) {
+	return defaultRegistry.RollbackPlan(driver, namespace, steps, ctx)
+}
+
+// RollbackPlan is like the package-level RollbackPlan, but uses this Registry.
+func (r *Registry) RollbackPlan(driver Driver, namespace string, steps int, ctx context.Context) ([]Migration, error) {
+	applied, err := AppliedVersions(driver, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Sort(sort.Reverse(sort.IntSlice(applied)))
+
+	if steps > 0 && steps < len(applied) {
+		applied = applied[:steps]
+	}
+
+	return rollbackPlan(r.registered(namespace), applied)
+}
+
+// Rollback reverts every applied version higher than toVersion, highest first, by executing their
+// down commands and deleting them from the versions table, in a transaction holding the versions
+// table lock (see WithLockScope). Nothing is reverted if any of those versions has no down commands
+// registered; an error wrapping ErrIrreversible is returned, naming them. If a down command fails,
+// the transaction is rolled back. See revert for what that means on databases without transactional
+// DDL. The driver must implement VersionDeleter.
+func Rollback(driver Driver, events EventHandler, namespace string, toVersion int, ctx context.Context, opts ...Option) error {
+	return defaultRegistry.Rollback(driver, events, namespace, toVersion, ctx, opts...)
+}
+
+// Rollback is like the package-level Rollback, but uses this Registry, e.g. to revert migrations
+// registered from an embedded filesystem with its RegisterFS.
+func (r *Registry) Rollback(driver Driver, events EventHandler, namespace string, toVersion int, ctx context.Context, opts ...Option) error {
+	o := newOptions(opts...)
+
+	ctx, cancel := withTimeout(ctx, o.timeout)
+	defer cancel()
+
+	if events == nil {
+		events = o.events
+	}
+
+	deleter, ok := driver.(VersionDeleter)
+	if !ok {
+		return ErrVersionDeleteNotSupported
+	}
+
+	exists, err := driver.VersionTableExists(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check if versions table exists: %w", err)
+	}
+
+	if !exists {
+		return nil
+	}
+
+	migrationsByVersion := r.registered(namespace)
+
+	return inTransaction(ctx, driver, o, func() error {
+		existingVersions, err := driver.Versions(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get current versions: %w", err)
+		}
+
+		var applied []int
+		for _, version := range existingVersions {
+			if version > toVersion {
+				applied = append(applied, version)
+			}
+		}
+
+		sort.Sort(sort.Reverse(sort.IntSlice(applied)))
+
+		plan, err := rollbackPlan(migrationsByVersion, applied)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range plan {
+			events.BeforeVersionRevert(migration.Version)
+
+			err = revert(ctx, driver, deleter, events, migration)
+			if err != nil {
+				return err
+			}
+
+			events.AfterVersionRevert(migration.Version)
+		}
+
+		return nil
+	})
+}
+
+// rollbackPlan returns the migrations that revert the given applied versions, in the same order,
+// or an error wrapping ErrIrreversible if any of them have no down commands.
+func rollbackPlan(migrationsByVersion Migrations, applied []int) ([]Migration, error) {
+	var plan []Migration
+	var irreversible []int
+
+	for _, version := range applied {
+		migration, ok := migrationsByVersion[version]
+		if !ok {
+			migration = Migration{Version: version}
+		}
+
+		if len(migration.Down) == 0 {
+			irreversible = append(irreversible, version)
+		}
+
+		plan = append(plan, migration)
+	}
+
+	if len(irreversible) > 0 {
+		return nil, fmt.Errorf("versions %v: %w", irreversible, ErrIrreversible)
+	}
+
+	return plan, nil
+}
+
+// revert executes the given migration's down commands, and then deletes its version, in the
+// driver's current transaction. If a down command fails, an OnRollbackPartialFailure event reports
+// which commands succeeded, and the version is left recorded, so the versions table never claims
+// a migration isn't applied while some of its objects may still exist. The caller should roll
+// back the transaction, which undoes the down commands that succeeded where the database supports
+// transactional DDL. Where it doesn't (e.g. MySQL), they stay applied, and the event says which.
+func revert(ctx context.Context, driver Driver, deleter VersionDeleter, events EventHandler, migration Migration) error {
+	for i, command := range migration.Down {
+		if err := ctx.Err(); err != nil {
+			events.OnRollbackPartialFailure(migration.Version, i, i, err)
+			return fmt.Errorf("stopped before version %d down command %d: %w", migration.Version, i, err)
+		}
+
+		err := driver.Exec(ctx, command)
+		if err != nil {
+			events.OnRollbackPartialFailure(migration.Version, i, i, err)
+			return newMigrationError(driver, migration.Version, i, err)
+		}
+	}
+
+	err := deleter.DeleteVersion(ctx, migration.Version)
+	if err != nil {
+		return fmt.Errorf("failed to delete version %d: %w", migration.Version, err)
+	}
+
+	return nil
+}
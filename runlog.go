@@ -0,0 +1,177 @@
+package migrate
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runLogTimeout is how long recording a failed run in the run log is given.
+const runLogTimeout = 30 * time.Second
+
+// Run outcomes recorded in the run log.
+const (
+	RunOutcomeSucceeded = "succeeded"
+	RunOutcomeFailed    = "failed"
+)
+
+// ErrRunLogNotSupported is returned when the run log is enabled, or read, but the driver doesn't
+// implement RunLogDriver.
+var ErrRunLogNotSupported = errors.New("migrate: driver does not support a run log")
+
+// RunLogEntry is a single Execute run recorded in the run log.
+type RunLogEntry struct {
+	// RunID uniquely identifies the run, e.g. for correlating it with application logs.
+	RunID      string
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Namespace  string
+	// Versions are the versions committed by the run.
+	Versions []int
+	Outcome  string
+	Duration time.Duration
+	// Operator identifies who ran it, as "user@host".
+	Operator string
+	Host     string
+	// AppVersion is the version of the application that ran it. See WithAppVersion.
+	AppVersion string
+	// Error is the error the run failed with, if it failed.
+	Error string
+}
+
+// RunLog returns every run recorded in the run log for the given namespace, oldest first. See
+// WithRunLog.
+func RunLog(driver Driver, namespace string, ctx context.Context) ([]RunLogEntry, error) {
+	runLog, ok := driver.(RunLogDriver)
+	if !ok {
+		return nil, ErrRunLogNotSupported
+	}
+
+	err := runLog.CreateRunLogTable(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create run log table: %w", err)
+	}
+
+	entries, err := runLog.RunLog(ctx, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read run log: %w", err)
+	}
+
+	return entries, nil
+}
+
+// newRunLogEntry returns a run log entry for a run in the given namespace that's starting now.
+func newRunLogEntry(namespace, appVersion string) RunLogEntry {
+	operator, host := runLogOperator()
+
+	return RunLogEntry{
+		RunID:      newRunID(),
+		StartedAt:  time.Now(),
+		Namespace:  namespace,
+		Operator:   operator,
+		Host:       host,
+		AppVersion: appVersion,
+	}
+}
+
+// finished returns a copy of the entry for the run finishing now, having committed versions.
+func (entry RunLogEntry) finished(versions []int, err error) RunLogEntry {
+	entry.FinishedAt = time.Now()
+	entry.Duration = entry.FinishedAt.Sub(entry.StartedAt)
+	entry.Versions = versions
+	entry.Outcome = RunOutcomeSucceeded
+
+	if err != nil {
+		entry.Outcome = RunOutcomeFailed
+		entry.Error = err.Error()
+	}
+
+	return entry
+}
+
+// recordFailedRun records a failed run in the run log, in its own transaction, as the run's
+// transaction has been rolled back. It has its own timeout, as the run may have failed because its
+// context is done.
+func recordFailedRun(driver Driver, runLog RunLogDriver, entry RunLogEntry) error {
+	ctx, cfn := context.WithTimeout(context.Background(), runLogTimeout)
+	defer cfn()
+
+	err := driver.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	err = runLog.InsertRunLog(ctx, entry)
+	if err != nil {
+		_ = driver.Rollback(ctx)
+		return fmt.Errorf("failed to insert run log entry: %w", err)
+	}
+
+	err = driver.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// newRunID returns a random run ID.
+func newRunID() string {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "unknown"
+	}
+
+	return hex.EncodeToString(id[:])
+}
+
+// runLogOperator returns the current user and host, as "user@host", and the host on its own.
+func runLogOperator() (string, string) {
+	username := "unknown"
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+
+	return username + "@" + host, host
+}
+
+// joinVersions encodes versions for storing in a single text column.
+func joinVersions(versions []int) string {
+	strs := make([]string, len(versions))
+	for i, version := range versions {
+		strs[i] = strconv.Itoa(version)
+	}
+
+	return strings.Join(strs, ",")
+}
+
+// splitVersions decodes versions encoded with joinVersions.
+func splitVersions(s string) ([]int, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var versions []int
+	for _, str := range strings.Split(s, ",") {
+		version, err := strconv.Atoi(str)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version %q: %w", str, err)
+		}
+
+		versions = append(versions, version)
+	}
+
+	return versions, nil
+}
@@ -0,0 +1,53 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// errFakeCommit is returned by commitFailingDriver.Commit.
+var errFakeCommit = errors.New("fake: commit failed")
+
+// commitFailingDriver is a fakeDriver whose transactions always fail to commit.
+type commitFailingDriver struct {
+	*fakeDriver
+}
+
+// Commit ...
+func (d commitFailingDriver) Commit(ctx context.Context) error {
+	if err := d.fakeDriver.Rollback(ctx); err != nil {
+		return err
+	}
+
+	return errFakeCommit
+}
+
+func TestExecuteSavepointCommitError(t *testing.T) {
+	r := NewRegistry()
+	r.Register("default", NewMigration(1, "CREATE TABLE a"))
+	r.Register("default", NewMigration(2, "CREATE TABLE b"))
+
+	driver := newFakeDriver()
+	driver.failOn = "CREATE TABLE b"
+
+	handler, events := ChannelEventHandler()
+
+	err := r.ExecuteContext(context.Background(), commitFailingDriver{driver}, handler, "default", 0, WithSavepoints())
+	if !errors.Is(err, errFakeExec) {
+		t.Fatalf("expected the failed version's error, got %v", err)
+	}
+
+	if !strings.Contains(err.Error(), errFakeCommit.Error()) {
+		t.Errorf("expected the commit error to be reported, got %v", err)
+	}
+
+	if kinds := eventKinds(events); !hasEvent(kinds, EventRollbackError) {
+		t.Errorf("expected a RollbackError event, got %v", kinds)
+	}
+
+	if got := driver.db.committedVersions(); got != nil {
+		t.Errorf("expected no versions to be committed, got %v", got)
+	}
+}
@@ -0,0 +1,122 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestUseSequences(t *testing.T) {
+	const version = 20240101120000
+
+	r := NewRegistry()
+	if err := r.UseSequences("default"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Registered out of order, to check they're applied by version and then sequence.
+	r.Register("default", Migration{Version: version, Sequence: 2, Commands: []string{"CREATE TABLE c"}})
+	r.Register("default", Migration{Version: version + 1, Commands: []string{"CREATE TABLE d"}})
+	r.Register("default", Migration{Version: version, Commands: []string{"CREATE TABLE a"}})
+	r.Register("default", Migration{Version: version, Sequence: 1, Commands: []string{"CREATE TABLE b"}})
+
+	driver := newFakeDriver()
+
+	if err := r.ExecuteContext(context.Background(), driver, NoopEventHandler{}, "default", 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{"CREATE TABLE a", "CREATE TABLE b", "CREATE TABLE c", "CREATE TABLE d"}
+	if !reflect.DeepEqual(driver.db.execs, expected) {
+		t.Errorf("expected commands %q, got %q", expected, driver.db.execs)
+	}
+
+	recorded := []int{version * SequenceLimit, version*SequenceLimit + 1, version*SequenceLimit + 2, (version + 1) * SequenceLimit}
+	if got := driver.db.committedVersions(); !reflect.DeepEqual(got, recorded) {
+		t.Errorf("expected committed versions %v, got %v", recorded, got)
+	}
+}
+
+func TestUseSequencesDuplicates(t *testing.T) {
+	r := NewRegistry()
+	if err := r.UseSequences("default"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	r.Register("default", Migration{Version: 1, Sequence: 1, Commands: []string{"CREATE TABLE a"}})
+
+	// The exact same migration again is fine.
+	if err := r.RegisterE("default", Migration{Version: 1, Sequence: 1, Commands: []string{"CREATE TABLE a"}}); err != nil {
+		t.Errorf("unexpected error registering the same migration again: %v", err)
+	}
+
+	err := r.RegisterE("default", Migration{Version: 1, Sequence: 1, Commands: []string{"CREATE TABLE b"}})
+	if !errors.Is(err, ErrDuplicateVersion) {
+		t.Errorf("expected ErrDuplicateVersion, got %v", err)
+	}
+
+	err = r.RegisterE("default", Migration{Version: 1, Sequence: SequenceLimit, Commands: []string{"CREATE TABLE b"}})
+	if !errors.Is(err, ErrInvalidMigration) {
+		t.Errorf("expected ErrInvalidMigration for a sequence out of range, got %v", err)
+	}
+}
+
+func TestSequenceWithoutUseSequences(t *testing.T) {
+	r := NewRegistry()
+
+	err := r.RegisterE("default", Migration{Version: 1, Sequence: 1, Commands: []string{"CREATE TABLE a"}})
+	if !errors.Is(err, ErrInvalidMigration) {
+		t.Errorf("expected ErrInvalidMigration, got %v", err)
+	}
+
+	r.Register("default", NewMigration(1, "CREATE TABLE a"))
+
+	if err := r.UseSequences("default"); !errors.Is(err, ErrNamespaceRegistered) {
+		t.Errorf("expected ErrNamespaceRegistered, got %v", err)
+	}
+}
+
+func TestRegisterFSSequences(t *testing.T) {
+	fsys := fstest.MapFS{
+		"20240101120000_add_users.up.sql":      {Data: []byte("CREATE TABLE users (id int);")},
+		"20240101120000_add_users.down.sql":    {Data: []byte("DROP TABLE users;")},
+		"20240101120000-1_add_orders.up.sql":   {Data: []byte("CREATE TABLE orders (id int);")},
+		"20240101120000-1_add_orders.down.sql": {Data: []byte("DROP TABLE orders;")},
+	}
+
+	r := NewRegistry()
+	if err := r.UseSequences("default"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := r.RegisterFS("default", fsys); err != nil {
+		t.Fatalf("unexpected error registering: %v", err)
+	}
+
+	const users = 20240101120000 * SequenceLimit
+
+	registered := r.registered("default")
+	if registered[users].Name != "add_users" || registered[users+1].Name != "add_orders" {
+		t.Errorf("expected add_users then add_orders, got %v", registered)
+	}
+
+	if got := registered[users+1].Down; !reflect.DeepEqual(got, []string{"DROP TABLE orders;"}) {
+		t.Errorf("expected add_orders' down commands, got %q", got)
+	}
+
+	// Without sequences, the same files are rejected loudly, rather than ordered arbitrarily.
+	err := NewRegistry().RegisterFS("default", fsys)
+	if !errors.Is(err, ErrInvalidMigration) {
+		t.Errorf("expected ErrInvalidMigration without sequences, got %v", err)
+	}
+
+	err = r.RegisterFS("other", fstest.MapFS{
+		"1-1.sql":   {Data: []byte("CREATE TABLE a (id int);")},
+		"001-1.sql": {Data: []byte("CREATE TABLE b (id int);")},
+	})
+	if !errors.Is(err, ErrDuplicateVersion) {
+		t.Errorf("expected ErrDuplicateVersion for the same version and sequence, got %v", err)
+	}
+}
@@ -0,0 +1,167 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"sort"
+	"sync"
+)
+
+// Source is a backend that migrations can be loaded from with RegisterFromSource, e.g. a config
+// service, object storage, or a git repository.
+type Source interface {
+	// List returns the versions of every migration in the source.
+	List(ctx context.Context) ([]int, error)
+	// Get returns the migration with the given version.
+	Get(ctx context.Context, version int) (Migration, error)
+}
+
+// RegisterFromSource loads every migration from the given source, and registers them in the given
+// namespace, all at once, or not at all if loading or registering any of them fails. Loading
+// stops if the context is done.
+func RegisterFromSource(ctx context.Context, namespace string, src Source) error {
+	return defaultRegistry.RegisterFromSource(ctx, namespace, src)
+}
+
+// RegisterFromSource is like the package-level RegisterFromSource, but uses this Registry.
+func (r *Registry) RegisterFromSource(ctx context.Context, namespace string, src Source) error {
+	versions, err := src.List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list migrations: %w", err)
+	}
+
+	migrations := make([]Migration, 0, len(versions))
+
+	for _, version := range versions {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		migration, err := src.Get(ctx, version)
+		if err != nil {
+			return fmt.Errorf("failed to get migration %d: %w", version, err)
+		}
+
+		if migration.Version != version {
+			return fmt.Errorf("migration %d: source returned version %d: %w", version, migration.Version, ErrInvalidMigration)
+		}
+
+		migrations = append(migrations, migration)
+	}
+
+	return r.register(namespace, migrations)
+}
+
+// fsSource is a Source backed by a filesystem.
+type fsSource struct {
+	in   fs.FS
+	opts fsOptions
+
+	once                sync.Once
+	migrationsByVersion Migrations
+	err                 error
+}
+
+// NewFSSource returns a Source that reads migrations from the given filesystem, in the same way
+// as RegisterFS, except that more than one file for a version with different sequences is
+// rejected, as a Source is keyed by version alone. The filesystem is read once, the first time
+// the source is used.
+func NewFSSource(in fs.FS, opts ...FSOption) Source {
+	src := &fsSource{in: in}
+	for _, opt := range opts {
+		opt(&src.opts)
+	}
+
+	return src
+}
+
+// List ...
+func (s *fsSource) List(ctx context.Context) ([]int, error) {
+	migrationsByVersion, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	return sortedVersions(migrationsByVersion), nil
+}
+
+// Get ...
+func (s *fsSource) Get(ctx context.Context, version int) (Migration, error) {
+	migrationsByVersion, err := s.read()
+	if err != nil {
+		return Migration{}, err
+	}
+
+	migration, ok := migrationsByVersion[version]
+	if !ok {
+		return Migration{}, fmt.Errorf("version %d: %w", version, fs.ErrNotExist)
+	}
+
+	return migration, nil
+}
+
+// read reads the filesystem, if it hasn't been already.
+func (s *fsSource) read() (Migrations, error) {
+	s.once.Do(func() {
+		migrations, err := readFS(s.in, s.opts)
+		if err != nil {
+			s.err = err
+			return
+		}
+
+		// Sources are keyed by version alone, so sequences can't be told apart; use RegisterFS.
+		s.migrationsByVersion = make(Migrations, len(migrations))
+		for _, migration := range migrations {
+			if _, ok := s.migrationsByVersion[migration.Version]; ok {
+				s.err = fmt.Errorf("version %d: sequences aren't supported by sources: %w", migration.Version, ErrDuplicateVersion)
+				return
+			}
+
+			s.migrationsByVersion[migration.Version] = migration
+		}
+	})
+
+	return s.migrationsByVersion, s.err
+}
+
+// staticSource is a Source backed by a fixed set of migrations.
+type staticSource Migrations
+
+// NewStaticSource returns a Source containing the given migrations, e.g. as a stand-in for a
+// remote source in tests.
+func NewStaticSource(migrations ...Migration) Source {
+	src := make(staticSource, len(migrations))
+	for _, migration := range migrations {
+		src[migration.Version] = migration
+	}
+
+	return src
+}
+
+// List ...
+func (s staticSource) List(ctx context.Context) ([]int, error) {
+	return sortedVersions(Migrations(s)), nil
+}
+
+// Get ...
+func (s staticSource) Get(ctx context.Context, version int) (Migration, error) {
+	migration, ok := s[version]
+	if !ok {
+		return Migration{}, fmt.Errorf("version %d: %w", version, fs.ErrNotExist)
+	}
+
+	return migration, nil
+}
+
+// sortedVersions returns the versions of the given migrations, in ascending order.
+func sortedVersions(migrationsByVersion Migrations) []int {
+	versions := make([]int, 0, len(migrationsByVersion))
+	for version := range migrationsByVersion {
+		versions = append(versions, version)
+	}
+
+	sort.Ints(versions)
+
+	return versions
+}
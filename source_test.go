@@ -0,0 +1,139 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+// recordingSource is a Source that wraps another, recording the versions it's asked for, and
+// optionally failing, or calling a function, when a given version is fetched.
+type recordingSource struct {
+	Source
+
+	got    []int
+	failOn int
+	err    error
+	onGet  func(version int)
+}
+
+// Get ...
+func (s *recordingSource) Get(ctx context.Context, version int) (Migration, error) {
+	s.got = append(s.got, version)
+
+	if s.onGet != nil {
+		s.onGet(version)
+	}
+
+	if version == s.failOn {
+		return Migration{}, s.err
+	}
+
+	return s.Source.Get(ctx, version)
+}
+
+func newTestSource() *recordingSource {
+	return &recordingSource{Source: NewStaticSource(
+		NewMigration(3, "CREATE TABLE c"),
+		NewMigration(1, "CREATE TABLE a"),
+		NewMigration(2, "CREATE TABLE b"),
+	)}
+}
+
+func TestRegisterFromSource(t *testing.T) {
+	src := newTestSource()
+	r := NewRegistry()
+
+	if err := r.RegisterFromSource(context.Background(), "default", src); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if versions, _ := src.List(context.Background()); !reflect.DeepEqual(versions, []int{1, 2, 3}) {
+		t.Errorf("expected List to return versions in order, got %v", versions)
+	}
+
+	if !reflect.DeepEqual(src.got, []int{1, 2, 3}) {
+		t.Errorf("expected migrations to be fetched in version order, got %v", src.got)
+	}
+
+	if got := sortedVersions(r.registered("default")); !reflect.DeepEqual(got, []int{1, 2, 3}) {
+		t.Errorf("expected versions [1 2 3] to be registered, got %v", got)
+	}
+}
+
+func TestRegisterFromSourceGetError(t *testing.T) {
+	errGet := errors.New("get failed")
+
+	src := newTestSource()
+	src.failOn, src.err = 2, errGet
+
+	r := NewRegistry()
+
+	err := r.RegisterFromSource(context.Background(), "default", src)
+	if !errors.Is(err, errGet) {
+		t.Fatalf("expected the Get error to be returned, got %v", err)
+	}
+
+	if !reflect.DeepEqual(src.got, []int{1, 2}) {
+		t.Errorf("expected fetching to stop at the failed version, got %v", src.got)
+	}
+
+	if got := r.registered("default"); got != nil {
+		t.Errorf("expected nothing to be registered, got %v", got)
+	}
+}
+
+func TestRegisterFromSourceCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	src := newTestSource()
+	src.onGet = func(version int) {
+		if version == 2 {
+			cancel()
+		}
+	}
+
+	r := NewRegistry()
+
+	err := r.RegisterFromSource(ctx, "default", src)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	if !reflect.DeepEqual(src.got, []int{1, 2}) {
+		t.Errorf("expected fetching to stop once cancelled, got %v", src.got)
+	}
+
+	if got := r.registered("default"); got != nil {
+		t.Errorf("expected nothing to be registered, got %v", got)
+	}
+}
+
+func TestNewFSSourceMatchesRegisterFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"1_init.sql":      {Data: []byte("CREATE TABLE a (id int);")},
+		"2_next.up.sql":   {Data: []byte("CREATE TABLE b (id int);")},
+		"2_next.down.sql": {Data: []byte("DROP TABLE b;")},
+	}
+
+	fromFS := NewRegistry()
+	if err := fromFS.RegisterFS("default", fsys); err != nil {
+		t.Fatalf("unexpected error registering from filesystem: %v", err)
+	}
+
+	if got := len(fromFS.registered("default")); got != 2 {
+		t.Fatalf("expected 2 migrations to be registered from the filesystem, got %d", got)
+	}
+
+	fromSource := NewRegistry()
+	if err := fromSource.RegisterFromSource(context.Background(), "default", NewFSSource(fsys)); err != nil {
+		t.Fatalf("unexpected error registering from source: %v", err)
+	}
+
+	if expected, got := fromFS.registered("default"), fromSource.registered("default"); !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
@@ -0,0 +1,246 @@
+package migrate
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+)
+
+// errEmptyDelimiter is returned when a MySQL DELIMITER command doesn't give a delimiter.
+var errEmptyDelimiter = errors.New("DELIMITER must be followed by a delimiter")
+
+// statementScanner reads SQL statements one at a time from a stream, splitting on semicolons that
+// aren't inside of quotes, comments, or Postgres dollar-quoted strings. Only one statement is held
+// in memory at a time, so arbitrarily large files can be executed.
+type statementScanner struct {
+	r    *bufio.Reader
+	buf  bytes.Buffer
+	stmt string
+	err  error
+
+	// delimiter ends each statement. It's only changed by MySQL DELIMITER commands, e.g. so that
+	// the semicolons in a stored procedure's body don't end it.
+	delimiter string
+
+	// mysql is true if backslashes escape the next character in quoted strings, and DELIMITER
+	// commands are recognised, as in MySQL's client, rather than dollar-quoted strings. In
+	// Postgres, backslashes are only escapes in E'' strings, which is not handled.
+	mysql bool
+}
+
+// newStatementScanner returns a new statementScanner reading from r, splitting statements as the
+// given dialect's client would. Anything other than DialectMySQL is split like Postgres.
+func newStatementScanner(r io.Reader, dialect string) *statementScanner {
+	return &statementScanner{
+		r:         bufio.NewReader(r),
+		delimiter: ";",
+		mysql:     dialect == DialectMySQL,
+	}
+}
+
+// splitStatements splits sql into its statements, for the given dialect. See statementScanner.
+func splitStatements(sql, dialect string) ([]string, error) {
+	var statements []string
+
+	scanner := newStatementScanner(strings.NewReader(sql), dialect)
+	for scanner.Scan() {
+		statements = append(statements, scanner.Statement())
+	}
+
+	return statements, scanner.Err()
+}
+
+// Scan reads the next statement, returning false when there are no more statements, or reading
+// failed. Err should be checked afterwards.
+func (s *statementScanner) Scan() bool {
+	for {
+		stmt, err := s.next()
+		if err != nil && err != io.EOF {
+			s.err = err
+			return false
+		}
+
+		stmt = strings.TrimSpace(stmt)
+		if stmt != "" {
+			s.stmt = stmt
+			return true
+		}
+
+		if err == io.EOF {
+			return false
+		}
+	}
+}
+
+// Statement returns the statement read by the last call to Scan.
+func (s *statementScanner) Statement() string {
+	return s.stmt
+}
+
+// Err returns the first non-EOF error encountered while scanning.
+func (s *statementScanner) Err() error {
+	return s.err
+}
+
+// next reads up to and including the next top-level delimiter, returning the statement without
+// it. At the end of the input, whatever remains is returned along with io.EOF.
+func (s *statementScanner) next() (string, error) {
+	s.buf.Reset()
+
+	for {
+		c, err := s.r.ReadByte()
+		if err != nil {
+			return s.buf.String(), err
+		}
+
+		switch {
+		case s.mysql && (c == 'D' || c == 'd') && s.atDelimiterCommand():
+			err = s.readDelimiter()
+		case c == s.delimiter[0] && s.peek(s.delimiter[1:]):
+			_, _ = s.r.Discard(len(s.delimiter) - 1)
+			return s.buf.String(), nil
+		case c == '\'' || c == '"' || c == '`':
+			s.buf.WriteByte(c)
+			err = s.quoted(c)
+		case c == '-' && s.peek("-"):
+			s.buf.WriteByte(c)
+			s.buf.WriteByte(s.discard())
+			err = s.until("\n")
+		case c == '/' && s.peek("*"):
+			s.buf.WriteByte(c)
+			s.buf.WriteByte(s.discard())
+			err = s.until("*/")
+		case c == '$' && !s.mysql:
+			s.buf.WriteByte(c)
+			err = s.dollarQuoted()
+		default:
+			s.buf.WriteByte(c)
+		}
+
+		if err != nil {
+			return s.buf.String(), err
+		}
+	}
+}
+
+// atDelimiterCommand returns true if the 'D' just read starts a MySQL DELIMITER command, which
+// must be the first thing in a statement.
+func (s *statementScanner) atDelimiterCommand() bool {
+	if strings.TrimSpace(s.buf.String()) != "" {
+		return false
+	}
+
+	bs, _ := s.r.Peek(len("ELIMITER "))
+	if len(bs) < len("ELIMITER ") {
+		return false
+	}
+
+	return strings.EqualFold(string(bs[:8]), "ELIMITER") && (bs[8] == ' ' || bs[8] == '\t')
+}
+
+// readDelimiter reads the rest of a DELIMITER command, changing the delimiter to the one given.
+// The command isn't part of any statement, so it's discarded.
+func (s *statementScanner) readDelimiter() error {
+	line, err := s.r.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	delimiter := strings.TrimSpace(line[len("ELIMITER "):])
+	if delimiter == "" {
+		return errEmptyDelimiter
+	}
+
+	s.delimiter = delimiter
+	s.buf.Reset()
+
+	return err
+}
+
+// peek returns true if the next bytes in the input are prefix.
+func (s *statementScanner) peek(prefix string) bool {
+	bs, _ := s.r.Peek(len(prefix))
+	return string(bs) == prefix
+}
+
+// quoted reads the remainder of a string quoted with the given quote character.
+func (s *statementScanner) quoted(quote byte) error {
+	for {
+		c, err := s.r.ReadByte()
+		if err != nil {
+			return err
+		}
+
+		s.buf.WriteByte(c)
+
+		if c == '\\' && s.mysql {
+			c, err = s.r.ReadByte()
+			if err != nil {
+				return err
+			}
+
+			s.buf.WriteByte(c)
+			continue
+		}
+
+		// Doubled quotes are handled naturally, as the end of one string and the start of another.
+		if c == quote {
+			return nil
+		}
+	}
+}
+
+// discard reads and returns the next byte, which must already have been peeked.
+func (s *statementScanner) discard() byte {
+	c, _ := s.r.ReadByte()
+	return c
+}
+
+// until reads up to and including the given terminator.
+func (s *statementScanner) until(terminator string) error {
+	start := s.buf.Len()
+
+	for !bytes.HasSuffix(s.buf.Bytes()[start:], []byte(terminator)) {
+		c, err := s.r.ReadByte()
+		if err != nil {
+			return err
+		}
+
+		s.buf.WriteByte(c)
+	}
+
+	return nil
+}
+
+// dollarQuoted reads a Postgres dollar-quoted string, e.g. $$...$$ or $body$...$body$, if the '$'
+// just read starts one. Otherwise it's something else, like a positional parameter, and nothing
+// more is read.
+func (s *statementScanner) dollarQuoted() error {
+	tag := "$"
+
+	for i := 1; ; i++ {
+		bs, err := s.r.Peek(i)
+		if err != nil || len(bs) < i {
+			return nil
+		}
+
+		c := bs[i-1]
+		if c == '$' {
+			tag += string(bs)
+			break
+		}
+
+		isLetter := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+		isDigit := c >= '0' && c <= '9'
+		if !isLetter && !(isDigit && i > 1) {
+			return nil
+		}
+	}
+
+	_, _ = s.r.Discard(len(tag) - 1)
+	s.buf.WriteString(tag[1:])
+
+	return s.until(tag)
+}
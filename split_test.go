@@ -0,0 +1,122 @@
+package migrate
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name     string
+		dialect  string
+		sql      string
+		expected []string
+	}{
+		{
+			name:     "simple",
+			dialect:  DialectPostgres,
+			sql:      "CREATE TABLE a (id int);\nCREATE TABLE b (id int);\n",
+			expected: []string{"CREATE TABLE a (id int)", "CREATE TABLE b (id int)"},
+		},
+		{
+			name:     "no trailing semicolon",
+			dialect:  DialectPostgres,
+			sql:      "SELECT 1;\nSELECT 2",
+			expected: []string{"SELECT 1", "SELECT 2"},
+		},
+		{
+			name:     "empty statements",
+			dialect:  DialectPostgres,
+			sql:      ";;SELECT 1;;\n;",
+			expected: []string{"SELECT 1"},
+		},
+		{
+			name:     "quotes",
+			dialect:  DialectPostgres,
+			sql:      `INSERT INTO a VALUES ('x;y', 'it''s;'); SELECT "weird;name" FROM b;`,
+			expected: []string{`INSERT INTO a VALUES ('x;y', 'it''s;')`, `SELECT "weird;name" FROM b`},
+		},
+		{
+			name:     "comments",
+			dialect:  DialectPostgres,
+			sql:      "SELECT 1; -- not; a statement\nSELECT 2 /* nor; this */;",
+			expected: []string{"SELECT 1", "-- not; a statement\nSELECT 2 /* nor; this */"},
+		},
+		{
+			name:    "dollar quoting",
+			dialect: DialectPostgres,
+			sql: "CREATE FUNCTION f() RETURNS int AS $$ BEGIN RETURN 1; END; $$ LANGUAGE plpgsql;\n" +
+				"CREATE FUNCTION g() RETURNS int AS $body$ SELECT 1; $body$ LANGUAGE sql;",
+			expected: []string{
+				"CREATE FUNCTION f() RETURNS int AS $$ BEGIN RETURN 1; END; $$ LANGUAGE plpgsql",
+				"CREATE FUNCTION g() RETURNS int AS $body$ SELECT 1; $body$ LANGUAGE sql",
+			},
+		},
+		{
+			name:     "positional parameters aren't dollar quotes",
+			dialect:  DialectPostgres,
+			sql:      "PREPARE p AS SELECT $1; SELECT 2;",
+			expected: []string{"PREPARE p AS SELECT $1", "SELECT 2"},
+		},
+		{
+			// The database reports the error, so the rest of the input is left as one statement.
+			name:     "unterminated quote",
+			dialect:  DialectPostgres,
+			sql:      "SELECT 1; SELECT 'a; SELECT 2;",
+			expected: []string{"SELECT 1", "SELECT 'a; SELECT 2;"},
+		},
+		{
+			name:     "unterminated dollar quote",
+			dialect:  DialectPostgres,
+			sql:      "SELECT 1; SELECT $$ a; b;",
+			expected: []string{"SELECT 1", "SELECT $$ a; b;"},
+		},
+		{
+			name:     "mysql backslash escapes",
+			dialect:  DialectMySQL,
+			sql:      `INSERT INTO a VALUES ('x\';y'); SELECT 2;`,
+			expected: []string{`INSERT INTO a VALUES ('x\';y')`, "SELECT 2"},
+		},
+		{
+			name:     "mysql dollars aren't quotes",
+			dialect:  DialectMySQL,
+			sql:      "SELECT 'a$$'; SELECT $$;",
+			expected: []string{"SELECT 'a$$'", "SELECT $$"},
+		},
+		{
+			name:    "mysql delimiter blocks",
+			dialect: DialectMySQL,
+			sql: "CREATE TABLE a (id int);\n" +
+				"DELIMITER //\n" +
+				"CREATE PROCEDURE p() BEGIN SELECT 1; SELECT 2; END//\n" +
+				"DELIMITER ;\n" +
+				"CREATE TABLE b (id int);",
+			expected: []string{
+				"CREATE TABLE a (id int)",
+				"CREATE PROCEDURE p() BEGIN SELECT 1; SELECT 2; END",
+				"CREATE TABLE b (id int)",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			statements, err := splitStatements(test.sql, test.dialect)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(statements, test.expected) {
+				t.Errorf("expected %q, got %q", test.expected, statements)
+			}
+		})
+	}
+}
+
+func TestSplitStatementsEmptyDelimiter(t *testing.T) {
+	_, err := splitStatements("DELIMITER \nSELECT 1;", DialectMySQL)
+	if !errors.Is(err, errEmptyDelimiter) {
+		t.Errorf("expected errEmptyDelimiter, got %v", err)
+	}
+}
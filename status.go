@@ -0,0 +1,113 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// SchemaStatus is the state of a namespace returned by Status, e.g. for reporting on a health or
+// status endpoint.
+type SchemaStatus struct {
+	Namespace string `json:"namespace"`
+	// Current is the highest applied version, or 0 if none have been applied.
+	Current int `json:"current"`
+	// Applied contains every recorded version, in ascending order.
+	Applied []AppliedVersion `json:"applied"`
+	// Pending contains the registered versions that haven't been applied yet, in the order Execute
+	// would apply them.
+	Pending []int `json:"pending"`
+}
+
+// AppliedVersion is a version recorded in the versions table.
+type AppliedVersion struct {
+	Version int `json:"version"`
+	// MigratedAt is when the version was recorded, or the zero time if the driver doesn't implement
+	// AppliedAtReader.
+	MigratedAt time.Time `json:"migrated_at"`
+}
+
+// Status returns the applied and pending versions of the given namespace, without applying
+// anything or locking the versions table. See Observe.
+func Status(ctx context.Context, driver Driver, namespace string) (SchemaStatus, error) {
+	return defaultRegistry.Status(ctx, driver, namespace)
+}
+
+// Status is like the package-level Status, but uses this Registry.
+func (r *Registry) Status(ctx context.Context, driver Driver, namespace string) (SchemaStatus, error) {
+	state, err := r.Observe(driver, namespace, ctx)
+	if err != nil {
+		return SchemaStatus{}, err
+	}
+
+	var appliedAt map[int]time.Time
+	if reader, ok := driver.(AppliedAtReader); ok && len(state.Applied) > 0 {
+		appliedAt, err = reader.AppliedAt(ctx)
+		if err != nil {
+			return SchemaStatus{}, fmt.Errorf("failed to get applied times: %w", err)
+		}
+	}
+
+	status := SchemaStatus{
+		Namespace: namespace,
+		Current:   state.Current(),
+		Applied:   make([]AppliedVersion, 0, len(state.Applied)),
+		Pending:   make([]int, 0, len(state.Pending)),
+	}
+
+	for _, version := range state.Applied {
+		status.Applied = append(status.Applied, AppliedVersion{
+			Version:    version,
+			MigratedAt: appliedAt[version],
+		})
+	}
+
+	for _, migration := range state.Pending {
+		status.Pending = append(status.Pending, migration.Version)
+	}
+
+	return status, nil
+}
+
+// SchemaVersionError is returned by EnsureVersion when the database hasn't been migrated to the
+// required version yet.
+type SchemaVersionError struct {
+	Namespace string
+	// Current is the highest applied version, or 0 if none have been applied.
+	Current int
+	// Required is the minimum version that was required.
+	Required int
+}
+
+// Error returns the error message, including the current and required versions.
+func (e *SchemaVersionError) Error() string {
+	return fmt.Sprintf("migrate: namespace %q is at version %d, but version %d is required", e.Namespace, e.Current, e.Required)
+}
+
+// EnsureVersion returns a *SchemaVersionError if the highest version applied to the database is
+// lower than minVersion, without applying anything or locking the versions table. It's for
+// services that don't run migrations themselves, so they can refuse to start against a schema
+// that's out of date. Nothing needs to be registered; namespace is only used in the error.
+func EnsureVersion(ctx context.Context, driver Driver, namespace string, minVersion int) error {
+	applied, err := AppliedVersions(driver, ctx)
+	if err != nil {
+		return err
+	}
+
+	var current int
+	for _, version := range applied {
+		if version > current {
+			current = version
+		}
+	}
+
+	if current < minVersion {
+		return &SchemaVersionError{
+			Namespace: namespace,
+			Current:   current,
+			Required:  minVersion,
+		}
+	}
+
+	return nil
+}
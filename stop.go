@@ -0,0 +1,28 @@
+package migrate
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// ErrStopped is returned when a graceful stop was requested, and the run stopped before applying
+// every pending version. See WithGracefulStop and WithStopOnSignal.
+var ErrStopped = errors.New("migrate: stopped before applying every pending version")
+
+// defaultStopSignals are the signals WithStopOnSignal stops on if none are given, which are those
+// sent to stop a process by Kubernetes, systemd, and a terminal.
+var defaultStopSignals = []os.Signal{syscall.SIGTERM, os.Interrupt}
+
+// stopRequested returns true if any of the stop channels has been closed.
+func (o *options) stopRequested() bool {
+	for _, stop := range o.stops {
+		select {
+		case <-stop:
+			return true
+		default:
+		}
+	}
+
+	return false
+}
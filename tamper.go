@@ -0,0 +1,150 @@
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// versionsSignatureKey is the metadata key the versions table signature is stored under.
+const versionsSignatureKey = "versions_signature"
+
+var (
+	// ErrVersionTableTampered is returned when tamper detection is enabled, and the versions table
+	// no longer matches the signature recorded at the end of the last run.
+	ErrVersionTableTampered = errors.New("migrate: versions table modified outside of migrate")
+	// ErrMetadataNotSupported is returned when an option requires storing metadata, but the driver
+	// doesn't implement MetadataDriver.
+	ErrMetadataNotSupported = errors.New("migrate: driver does not support metadata")
+)
+
+// versionsSignature returns a signature of the given set of versions, which changes if any version
+// is added or removed.
+func versionsSignature(versions []int) string {
+	sorted := make([]int, len(versions))
+	copy(sorted, versions)
+	sort.Ints(sorted)
+
+	var sb strings.Builder
+	sb.WriteString(strconv.Itoa(len(sorted)))
+	sb.WriteString(":")
+
+	for i, version := range sorted {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+
+		sb.WriteString(strconv.Itoa(version))
+	}
+
+	sum := sha256.Sum256([]byte(sb.String()))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// verifyVersionsSignature checks the given versions against the stored signature. If there is no
+// stored signature yet (i.e. tamper detection was only just enabled) then there is nothing to
+// verify against.
+func verifyVersionsSignature(ctx context.Context, metadata MetadataDriver, versions []int) error {
+	stored, err := metadata.Metadata(ctx, versionsSignatureKey)
+	if err != nil {
+		return fmt.Errorf("failed to get versions signature: %w", err)
+	}
+
+	if stored != "" && stored != versionsSignature(versions) {
+		return ErrVersionTableTampered
+	}
+
+	return nil
+}
+
+// updateVersionsSignature stores the signature of the versions currently in the versions table.
+// This must be called in the same transaction as any change to the versions table.
+func updateVersionsSignature(ctx context.Context, driver Driver, metadata MetadataDriver) error {
+	versions, err := driver.Versions(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get current versions: %w", err)
+	}
+
+	err = metadata.SetMetadata(ctx, versionsSignatureKey, versionsSignature(versions))
+	if err != nil {
+		return fmt.Errorf("failed to set versions signature: %w", err)
+	}
+
+	return nil
+}
+
+// signedMetadata returns the driver's MetadataDriver if a versions table signature may be stored in
+// it, or nil if tamper detection has never been used, so that operations outside of Execute only
+// touch the metadata table if there's a signature to keep up to date. If the driver can't check
+// whether its metadata table exists, it's created, so that a signature can be looked for.
+func signedMetadata(ctx context.Context, driver Driver) (MetadataDriver, error) {
+	metadata, ok := driver.(MetadataDriver)
+	if !ok {
+		return nil, nil
+	}
+
+	checker, ok := driver.(MetadataTableChecker)
+	if !ok {
+		err := metadata.CreateMetadataTable(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create metadata table: %w", err)
+		}
+
+		return metadata, nil
+	}
+
+	exists, err := checker.MetadataTableExists(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check if metadata table exists: %w", err)
+	}
+
+	if !exists {
+		return nil, nil
+	}
+
+	return metadata, nil
+}
+
+// refreshVersionsSignature updates the stored versions table signature, if there is one. This
+// allows migrate itself to modify the versions table without it being detected as tampering.
+func refreshVersionsSignature(ctx context.Context, driver Driver, metadata MetadataDriver) error {
+	stored, err := metadata.Metadata(ctx, versionsSignatureKey)
+	if err != nil {
+		return fmt.Errorf("failed to get versions signature: %w", err)
+	}
+
+	if stored == "" {
+		return nil
+	}
+
+	return updateVersionsSignature(ctx, driver, metadata)
+}
+
+// refreshNamespaceSignatures updates the stored versions table signature, if there is one, of each
+// of the given drivers, which are bound to different namespaces within the same transaction, e.g.
+// after versions have been moved from one namespace to another.
+func refreshNamespaceSignatures(ctx context.Context, drivers ...Driver) error {
+	for _, driver := range drivers {
+		metadata, err := signedMetadata(ctx, driver)
+		if err != nil {
+			return err
+		}
+
+		if metadata == nil {
+			continue
+		}
+
+		err = refreshVersionsSignature(ctx, driver, metadata)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
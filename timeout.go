@@ -0,0 +1,49 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrMigrationTimeout is returned when a version or command takes longer than its timeout. See
+// WithVersionTimeout and WithCommandTimeout.
+var ErrMigrationTimeout = errors.New("migrate: migration timed out")
+
+// withTimeout returns a copy of ctx with the given timeout, if it's positive, or ctx otherwise.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// versionTimeout returns the timeout for applying the given migration, which is its own Timeout if
+// it has one, and the default set by WithVersionTimeout otherwise.
+func (o *options) versionTimeout(migration Migration) time.Duration {
+	if migration.Timeout > 0 {
+		return migration.Timeout
+	}
+
+	return o.defaultVersionTimeout
+}
+
+// timeoutError returns err wrapped with ErrMigrationTimeout, naming the timeout that expired, if
+// it was caused by versionCtx or commandCtx timing out, rather than the run's own ctx being done.
+// Otherwise, err is returned unchanged.
+func timeoutError(ctx, versionCtx, commandCtx context.Context, versionTimeout, commandTimeout time.Duration, err error) error {
+	if ctx.Err() != nil {
+		return err
+	}
+
+	switch {
+	case versionCtx.Err() == context.DeadlineExceeded:
+		return fmt.Errorf("%w: version took longer than %s: %v", ErrMigrationTimeout, versionTimeout, err)
+	case commandCtx.Err() == context.DeadlineExceeded:
+		return fmt.Errorf("%w: command took longer than %s: %v", ErrMigrationTimeout, commandTimeout, err)
+	}
+
+	return err
+}
@@ -0,0 +1,269 @@
+package migrate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// directionUp is the direction of migration files containing the commands to apply.
+	directionUp = "up"
+	// directionDown is the direction of migration files containing the commands to revert.
+	directionDown = "down"
+)
+
+// errNotMigration is returned by parseFilename for files that should be ignored.
+var errNotMigration = errors.New("not a migration file")
+
+// parseFilename parses the version, sequence, name, and direction from the path of a migration
+// file. Migration files are named "<version>.sql", or "<version>.up.sql" and "<version>.down.sql"
+// for migrations that can be reverted. The version may be followed by a hyphen and a sequence (see
+// Migration.Sequence), and then an underscore and a name, e.g. "0003_add_users_table.sql" or
+// "20240101120000-2_add_orders.sql". Files that aren't SQL files return errNotMigration.
+func parseFilename(path string) (int, int, string, string, error) {
+	ext := filepath.Ext(path)
+	if strings.ToLower(ext) != ".sql" {
+		return 0, 0, "", "", errNotMigration
+	}
+
+	name := strings.TrimSuffix(filepath.Base(path), ext)
+	direction := directionUp
+
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".up":
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	case ".down":
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+		direction = directionDown
+	}
+
+	name, description := splitName(name)
+
+	version, sequence, err := parseVersion(name)
+	if err != nil {
+		return 0, 0, "", "", err
+	}
+
+	return version, sequence, description, direction, nil
+}
+
+// parseVersion parses the version, and the sequence if any, from the start of a migration file or
+// directory's name, e.g. "0003" or "20240101120000-2".
+func parseVersion(s string) (int, int, error) {
+	versionStr, sequenceStr := s, ""
+	if i := strings.Index(s, "-"); i > 0 {
+		versionStr, sequenceStr = s[:i], s[i+1:]
+	}
+
+	// Get the version name, it must be an int
+	version, err := strconv.Atoi(versionStr)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to parse filename as int: %w", err)
+	}
+
+	if versionStr == s {
+		return version, 0, nil
+	}
+
+	sequence, err := strconv.Atoi(sequenceStr)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to parse sequence as int: %w", err)
+	}
+
+	return version, sequence, nil
+}
+
+// describeVersion returns a description of the given version and sequence for error messages,
+// e.g. "version 3", or "version 20240101120000 sequence 2".
+func describeVersion(version, sequence int) string {
+	if sequence == 0 {
+		return fmt.Sprintf("version %d", version)
+	}
+
+	return fmt.Sprintf("version %d sequence %d", version, sequence)
+}
+
+// splitName splits a migration file's name (without extensions) into its version and name, at the
+// first underscore. The name is empty if there is no underscore.
+func splitName(base string) (string, string) {
+	if i := strings.Index(base, "_"); i >= 0 {
+		return base[:i], base[i+1:]
+	}
+
+	return base, ""
+}
+
+// ValidationIssue is a problem found with a migration file by ValidateFS.
+type ValidationIssue struct {
+	Path    string
+	Message string
+}
+
+// String returns the issue as a human-readable string.
+func (i ValidationIssue) String() string {
+	return fmt.Sprintf("%s: %s", i.Path, i.Message)
+}
+
+// ValidateOption configures optional behaviour of ValidateFS.
+type ValidateOption func(*validateOptions)
+
+// validateOptions holds the configuration built up from ValidateOption values.
+type validateOptions struct {
+	checkSQL      bool
+	checkEncoding bool
+}
+
+// WithEncodingCheck makes ValidateFS also report files that start with a UTF-8 byte order mark,
+// or that aren't valid UTF-8. RegisterFS strips byte order marks, but they're still best removed,
+// as other tools may not.
+func WithEncodingCheck() ValidateOption {
+	return func(o *validateOptions) {
+		o.checkEncoding = true
+	}
+}
+
+// WithSQLCheck makes ValidateFS also check each file for obviously malformed SQL, i.e. unbalanced
+// parentheses, or unterminated quotes or comments. It doesn't parse the SQL.
+func WithSQLCheck() ValidateOption {
+	return func(o *validateOptions) {
+		o.checkSQL = true
+	}
+}
+
+// ValidateFS statically checks the migration files in the given filesystem, without needing a
+// database connection, e.g. for use in CI or pre-commit hooks. It checks that filenames can be
+// parsed, that no version is defined more than once, that no file is empty, and that every down
+// file has a matching up file. Any problems found are returned as issues; an error is only
+// returned if the filesystem couldn't be read.
+func ValidateFS(fsys fs.FS, opts ...ValidateOption) ([]ValidationIssue, error) {
+	var o validateOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var issues []ValidationIssue
+
+	// Paths of the files seen for each version and direction, for finding duplicates and pairs.
+	seen := make(map[string]string)
+	var downs []string
+
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		version, sequence, _, direction, err := parseFilename(path)
+		if errors.Is(err, errNotMigration) {
+			return nil
+		}
+
+		if err != nil {
+			issues = append(issues, ValidationIssue{Path: path, Message: err.Error()})
+			return nil
+		}
+
+		key := fmt.Sprintf("%d-%d.%s", version, sequence, direction)
+		if other, ok := seen[key]; ok {
+			issues = append(issues, ValidationIssue{
+				Path:    path,
+				Message: fmt.Sprintf("%s is also defined by %s", describeVersion(version, sequence), other),
+			})
+		} else {
+			seen[key] = path
+		}
+
+		if direction == directionDown {
+			downs = append(downs, key)
+		}
+
+		bs, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return fmt.Errorf("failed to read file: %w", err)
+		}
+
+		if o.checkEncoding {
+			if msg := checkEncoding(bs); msg != "" {
+				issues = append(issues, ValidationIssue{Path: path, Message: msg})
+			}
+		}
+
+		if len(bytes.TrimSpace(normalizeFile(bs))) == 0 {
+			issues = append(issues, ValidationIssue{Path: path, Message: "file is empty"})
+			return nil
+		}
+
+		if o.checkSQL {
+			if msg := checkSQL(string(bs)); msg != "" {
+				issues = append(issues, ValidationIssue{Path: path, Message: msg})
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range downs {
+		up := strings.TrimSuffix(key, directionDown) + directionUp
+		if _, ok := seen[up]; !ok {
+			issues = append(issues, ValidationIssue{Path: seen[key], Message: "down file has no matching up file"})
+		}
+	}
+
+	return issues, nil
+}
+
+// checkSQL looks for obvious problems in the given SQL, returning a description of the first one
+// found, or an empty string if there are none.
+func checkSQL(sql string) string {
+	depth := 0
+
+	for i := 0; i < len(sql); i++ {
+		switch {
+		case sql[i] == '\'' || sql[i] == '"' || sql[i] == '`':
+			end := strings.IndexByte(sql[i+1:], sql[i])
+			if end < 0 {
+				return fmt.Sprintf("unterminated %c quote", sql[i])
+			}
+
+			i += end + 1
+		case strings.HasPrefix(sql[i:], "--"):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				return ""
+			}
+
+			i += end
+		case strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				return "unterminated block comment"
+			}
+
+			i += end + 3
+		case sql[i] == '(':
+			depth++
+		case sql[i] == ')':
+			depth--
+			if depth < 0 {
+				return "unbalanced parentheses"
+			}
+		}
+	}
+
+	if depth != 0 {
+		return "unbalanced parentheses"
+	}
+
+	return ""
+}
@@ -0,0 +1,47 @@
+package migrate
+
+import "runtime/debug"
+
+// modulePath is the module path of this library.
+const modulePath = "github.com/seeruk/go-migrate"
+
+// libraryVersion returns the version of this library that the running binary was built with, or
+// "(devel)" if it isn't known.
+func libraryVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "(devel)"
+	}
+
+	if info.Main.Path == modulePath {
+		return info.Main.Version
+	}
+
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			// Local replacements have no version.
+			if dep.Replace != nil && dep.Replace.Version == "" {
+				return "(devel)"
+			}
+
+			if dep.Replace != nil {
+				return dep.Replace.Version
+			}
+
+			return dep.Version
+		}
+	}
+
+	return "(devel)"
+}
+
+// appVersion returns the version of the running binary's main module, or "(devel)" if it isn't
+// known.
+func appVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok || info.Main.Version == "" {
+		return "(devel)"
+	}
+
+	return info.Main.Version
+}